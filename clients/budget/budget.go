@@ -0,0 +1,57 @@
+package budget
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/query"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type Repository struct {
+	q *query.Query
+}
+
+func NewRepository(db *gorm.DB) (*Repository, error) {
+	return &Repository{
+		q: query.Use(db),
+	}, nil
+}
+
+// FindByUserID returns [gorm.ErrRecordNotFound] if the user has no budget.
+func (r *Repository) FindByUserID(ctx context.Context, userID int) (*model.Budget, error) {
+	return r.q.Budget.WithContext(ctx).Where(r.q.Budget.UserID.Eq(userID)).First()
+}
+
+// Save creates item, or replaces the budget of its user.
+func (r *Repository) Save(ctx context.Context, item *model.Budget) error {
+	return r.q.Budget.WithContext(ctx).Save(item)
+}
+
+// FindUsagesByUserIDSince returns usages of the user since day, inclusive, in order of day.
+func (r *Repository) FindUsagesByUserIDSince(ctx context.Context, userID int, day string) ([]*model.Usage, error) {
+	return r.q.Usage.WithContext(ctx).
+		Where(r.q.Usage.UserID.Eq(userID)).
+		Where(r.q.Usage.Day.Gte(day)).
+		Order(r.q.Usage.Day, r.q.Usage.Model, r.q.Usage.Unit).
+		Find()
+}
+
+// AddUsage adds item to the usage of the same user, day, model and unit, creating it if none.
+func (r *Repository) AddUsage(ctx context.Context, item *model.Usage) error {
+	return r.q.Transaction(func(tx *query.Query) error {
+		u := tx.Usage
+		info, err := u.WithContext(ctx).
+			Where(u.UserID.Eq(item.UserID), u.Day.Eq(item.Day), u.Model.Eq(item.Model), u.Unit.Eq(item.Unit)).
+			UpdateSimple(
+				u.PromptTokens.Add(item.PromptTokens),
+				u.CachedTokens.Add(item.CachedTokens),
+				u.CompletionTokens.Add(item.CompletionTokens),
+				u.Cost.Add(item.Cost),
+			)
+		if err != nil || info.RowsAffected > 0 {
+			return err
+		}
+		return u.WithContext(ctx).Create(item)
+	})
+}
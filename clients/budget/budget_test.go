@@ -0,0 +1,70 @@
+//go:build sqlite_fts5
+
+package budget
+
+import (
+	"aiagent/clients/model"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newRepository(t *testing.T) *Repository {
+	db, err := gorm.Open(sqlite.Open("file::memory:?_foreign_keys=on"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1) // each connection opens its own memory database
+	ddl, err := os.ReadFile("../../docs/ddl.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(string(ddl)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO users VALUES (1, 'u', 0)").Error; err != nil {
+		t.Fatal(err)
+	}
+	ret, err := NewRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestRepository_AddUsage(t *testing.T) {
+	r := newRepository(t)
+	ctx := context.Background()
+	usage := func(tokens int64, cost int64, unit string) *model.Usage {
+		return &model.Usage{UserID: 1, Day: "2026-10-17", Model: "m",
+			PromptTokens: tokens, CachedTokens: 0, CompletionTokens: tokens, Cost: cost, Unit: unit}
+	}
+	for _, item := range []*model.Usage{
+		usage(1, 10, "CNY"),
+		usage(2, 20, "CNY"),
+		usage(3, 30, "USD"), // repriced in another unit
+		usage(4, 0, ""),     // not priced
+	} {
+		if err := r.AddUsage(ctx, item); err != nil {
+			t.Fatalf("AddUsage() error = %v", err)
+		}
+	}
+
+	got, err := r.FindUsagesByUserIDSince(ctx, 1, "2026-10-17")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*model.Usage{usage(4, 0, ""), usage(3, 30, "CNY"), usage(3, 30, "USD")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("usages got %+v, want %+v", got, want)
+	}
+}
@@ -18,13 +18,74 @@ func NewRepository(db *gorm.DB) (*Repository, error) {
 }
 
 func (r *Repository) FindBySessionID(ctx context.Context, sessionID int) ([]*model.Chat, error) {
-	return r.q.Chat.WithContext(ctx).Preload(r.q.Chat.Result).Where(r.q.Chat.SessionID.Eq(sessionID)).Find()
+	return r.q.Chat.WithContext(ctx).Preload(r.q.Chat.Steps).Preload(r.q.Chat.Result).Where(r.q.Chat.SessionID.Eq(sessionID)).Find()
+}
+
+func (r *Repository) FindByID(ctx context.Context, id int) (*model.Chat, error) {
+	return r.q.Chat.WithContext(ctx).Preload(r.q.Chat.Steps).Preload(r.q.Chat.Result).Where(r.q.Chat.ID.Eq(id)).First()
+}
+
+// FindLastByIdempotencyKey returns the last chat of the session created by key since the epoch millisecond,
+// [gorm.ErrRecordNotFound] if none.
+func (r *Repository) FindLastByIdempotencyKey(
+	ctx context.Context,
+	sessionID int,
+	key string,
+	since int64,
+) (*model.Chat, error) {
+	return r.q.Chat.WithContext(ctx).
+		Preload(r.q.Chat.Steps).
+		Preload(r.q.Chat.Result).
+		Where(r.q.Chat.SessionID.Eq(sessionID)).
+		Where(r.q.Chat.IdempotencyKey.Eq(key)).
+		Where(r.q.Chat.CreateTime.Gte(since)).
+		Last()
 }
 
 func (r *Repository) Save(ctx context.Context, chat *model.Chat) error {
 	return r.q.Chat.WithContext(ctx).Save(chat)
 }
 
+// SaveResult replaces the result of a chat, which Save leaves as it is once saved.
+func (r *Repository) SaveResult(ctx context.Context, result *model.Result) error {
+	return r.q.Result.WithContext(ctx).Save(result)
+}
+
+// FindChatsWithoutCost returns at most limit chats after the one on afterID, whose results have no cost,
+// in order of ID with their results.
+func (r *Repository) FindChatsWithoutCost(ctx context.Context, afterID int, limit int) ([]*model.Chat, error) {
+	return r.q.Chat.WithContext(ctx).
+		Select(r.q.Chat.ALL).
+		Preload(r.q.Chat.Result).
+		Join(r.q.Result, r.q.Result.ChatID.EqCol(r.q.Chat.ID)).
+		Where(r.q.Chat.ID.Gt(afterID)).
+		Where(r.q.Result.CostNano.IsNull()).
+		Order(r.q.Chat.ID).
+		Limit(limit).
+		Find()
+}
+
+// SaveResultCost saves the cost of result only, leaving what could change meanwhile as it is.
+func (r *Repository) SaveResultCost(ctx context.Context, result *model.Result) error {
+	_, err := r.q.Result.WithContext(ctx).
+		Where(r.q.Result.ID.Eq(result.ID)).
+		UpdateSimple(r.q.Result.CostNano.Value(*result.CostNano), r.q.Result.CostUnit.Value(result.CostUnit))
+	return err
+}
+
 func (r *Repository) FindLastBySessionID(ctx context.Context, sessionID int) (*model.Chat, error) {
 	return r.q.Chat.WithContext(ctx).Where(r.q.Chat.SessionID.Eq(sessionID)).Last()
 }
+
+// Append saves neo and makes it the active chat of its session.
+func (r *Repository) Append(ctx context.Context, neo *model.Chat) error {
+	return r.q.Transaction(func(tx *query.Query) error {
+		if err := tx.Chat.WithContext(ctx).Save(neo); err != nil {
+			return err
+		}
+		_, err := tx.Session.WithContext(ctx).
+			Where(tx.Session.ID.Eq(neo.SessionID)).
+			Update(tx.Session.ActiveChatID, neo.ID)
+		return err
+	})
+}
@@ -0,0 +1,31 @@
+package compaction
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/query"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type Repository struct {
+	q *query.Query
+}
+
+func NewRepository(db *gorm.DB) (*Repository, error) {
+	return &Repository{
+		q: query.Use(db),
+	}, nil
+}
+
+// FindBySessionID returns compactions of the session in order of ID, thus the latest last.
+func (r *Repository) FindBySessionID(ctx context.Context, sessionID int) ([]*model.Compaction, error) {
+	return r.q.Compaction.WithContext(ctx).
+		Where(r.q.Compaction.SessionID.Eq(sessionID)).
+		Order(r.q.Compaction.ID).
+		Find()
+}
+
+func (r *Repository) Save(ctx context.Context, item *model.Compaction) error {
+	return r.q.Compaction.WithContext(ctx).Save(item)
+}
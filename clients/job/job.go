@@ -0,0 +1,42 @@
+package job
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/query"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type Repository struct {
+	q *query.Query
+}
+
+func NewRepository(db *gorm.DB) (*Repository, error) {
+	return &Repository{
+		q: query.Use(db),
+	}, nil
+}
+
+func (r *Repository) Save(ctx context.Context, item *model.Job) error {
+	return r.q.Job.WithContext(ctx).Save(item)
+}
+
+func (r *Repository) FindByID(ctx context.Context, id int) (*model.Job, error) {
+	return r.q.Job.WithContext(ctx).Where(r.q.Job.ID.Eq(id)).First()
+}
+
+func (r *Repository) FindByUserIDAndID(ctx context.Context, userID int, id int) (*model.Job, error) {
+	return r.q.Job.WithContext(ctx).
+		Where(r.q.Job.UserID.Eq(userID)).
+		Where(r.q.Job.ID.Eq(id)).
+		First()
+}
+
+// FindByState returns jobs in state in order of ID, thus the earliest first.
+func (r *Repository) FindByState(ctx context.Context, state model.JobState) ([]*model.Job, error) {
+	return r.q.Job.WithContext(ctx).
+		Where(r.q.Job.State.Eq(string(state))).
+		Order(r.q.Job.ID).
+		Find()
+}
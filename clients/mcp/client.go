@@ -0,0 +1,172 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// transport moves JSON-RPC messages between client and server.
+type transport interface {
+	// roundTrip sends a request and waits for its response.
+	roundTrip(ctx context.Context, request *message) (response *message, err error)
+	// notify sends a notification, which has no response.
+	notify(ctx context.Context, notification *message) error
+	close() error
+}
+
+// Client talks to one MCP server. Use [Dial] to get an initialized one.
+type Client struct {
+	name      string
+	transport transport
+	nextID    atomic.Int64
+	server    InitializeResult
+}
+
+func newClient(name string, t transport) *Client {
+	return &Client{name: name, transport: t}
+}
+
+// Name is how the server is called in [Config], not what it calls itself.
+func (c *Client) Name() string {
+	return c.name
+}
+
+func (c *Client) Server() InitializeResult {
+	return c.server
+}
+
+func (c *Client) Close() error {
+	return c.transport.close()
+}
+
+func call[ResultType any](ctx context.Context, c *Client, method string, params any) (*ResultType, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := c.transport.roundTrip(ctx, &message{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10)),
+		Method:  method,
+		Params:  data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", c.name, method, err)
+	}
+	if rsp.Error != nil {
+		return nil, fmt.Errorf("%s %s: %w", c.name, method, rsp.Error)
+	}
+	var ret ResultType
+	if err := json.Unmarshal(rsp.Result, &ret); err != nil {
+		return nil, fmt.Errorf("%s %s decode result: %w", c.name, method, err)
+	}
+	return &ret, nil
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	result, err := call[InitializeResult](ctx, c, "initialize", InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]any{}, // neither roots, sampling nor elicitation is supported
+		ClientInfo:      Implementation{Name: "aiagent", Version: "dev"},
+	})
+	if err != nil {
+		return err
+	}
+	c.server = *result
+	return c.transport.notify(ctx, &message{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// listAll follows nextCursor until the end, as the server may paginate.
+func listAll[ResultType any, ItemType any](
+	ctx context.Context,
+	c *Client,
+	method string,
+	extract func(*ResultType) (items []ItemType, nextCursor string),
+) ([]ItemType, error) {
+	var ret []ItemType
+	var cursor string
+	for {
+		result, err := call[ResultType](ctx, c, method, paginatedParams{Cursor: cursor})
+		if err != nil {
+			return nil, err
+		}
+		items, next := extract(result)
+		ret = append(ret, items...)
+		if next == "" {
+			return ret, nil
+		}
+		cursor = next
+	}
+}
+
+// ListTools returns nothing if the server does not have the capability.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	if c.server.Capabilities.Tools == nil {
+		return nil, nil
+	}
+	return listAll(ctx, c, "tools/list", func(r *listToolsResult) ([]Tool, string) {
+		return r.Tools, r.NextCursor
+	})
+}
+
+// ListResources returns nothing if the server does not have the capability.
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+	if c.server.Capabilities.Resources == nil {
+		return nil, nil
+	}
+	return listAll(ctx, c, "resources/list", func(r *listResourcesResult) ([]Resource, string) {
+		return r.Resources, r.NextCursor
+	})
+}
+
+// ListPrompts returns nothing if the server does not have the capability.
+func (c *Client) ListPrompts(ctx context.Context) ([]Prompt, error) {
+	if c.server.Capabilities.Prompts == nil {
+		return nil, nil
+	}
+	return listAll(ctx, c, "prompts/list", func(r *listPromptsResult) ([]Prompt, string) {
+		return r.Prompts, r.NextCursor
+	})
+}
+
+// CallTool returns a result with IsError as a failure reported by the tool, rather than an error.
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (*CallToolResult, error) {
+	return call[CallToolResult](ctx, c, "tools/call", callToolParams{Name: name, Arguments: arguments})
+}
+
+func (c *Client) ReadResource(ctx context.Context, uri string) (*ReadResourceResult, error) {
+	return call[ReadResourceResult](ctx, c, "resources/read", readResourceParams{URI: uri})
+}
+
+func (c *Client) GetPrompt(ctx context.Context, name string, arguments map[string]string) (*GetPromptResult, error) {
+	return call[GetPromptResult](ctx, c, "prompts/get", getPromptParams{Name: name, Arguments: arguments})
+}
+
+// Dial connects to the server as sc describes and initializes the session.
+func Dial(ctx context.Context, name string, sc ServerConfig) (*Client, error) {
+	var t transport
+	var err error
+	switch {
+	case sc.Command != "" && sc.URL != "":
+		return nil, fmt.Errorf("server %s has both command and url", name)
+	case sc.Command != "":
+		t, err = newStdioTransport(sc.Command, sc.Args, sc.Env)
+	case sc.URL != "":
+		t = newHTTPTransport(sc.URL, sc.Headers)
+	default:
+		return nil, fmt.Errorf("server %s has neither command nor url", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("server %s: %w", name, err)
+	}
+
+	c := newClient(name, t)
+	if err := c.initialize(ctx); err != nil {
+		return nil, errors.Join(err, c.Close())
+	}
+	return c, nil
+}
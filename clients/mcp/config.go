@@ -0,0 +1,33 @@
+package mcp
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Config follows the mcpServers convention shared by many MCP hosts,
+// so that a team could copy their existing config here.
+type Config struct {
+	Servers map[string]ServerConfig `json:"mcpServers"`
+}
+
+// ServerConfig is either a stdio server by Command or a streamable HTTP server by URL.
+type ServerConfig struct {
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"` // appended to that of aiagent
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret Config
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
@@ -0,0 +1,169 @@
+package mcp
+
+import (
+	"aiagent/helpers/closer"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// httpTransport speaks the streamable HTTP transport, where each message is POSTed,
+// and the response comes either as a JSON body or in a text/event-stream body.
+//
+// The optional GET stream for server-initiated messages is not opened, as we have no use of them.
+type httpTransport struct {
+	url     string
+	headers map[string]string
+
+	muSession *sync.Mutex
+	sessionID string // assigned by server in the response of initialize, if it's stateful
+}
+
+func newHTTPTransport(url string, headers map[string]string) *httpTransport {
+	return &httpTransport{
+		url:       url,
+		headers:   headers,
+		muSession: &sync.Mutex{},
+		sessionID: "",
+	}
+}
+
+func (t *httpTransport) post(ctx context.Context, m *message) (*http.Response, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	t.decorate(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.muSession.Lock()
+		t.sessionID = id
+		t.muSession.Unlock()
+	}
+	if resp.StatusCode/100 != 2 {
+		payload, _ := io.ReadAll(resp.Body)
+		closer.CloseAndWarnIfFail(resp.Body)
+		return nil, fmt.Errorf("unexpected status %v body %s", resp.Status, string(payload))
+	}
+	return resp, nil
+}
+
+func (t *httpTransport) decorate(req *http.Request) {
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("MCP-Protocol-Version", ProtocolVersion)
+	t.muSession.Lock()
+	defer t.muSession.Unlock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, request *message) (*message, error) {
+	resp, err := t.post(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.CloseAndWarnIfFail(resp.Body)
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case "application/json":
+		var ret message
+		if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	case "text/event-stream":
+		return findResponseInStream(resp.Body, request.ID)
+	default:
+		return nil, fmt.Errorf("unexpected content type %s", mediaType)
+	}
+}
+
+// findResponseInStream reads events until the response of id,
+// messages from server in between are ignored, as they are only notifications we have no interest.
+func findResponseInStream(body io.Reader, id json.RawMessage) (*message, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, 16*1024*1024) // a large tool result comes in one data line
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" {
+			continue // event, id, retry and comments are not used
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var m message
+		err := json.Unmarshal([]byte(strings.Join(data, "\n")), &m)
+		data = nil
+		if err != nil {
+			return nil, err
+		}
+		if m.isResponse() && bytes.Equal(m.ID, id) {
+			return &m, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("stream ended without response of id %s", string(id))
+}
+
+func (t *httpTransport) notify(ctx context.Context, notification *message) error {
+	resp, err := t.post(ctx, notification)
+	if err != nil {
+		return err
+	}
+	closer.CloseAndWarnIfFail(resp.Body)
+	return nil
+}
+
+// close terminates the session on server if there is one.
+func (t *httpTransport) close() error {
+	t.muSession.Lock()
+	sessionID := t.sessionID
+	t.muSession.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	t.decorate(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	closer.CloseAndWarnIfFail(resp.Body)
+	// 405 is allowed by spec as the server does not let clients terminate sessions.
+	return nil
+}
@@ -0,0 +1,210 @@
+package mcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// standInEnv makes the test binary itself a stdio MCP server, as the way os/exec tests its helper process.
+const standInEnv = "AIAGENT_MCP_STAND_IN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(standInEnv) == "1" {
+		serveStandIn()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// serveStandIn answers just enough of the protocol, with tools on the first page and resources on the second.
+func serveStandIn() {
+	reader := bufio.NewReader(os.Stdin)
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var m message
+		if err := json.Unmarshal(line, &m); err != nil {
+			panic(err)
+		}
+		if len(m.ID) == 0 {
+			continue // notifications
+		}
+		reply := message{JSONRPC: "2.0", ID: m.ID}
+		var result any
+		switch m.Method {
+		case "initialize":
+			result = InitializeResult{
+				ProtocolVersion: ProtocolVersion,
+				Capabilities: ServerCapabilities{
+					Tools:     json.RawMessage("{}"),
+					Resources: json.RawMessage("{}"),
+					Prompts:   nil,
+				},
+				ServerInfo: Implementation{Name: "stand-in", Version: "0"},
+			}
+		case "tools/list":
+			var p paginatedParams
+			_ = json.Unmarshal(m.Params, &p)
+			if p.Cursor == "" {
+				result = listToolsResult{Tools: []Tool{{Name: "echo", InputSchema: json.RawMessage(`{}`)}}, NextCursor: "2"}
+			} else {
+				result = listToolsResult{Tools: []Tool{{Name: "fail", InputSchema: json.RawMessage(`{}`)}}}
+			}
+		case "resources/list":
+			result = listResourcesResult{Resources: []Resource{{URI: "mem://a", Name: "a"}}}
+		case "tools/call":
+			var p callToolParams
+			_ = json.Unmarshal(m.Params, &p)
+			result = CallToolResult{
+				Content: []Content{{Type: "text", Text: fmt.Sprintf("%s %s", p.Name, p.Arguments)}},
+				IsError: p.Name == "fail",
+			}
+		default:
+			reply.Error = &Error{Code: codeMethodNotFound, Message: m.Method}
+		}
+		if result != nil {
+			reply.Result, _ = json.Marshal(result)
+		}
+		if err := encoder.Encode(reply); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestStdioStandIn(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Dial(t.Context(), "local", ServerConfig{
+		Command: exe,
+		Env:     map[string]string{standInEnv: "1"},
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}()
+
+	if c.Server().ServerInfo.Name != "stand-in" {
+		t.Errorf("Server() got %+v", c.Server())
+	}
+
+	tools, err := c.ListTools(t.Context())
+	if err != nil {
+		t.Fatalf("ListTools() error = %v", err)
+	}
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"echo", "fail"}) {
+		t.Errorf("ListTools() paginated got %v", names)
+	}
+
+	resources, err := c.ListResources(t.Context())
+	if err != nil || len(resources) != 1 {
+		t.Errorf("ListResources() got %v error = %v", resources, err)
+	}
+	prompts, err := c.ListPrompts(t.Context())
+	if err != nil || prompts != nil {
+		t.Errorf("ListPrompts() without capability got %v error = %v", prompts, err)
+	}
+
+	result, err := c.CallTool(t.Context(), "echo", json.RawMessage(`{"x":1}`))
+	if err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+	if result.IsError || result.Content[0].Text != `echo {"x":1}` {
+		t.Errorf("CallTool() got %+v", result)
+	}
+
+	if _, err := c.ReadResource(t.Context(), "mem://a"); err == nil {
+		t.Error("ReadResource() on an unsupported method want error got nil")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var mu sync.Mutex
+	var sessionIDs []string // sent by the client, by method
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			mu.Lock()
+			sessionIDs = append(sessionIDs, "DELETE "+r.Header.Get("Mcp-Session-Id"))
+			mu.Unlock()
+			return
+		}
+		var m message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Errorf("decode request: %v", err)
+			return
+		}
+		mu.Lock()
+		sessionIDs = append(sessionIDs, m.Method+" "+r.Header.Get("Mcp-Session-Id"))
+		mu.Unlock()
+		if len(m.ID) == 0 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		reply := message{JSONRPC: "2.0", ID: m.ID}
+		switch m.Method {
+		case "initialize":
+			reply.Result, _ = json.Marshal(InitializeResult{
+				ProtocolVersion: ProtocolVersion,
+				Capabilities:    ServerCapabilities{Tools: json.RawMessage("{}")},
+				ServerInfo:      Implementation{Name: "remote", Version: "0"},
+			})
+			w.Header().Set("Mcp-Session-Id", "s1")
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_ = json.NewEncoder(w).Encode(reply)
+		case "tools/list":
+			// The response comes after a notification, in a data line split in two.
+			reply.Result, _ = json.Marshal(listToolsResult{Tools: []Tool{{Name: "echo", InputSchema: json.RawMessage(`{}`)}}})
+			data, _ := json.MarshalIndent(reply, "", "")
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprint(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+			first, rest, _ := strings.Cut(string(data), "\n")
+			_, _ = fmt.Fprintf(w, ": comment\nid: 1\ndata: %s\ndata: %s\n\n", first, strings.ReplaceAll(rest, "\n", ""))
+		default:
+			http.Error(w, "unexpected "+m.Method, http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := Dial(t.Context(), "remote", ServerConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if c.Server().ServerInfo.Name != "remote" {
+		t.Errorf("Server() got %+v", c.Server())
+	}
+	tools, err := c.ListTools(t.Context())
+	if err != nil || len(tools) != 1 || tools[0].Name != "echo" {
+		t.Errorf("ListTools() in event stream got %v error = %v", tools, err)
+	}
+	if _, err := c.ListResources(t.Context()); err != nil {
+		t.Errorf("ListResources() without capability error = %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	want := []string{"initialize ", "notifications/initialized s1", "tools/list s1", "DELETE s1"}
+	if !reflect.DeepEqual(sessionIDs, want) {
+		t.Errorf("session IDs sent got %q, want %q", sessionIDs, want)
+	}
+}
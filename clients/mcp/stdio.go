@@ -0,0 +1,193 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// stdioTransport runs the server as a subprocess, exchanging newline delimited JSON over its stdin and stdout.
+type stdioTransport struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	muWrite *sync.Mutex // Guard a message is written as a whole line.
+
+	muPending *sync.Mutex
+	pending   map[string]chan *message // by ID of requests waiting for response
+	closed    error                    // why the reader stopped, nil if it's alive
+}
+
+func newStdioTransport(command string, args []string, env map[string]string) (*stdioTransport, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = os.Stderr // As the spec allows servers log there, pass them to ours.
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	ret := &stdioTransport{
+		cmd:       cmd,
+		stdin:     stdin,
+		muWrite:   &sync.Mutex{},
+		muPending: &sync.Mutex{},
+		pending:   make(map[string]chan *message),
+		closed:    nil,
+	}
+	go ret.read(stdout)
+	return ret, nil
+}
+
+func (t *stdioTransport) write(m *message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// The spec forbids embedded newlines, which json.Marshal never produces as it escapes them.
+	data = append(data, '\n')
+
+	t.muWrite.Lock()
+	defer t.muWrite.Unlock()
+	_, err = t.stdin.Write(data)
+	return err
+}
+
+func (t *stdioTransport) read(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	var err error
+	for {
+		var line []byte
+		// Not bufio.Scanner, whose token size limit is easily exceeded by a large tool result.
+		line, err = reader.ReadBytes('\n')
+		if len(line) > 0 {
+			t.dispatch(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = errors.New("server closed stdout")
+	}
+
+	t.muPending.Lock()
+	defer t.muPending.Unlock()
+	t.closed = err
+	for id, ch := range t.pending {
+		close(ch)
+		delete(t.pending, id)
+	}
+}
+
+func (t *stdioTransport) dispatch(line []byte) {
+	var m message
+	if err := json.Unmarshal(line, &m); err != nil {
+		slog.Warn("mcp stdio bad line", "line", string(line), "err", err)
+		return
+	}
+	if m.isResponse() {
+		t.muPending.Lock()
+		ch, ok := t.pending[string(m.ID)]
+		delete(t.pending, string(m.ID))
+		t.muPending.Unlock()
+		if !ok {
+			slog.Warn("mcp stdio unmatched response", "id", string(m.ID))
+			return
+		}
+		ch <- &m // buffered, never blocks
+		return
+	}
+	if len(m.ID) == 0 {
+		slog.Debug("mcp stdio notification", "method", m.Method)
+		return
+	}
+	// A request from the server. We declared no capability, so only ping is expected.
+	reply := &message{JSONRPC: "2.0", ID: m.ID}
+	if m.Method == "ping" {
+		reply.Result = json.RawMessage("{}")
+	} else {
+		reply.Error = &Error{Code: codeMethodNotFound, Message: "unsupported " + m.Method}
+	}
+	if err := t.write(reply); err != nil {
+		slog.Warn("mcp stdio reply server request", "method", m.Method, "err", err)
+	}
+}
+
+func (t *stdioTransport) roundTrip(ctx context.Context, request *message) (*message, error) {
+	ch := make(chan *message, 1)
+	t.muPending.Lock()
+	if closed := t.closed; closed != nil {
+		t.muPending.Unlock()
+		return nil, closed
+	}
+	t.pending[string(request.ID)] = ch
+	t.muPending.Unlock()
+
+	if err := t.write(request); err != nil {
+		t.forget(request.ID)
+		return nil, err
+	}
+
+	select {
+	case rsp, ok := <-ch:
+		if !ok {
+			t.muPending.Lock()
+			closed := t.closed
+			t.muPending.Unlock()
+			return nil, fmt.Errorf("no response as transport closed: %w", closed)
+		}
+		return rsp, nil
+	case <-ctx.Done():
+		t.forget(request.ID)
+		// Best effort to let the server stop working on it.
+		params, _ := json.Marshal(map[string]any{"requestId": request.ID, "reason": ctx.Err().Error()})
+		_ = t.write(&message{JSONRPC: "2.0", Method: "notifications/cancelled", Params: params})
+		return nil, ctx.Err()
+	}
+}
+
+func (t *stdioTransport) forget(id json.RawMessage) {
+	t.muPending.Lock()
+	defer t.muPending.Unlock()
+	delete(t.pending, string(id))
+}
+
+func (t *stdioTransport) notify(_ context.Context, notification *message) error {
+	return t.write(notification)
+}
+
+// close follows the spec, closing stdin and waiting the server to exit, kill it if it won't.
+func (t *stdioTransport) close() error {
+	err := t.stdin.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- t.cmd.Wait()
+	}()
+	select {
+	case e := <-done:
+		return errors.Join(err, e)
+	case <-time.After(stdioCloseTimeout):
+		return errors.Join(err, t.cmd.Process.Kill(), <-done)
+	}
+}
+
+const stdioCloseTimeout = 2 * time.Second
@@ -13,8 +13,9 @@ func main() {
 		ModelPkgPath: "",
 		Mode:         gen.WithoutContext | gen.WithDefaultQuery | gen.WithQueryInterface,
 	})
-	g.ApplyBasic(model.Session{}, model.User{})
+	g.ApplyBasic(model.Session{}, model.User{}, model.Preset{}, model.Compaction{}, model.Job{})
 
-	g.ApplyBasic(model.Chat{}, model.Result{})
+	g.ApplyBasic(model.Chat{}, model.Result{}, model.Step{})
+	g.ApplyBasic(model.Budget{}, model.Usage{})
 	g.Execute()
 }
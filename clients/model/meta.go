@@ -16,10 +16,35 @@ type SessionChatQuery[T any] interface {
 	FindChatPartByUserID(userID int) ([]ChatPart, error)
 }
 
+//goland:noinspection GoCommentStart
+type SearchQuery[T any] interface {
+	// SELECT sessions.scoped_id AS session_scoped_id, sessions.name AS session_name, hits.chat_id, hits.source, hits.snippet
+	// FROM (SELECT chats_fts.rowid AS chat_id, 'input' AS source,
+	//              snippet(chats_fts, 0, '**', '**', '...', 64) AS snippet, chats_fts.rank AS rank
+	//       FROM chats_fts
+	//       WHERE chats_fts MATCH @match
+	//       UNION ALL
+	//       SELECT results.chat_id, 'content', snippet(results_fts, 0, '**', '**', '...', 64), results_fts.rank
+	//       FROM results_fts
+	//       JOIN results ON results.id = results_fts.rowid
+	//       WHERE results_fts MATCH @match) AS hits
+	// JOIN chats ON chats.id = hits.chat_id
+	// JOIN sessions ON sessions.id = chats.session_id
+	// WHERE sessions.user_id = @userID
+	// ORDER BY hits.rank
+	// LIMIT @limit;
+	FindHitsByUserID(match string, userID int, limit int) ([]SearchHit, error)
+}
+
 type Session struct {
-	ID       int `json:"-"`
-	Name     string
-	UserID   int
-	ScopedID int
-	Chats    []*Chat `gorm:"foreignkey:SessionID"`
+	ID           int `json:"-"`
+	Name         string
+	UserID       int
+	ScopedID     int
+	SystemPrompt string // empty for none
+	ActiveChatID *int   // the end of the branch History follows, nil if no chat
+	// Where the session is forked from, nil if it's not a fork.
+	OriginSessionID *int `json:"-"`
+	OriginChatID    *int
+	Chats           []*Chat `gorm:"foreignkey:SessionID"`
 }
@@ -9,6 +9,10 @@ package model
 
 import (
 	"aiagent/clients/openai"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
@@ -77,26 +81,102 @@ func DigestSessionName(name string) (t time.Time, generatedFromTime bool) {
 	return t, err == nil
 }
 
-func (s *Session) History() []openai.Message {
+// History returns messages of chats on the path, partial answers included only if partial is set.
+func (s *Session) History(partial bool) []openai.Message {
 	var ret []openai.Message
-	for _, chat := range s.Chats {
-		c := chat.Chat()
-		if !c.Valid() {
+	if s.SystemPrompt != "" {
+		ret = append(ret, openai.NewSystemMessage(s.SystemPrompt))
+	}
+	for _, chat := range s.Path() {
+		if !chat.InHistory(partial) {
 			continue
 		}
-		ret = append(ret, c.HistoryRecords()...)
+		ret = append(ret, chat.Chat().HistoryRecords()...)
+	}
+	return ret
+}
+
+// FindChat returns the chat on id in s, nil if none.
+func (s *Session) FindChat(id int) *Chat {
+	for _, chat := range s.Chats {
+		if chat.ID == id {
+			return chat
+		}
+	}
+	return nil
+}
+
+// Path returns chats from the first turn to the active one, which is the branch History follows.
+func (s *Session) Path() []*Chat {
+	if s.ActiveChatID == nil {
+		return nil
+	}
+	return s.PathTo(*s.ActiveChatID)
+}
+
+// PathTo returns chats from the first turn to the one on id.
+func (s *Session) PathTo(id int) []*Chat {
+	var ret []*Chat
+	for next := &id; next != nil; {
+		chat := s.FindChat(*next)
+		if chat == nil {
+			// A chat only follows one of the same session, and chats are never deleted.
+			panic(fmt.Errorf("chat %d is not in session %d", *next, s.ID))
+		}
+		ret = append(ret, chat)
+		next = chat.ParentID
 	}
+	slices.Reverse(ret)
 	return ret
 }
 
+// Leaves returns chats which no chat follows, in order of ID, each is the end of a branch.
+func (s *Session) Leaves() []*Chat {
+	followed := make(map[int]bool)
+	for _, chat := range s.Chats {
+		if chat.ParentID != nil {
+			followed[*chat.ParentID] = true
+		}
+	}
+	var ret []*Chat
+	for _, chat := range s.Chats {
+		if !followed[chat.ID] {
+			ret = append(ret, chat)
+		}
+	}
+	return ret
+}
+
+// Chat is a turn in a [Session], chats of a session form a tree by ParentID.
+// Unlike that of a session, ID of a chat is exposed, as clients navigate the tree by it.
 type Chat struct {
 	ChatPart
-	Input  string
-	Result *Result `gorm:"foreignkey:ChatID"`
+	ParentID *int // the previous turn, nil for the first one
+	Input    string
+	Steps    []*Step `gorm:"foreignkey:ChatID"`
+	Result   *Result `gorm:"foreignkey:ChatID"`
+	// IdempotencyKey is of the request creating it, nil if none, so that a retried one gets it rather than a duplicate.
+	IdempotencyKey *string `json:"-"`
+	// RequestDigest is of the request with IdempotencyKey, nil if none,
+	// so that the key reused by another request is rejected rather than getting it.
+	RequestDigest *string `json:"-"`
+}
+
+// InHistory returns whether c is sent in history, which a partial answer is only if partial is set.
+// Those with nothing answered are never, as an empty assistant message is rejected by some upstreams.
+func (c *Chat) InHistory(partial bool) bool {
+	switch {
+	case c.Result == nil:
+		return false
+	case c.Result.Partial():
+		return partial && c.Result.Content != ""
+	default:
+		return true
+	}
 }
 
 type ChatPart struct {
-	ID         int `json:"-"`
+	ID         int
 	SessionID  int `json:"-"`
 	CreateTime int64
 }
@@ -105,10 +185,112 @@ func (c *Chat) Chat() *openai.Chat {
 	return &openai.Chat{
 		Input:   c.Input,
 		Created: time.UnixMilli(c.CreateTime),
+		Steps:   StepMessages(c.Steps),
 		Result:  c.Result.ChatCompletion(),
 	}
 }
 
+// Step is an intermediate message of a [Chat] between its Input and its final [Result],
+// typically an assistant message calling tools or a tool message replying one.
+// Steps in a Chat are ordered by ID.
+type Step struct {
+	ID               int `json:"-"`
+	ChatID           int `json:"-"`
+	Role             string
+	Content          string
+	ReasoningContent string
+	ToolCalls        ToolCalls
+	ToolCallID       string
+	// ReasoningSignature is kept to pass ReasoningContent back in tool calling, see [openai.Message].
+	ReasoningSignature string `json:"-"`
+}
+
+func NewSteps(messages []openai.Message) []*Step {
+	var ret []*Step
+	for _, m := range messages {
+		ret = append(ret, &Step{
+			ID:                 0, // leave null for generated PK
+			ChatID:             0, // leave null for FK fulfilling
+			Role:               m.Role,
+			Content:            m.Content,
+			ReasoningContent:   m.ReasoningContent,
+			ToolCalls:          m.ToolCalls,
+			ToolCallID:         m.ToolCallID,
+			ReasoningSignature: m.ReasoningSignature,
+		})
+	}
+	return ret
+}
+
+func StepMessages(steps []*Step) []openai.Message {
+	var ret []openai.Message
+	for _, step := range steps {
+		ret = append(ret, openai.Message{
+			Role:               step.Role,
+			Content:            step.Content,
+			ReasoningContent:   step.ReasoningContent,
+			ToolCalls:          step.ToolCalls,
+			ToolCallID:         step.ToolCallID,
+			ReasoningSignature: step.ReasoningSignature,
+		})
+	}
+	return ret
+}
+
+// ToolCalls is stored as a JSON array in TEXT, as nobody queries inside it yet.
+type ToolCalls []openai.ToolCall
+
+func (tc ToolCalls) Value() (driver.Value, error) {
+	data, err := json.Marshal(tc)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (tc *ToolCalls) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case nil:
+		*tc = nil
+		return nil
+	default:
+		return fmt.Errorf("scan ToolCalls from unexpected type %T", src)
+	}
+	return json.Unmarshal(data, tc)
+}
+
+// Strings is stored as a JSON array in TEXT.
+type Strings []string
+
+func (s Strings) Value() (driver.Value, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (s *Strings) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case nil:
+		*s = nil
+		return nil
+	default:
+		return fmt.Errorf("scan Strings from unexpected type %T", src)
+	}
+	return json.Unmarshal(data, s)
+}
+
 type Result struct {
 	ID                int `json:"-"`
 	ChatID            int `json:"-"`
@@ -127,9 +309,72 @@ type Result struct {
 	CachedTokens         int
 	ReasoningTokens      int
 	PromptCacheHitTokens int
+
+	// What the request asked for, so that the result could be reproduced.
+	// Nil ones are upstream default, which are not stored as they may change.
+	ReasoningEffort  openai.ReasoningEffort
+	Temperature      *float64
+	TopP             *float64
+	MaxTokens        *int
+	Stop             Strings
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	Seed             *int
+
+	Status ResultStatus
+
+	// CostNano is of the tokens above in billionths of CostUnit, rather than its minor unit such as cents,
+	// as a chat could cost less than one. It's by the price of [Result.PricedModel] effective when its chat
+	// is created, as prices change later. Nil for those saved before costs are stored,
+	// until they are backfilled, and for those whose model is not priced then.
+	CostNano *int64
+	CostUnit string // ISO 4217, empty if CostNano is nil
+
+	// Route is the model asked for in the routing table, which upstream may name differently as Model,
+	// such as a dated one or a local tag. Empty for those saved before it's stored.
+	Route openai.ChatModel
+}
+
+// PricedModel returns the model r is priced by, the route or Model as upstream names it if that's not stored.
+func (r *Result) PricedModel() openai.ChatModel {
+	if r.Route != "" {
+		return r.Route
+	}
+	return r.Model
+}
+
+// ResultStatus tells whether a [Result] is a complete answer, or a partial one kept for what's been generated.
+type ResultStatus string
+
+const (
+	ResultStatusComplete      ResultStatus = "complete"
+	ResultStatusLength        ResultStatus = "length"      // cut by max_tokens or the context window
+	ResultStatusInterrupted   ResultStatus = "interrupted" // the stream ended without a finish reason
+	ResultStatusUpstreamError ResultStatus = "upstream_error"
+	ResultStatusCancelled     ResultStatus = "cancelled" // aborted by the client, never from a finish reason
+)
+
+// NewResultStatus returns the status by finishReason, which is empty if the stream ended early.
+func NewResultStatus(finishReason string) ResultStatus {
+	switch finishReason {
+	case openai.FinishReasonStop:
+		return ResultStatusComplete
+	case openai.FinishReasonLength:
+		return ResultStatusLength
+	case "":
+		return ResultStatusInterrupted
+	default:
+		// content_filter and insufficient_system_resource, which upstream gives up.
+		return ResultStatusUpstreamError
+	}
 }
 
-func NewResult(cc *openai.ChatCompletion) *Result {
+// Partial returns whether r is not a complete answer.
+func (r *Result) Partial() bool {
+	return r.Status != ResultStatusComplete
+}
+
+func NewResult(cc *openai.ChatCompletion, effort openai.ReasoningEffort, sampling openai.Sampling) *Result {
 	return &Result{
 		ID:                   0, // leave null for generated PK
 		ChatID:               0, // leave null for FK fulfilling
@@ -146,6 +391,18 @@ func NewResult(cc *openai.ChatCompletion) *Result {
 		CachedTokens:         cc.Usage.PromoteTokensDetails.CachedTokens,
 		ReasoningTokens:      cc.Usage.CompletionTokensDetails.ReasoningTokens,
 		PromptCacheHitTokens: cc.Usage.PromptCacheHitTokens,
+		ReasoningEffort:      effort,
+		Temperature:          sampling.Temperature,
+		TopP:                 sampling.TopP,
+		MaxTokens:            sampling.MaxTokens,
+		Stop:                 sampling.Stop,
+		PresencePenalty:      sampling.PresencePenalty,
+		FrequencyPenalty:     sampling.FrequencyPenalty,
+		Seed:                 sampling.Seed,
+		Status:               NewResultStatus(cc.Choices[0].FinishReason),
+		CostNano:             nil, // priced when it's saved
+		CostUnit:             "",
+		Route:                "", // known by the caller only
 	}
 }
 
@@ -181,8 +438,96 @@ func (r *Result) ChatCompletion() *openai.ChatCompletion {
 	}
 }
 
+// Compaction is a summary of chats on the path to the one on ChatID, which replaces them in history.
+// It's reused while the chat is on the active path, until the history grows too long again.
+type Compaction struct {
+	ID         int
+	SessionID  int
+	ChatID     int
+	Summary    string
+	CreateTime int64
+}
+
+// Preset is a named system prompt of a user, which could be applied when creating a session.
+// Name is unique per user.
+type Preset struct {
+	ID           int
+	UserID       int `json:"-"`
+	Name         string
+	SystemPrompt string
+}
+
+// Job is a chat generated in the background, which is polled by ID or told to CallbackURL once it's over.
+type Job struct {
+	ID          int
+	UserID      int  `json:"-"`
+	SessionID   int  `json:"-"`
+	ChatID      *int // the chat generated, nil until it's over or if nothing is saved
+	State       JobState
+	Payload     string `json:"-"` // JSON of the chat request
+	CallbackURL string // empty for none
+	Error       string // why it's failed, empty otherwise
+	CreateTime  int64
+	UpdateTime  int64
+}
+
+// JobState goes from queued to running, and ends in done or failed.
+type JobState string
+
+const (
+	JobStateQueued  JobState = "queued"
+	JobStateRunning JobState = "running"
+	JobStateDone    JobState = "done"
+	JobStateFailed  JobState = "failed"
+)
+
+// Over returns whether j has ended in done or failed.
+func (j *Job) Over() bool {
+	return j.State == JobStateDone || j.State == JobStateFailed
+}
+
+// Budget limits what a user spends in a day and in a month, in local time of the server. Zero is no limit.
+// Costs are in billionths of Unit, only those priced in Unit count.
+type Budget struct {
+	UserID        int `gorm:"primaryKey"`
+	DailyTokens   int64
+	MonthlyTokens int64
+	DailyCost     int64
+	MonthlyCost   int64
+	Unit          string // ISO 4217 currency, such as CNY, empty if no cost is limited
+}
+
+// Usage is what a user has spent on a model in a day in a unit, accumulated from each saved [Result].
+// A model repriced in another unit that day spends in another usage, as costs in different units never add up.
+type Usage struct {
+	UserID           int    `gorm:"primaryKey" json:"-"`
+	Day              string `gorm:"primaryKey"` // in [UsageDayLayout] of local time of the server
+	Model            string `gorm:"primaryKey"`
+	PromptTokens     int64
+	CachedTokens     int64
+	CompletionTokens int64
+	Cost             int64  // in billionths of Unit, by the price when it's spent
+	Unit             string `gorm:"primaryKey"` // ISO 4217 currency, empty if the model is not priced
+}
+
+const UsageDayLayout = time.DateOnly
+
+// Tokens returns all tokens u has spent, which a budget limits.
+func (u *Usage) Tokens() int64 {
+	return u.PromptTokens + u.CompletionTokens
+}
+
 type User struct {
 	ID               int
 	Nickname         string
 	SessionsSequence int
 }
+
+// SearchHit is where a chat matches a full-text search, in the session it belongs to.
+type SearchHit struct {
+	SessionScopedID int
+	SessionName     string
+	ChatID          int
+	Source          string // input of the chat, or content of its result
+	Snippet         string // around what matches, which is marked by ** on both sides
+}
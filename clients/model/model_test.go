@@ -1,6 +1,10 @@
 package model
 
-import "testing"
+import (
+	"aiagent/clients/openai"
+	"slices"
+	"testing"
+)
 
 func TestSession_WeakName(t *testing.T) {
 	tests := []struct {
@@ -23,3 +27,106 @@ func TestSession_WeakName(t *testing.T) {
 		})
 	}
 }
+
+func TestSession_History(t *testing.T) {
+	ptr := func(id int) *int { return &id }
+	// 1 complete - 2 cut by length - 3 failed with nothing answered
+	chats := []*Chat{
+		{ChatPart: ChatPart{ID: 1}, Input: "a", Result: &Result{Role: "assistant", Content: "ok", Status: ResultStatusComplete}},
+		{ChatPart: ChatPart{ID: 2}, ParentID: ptr(1), Input: "b", Result: &Result{Role: "assistant", Content: "o", Status: ResultStatusLength}},
+		{ChatPart: ChatPart{ID: 3}, ParentID: ptr(2), Input: "c", Result: &Result{Status: ResultStatusUpstreamError}},
+	}
+	tests := []struct {
+		name         string
+		systemPrompt string
+		active       *int
+		partial      bool
+		wantRoles    []string
+	}{
+		{"none", "", nil, false, nil},
+		{"system prompt first", "Be brief.", nil, false, []string{"system"}},
+		{"partial excluded", "", ptr(3), false, []string{"user", "assistant"}},
+		{"partial included", "", ptr(3), true, []string{"user", "assistant", "user", "assistant"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{
+				SystemPrompt: tt.systemPrompt,
+				ActiveChatID: tt.active,
+				Chats:        chats,
+			}
+			var got []string
+			for _, m := range s.History(tt.partial) {
+				got = append(got, string(m.Role))
+			}
+			if !slices.Equal(got, tt.wantRoles) {
+				t.Errorf("History() roles = %v, want %v", got, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestSession_PathAndLeaves(t *testing.T) {
+	ptr := func(id int) *int { return &id }
+	//   1 - 2 - 3
+	//    \- 4 - 5
+	//        \- 6
+	chats := []*Chat{
+		{ChatPart: ChatPart{ID: 1}, ParentID: nil},
+		{ChatPart: ChatPart{ID: 2}, ParentID: ptr(1)},
+		{ChatPart: ChatPart{ID: 3}, ParentID: ptr(2)},
+		{ChatPart: ChatPart{ID: 4}, ParentID: ptr(1)},
+		{ChatPart: ChatPart{ID: 5}, ParentID: ptr(4)},
+		{ChatPart: ChatPart{ID: 6}, ParentID: ptr(4)},
+	}
+	ids := func(chats []*Chat) []int {
+		var ret []int
+		for _, c := range chats {
+			ret = append(ret, c.ID)
+		}
+		return ret
+	}
+	tests := []struct {
+		name   string
+		active *int
+		want   []int
+	}{
+		{"no chat", nil, nil},
+		{"first", ptr(1), []int{1}},
+		{"leaf", ptr(3), []int{1, 2, 3}},
+		{"another branch", ptr(6), []int{1, 4, 6}},
+		{"inner", ptr(4), []int{1, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{ActiveChatID: tt.active, Chats: chats}
+			if got := ids(s.Path()); !slices.Equal(got, tt.want) {
+				t.Errorf("Path() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	s := &Session{Chats: chats}
+	if got, want := ids(s.Leaves()), []int{3, 5, 6}; !slices.Equal(got, want) {
+		t.Errorf("Leaves() = %v, want %v", got, want)
+	}
+}
+
+func TestNewResultStatus(t *testing.T) {
+	tests := []struct {
+		finishReason string
+		want         ResultStatus
+	}{
+		{openai.FinishReasonStop, ResultStatusComplete},
+		{openai.FinishReasonLength, ResultStatusLength},
+		{"", ResultStatusInterrupted},
+		{openai.FinishReasonContentFilter, ResultStatusUpstreamError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.finishReason, func(t *testing.T) {
+			if got := NewResultStatus(tt.finishReason); got != tt.want {
+				t.Errorf("NewResultStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
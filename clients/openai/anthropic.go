@@ -0,0 +1,403 @@
+package openai
+
+import (
+	"aiagent/helpers/closer"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The Anthropic Messages API differs from the OpenAI compatible one in shapes rather than in concepts,
+// so DialectAnthropic converts a [Request] to it, and its responses back to [ChatCompletion] and [ChatCompletionChunk].
+// Then callers, including the aggregation, persistence and pricing, need not know which dialect is spoken.
+// ref https://docs.anthropic.com/en/api/messages
+// ref https://docs.anthropic.com/en/docs/build-with-claude/streaming
+
+const anthropicVersion = "2023-06-01"
+
+// anthropicMaxTokens is required by the API, chosen as large as most models accept,
+// so that it rarely stops for length where an OpenAI compatible one would not.
+const anthropicMaxTokens = 32_000
+
+const anthropicMinThinkingBudget = 1024
+
+// anthropicThinkingBudget maps [ReasoningEffort] to budget_tokens, which must be less than anthropicMaxTokens.
+func anthropicThinkingBudget(effort ReasoningEffort) int {
+	switch effort {
+	case ReasoningEffortHigh:
+		return 10_000
+	case ReasoningEffortMax:
+		return 24_000
+	default:
+		return 0 // disabled
+	}
+}
+
+type anthropicRequest struct {
+	Model     ChatModel          `json:"model"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	MaxTokens int                `json:"max_tokens"`
+	Thinking  *anthropicThinking `json:"thinking,omitempty"`
+	// penalties and seed are not supported, see Dialect.Check
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Tools         []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Stream        bool                 `json:"stream"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"` // enabled only, as omitted means disabled
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"` // user or assistant, as tool replies are content blocks of user
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block of any type, whose fields are used according to Type.
+type anthropicBlock struct {
+	Type string `json:"type"`
+	// text
+	Text string `json:"text,omitempty"`
+	// thinking
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"` // auto, any, tool or none
+	Name string `json:"name,omitempty"`
+}
+
+func newAnthropicRequest(request RequestWhole) anthropicRequest {
+	var system []string
+	var messages []anthropicMessage
+	for _, m := range request.Messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		role, blocks := anthropicBlocks(m)
+		// The API requires roles alternate, while consecutive tool replies are in the same role.
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, blocks...)
+			continue
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: blocks})
+	}
+
+	ret := anthropicRequest{
+		Model:         request.Model,
+		System:        strings.Join(system, "\n\n"),
+		Messages:      messages,
+		MaxTokens:     anthropicMaxTokens,
+		Thinking:      nil,
+		Temperature:   request.Temperature,
+		TopP:          request.TopP,
+		StopSequences: request.Stop,
+		Tools:         nil,
+		ToolChoice:    nil,
+		Stream:        request.Stream,
+	}
+	if request.MaxTokens != nil {
+		ret.MaxTokens = *request.MaxTokens
+	}
+	// The budget must be less than max_tokens, and no less than the minimum.
+	if budget := min(anthropicThinkingBudget(request.ReasoningEffort), ret.MaxTokens-1); budget >= anthropicMinThinkingBudget {
+		ret.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+	}
+	for _, t := range request.Tools {
+		schema := t.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`) // required by the API
+		}
+		ret.Tools = append(ret.Tools, anthropicTool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			InputSchema: schema,
+		})
+	}
+	if tc := request.ToolChoice; tc != nil {
+		switch {
+		case tc.FunctionName != "":
+			ret.ToolChoice = &anthropicToolChoice{Type: "tool", Name: tc.FunctionName}
+		case tc.Mode == ToolChoiceModeRequired:
+			ret.ToolChoice = &anthropicToolChoice{Type: "any", Name: ""}
+		case tc.Mode != "":
+			ret.ToolChoice = &anthropicToolChoice{Type: string(tc.Mode), Name: ""}
+		}
+	}
+	return ret
+}
+
+// anthropicBlocks converts m to content blocks, and returns the role they belong to.
+func anthropicBlocks(m Message) (role string, blocks []anthropicBlock) {
+	switch m.Role {
+	case "tool":
+		return "user", []anthropicBlock{{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content}}
+	case "assistant":
+		// Thinking without a signature, such as one of another dialect or saved before signatures are, is rejected by the API.
+		// Dropping it is fine, as the API ignores thinking of previous turns anyway.
+		if m.ReasoningContent != "" && m.ReasoningSignature != "" {
+			blocks = append(blocks, anthropicBlock{
+				Type:      "thinking",
+				Thinking:  m.ReasoningContent,
+				Signature: m.ReasoningSignature,
+			})
+		}
+		if m.Content != "" {
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: m.Content})
+		}
+		for _, tc := range m.ToolCalls {
+			input := json.RawMessage(tc.Function.Arguments)
+			if !json.Valid(input) {
+				input = json.RawMessage(`{}`) // upstream generated garbage, whose reply would tell it so
+			}
+			blocks = append(blocks, anthropicBlock{
+				Type:  "tool_use",
+				ID:    tc.ID,
+				Name:  tc.Function.Name,
+				Input: input,
+			})
+		}
+		return m.Role, blocks
+	default:
+		return m.Role, []anthropicBlock{{Type: "text", Text: m.Content}}
+	}
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	Role       string           `json:"role"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// usage converts u, whose input_tokens excludes cached ones, to [Usage] whose prompt_tokens includes them.
+func (u anthropicUsage) usage() Usage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	return Usage{
+		PromptTokens:            prompt,
+		CompletionTokens:        u.OutputTokens,
+		TotalTokens:             prompt + u.OutputTokens,
+		PromoteTokensDetails:    PromoteTokensDetails{CachedTokens: u.CacheReadInputTokens},
+		CompletionTokensDetails: CompletionTokensDetails{ReasoningTokens: 0}, // not reported separately
+		PromptCacheHitTokens:    u.CacheReadInputTokens,
+		PromptCacheMissTokens:   u.InputTokens + u.CacheCreationInputTokens,
+	}
+}
+
+func anthropicFinishReason(stopReason string) FinishReason {
+	switch stopReason {
+	case "max_tokens":
+		return FinishReasonLength
+	case "tool_use":
+		return FinishReasonToolCalls
+	case "refusal":
+		return FinishReasonContentFilter
+	default: // end_turn, stop_sequence and pause_turn
+		return FinishReasonStop
+	}
+}
+
+func (r anthropicResponse) chatCompletion() *ChatCompletion {
+	message := Message{Role: r.Role}
+	for _, b := range r.Content {
+		switch b.Type {
+		case "text":
+			message.Content += b.Text
+		case "thinking":
+			message.ReasoningContent += b.Thinking
+			message.ReasoningSignature += b.Signature
+		case "tool_use":
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				Index:    len(message.ToolCalls),
+				ID:       b.ID,
+				Type:     ToolTypeFunction,
+				Function: FunctionCall{Name: b.Name, Arguments: string(b.Input)},
+			})
+		default: // such as redacted_thinking, which is not readable
+		}
+	}
+	return &ChatCompletion{
+		ChatCompletionBase: ChatCompletionBase{
+			ID:                r.ID,
+			Created:           time.Now().Unix(), // not in the response
+			Model:             r.Model,
+			SystemFingerprint: "",
+		},
+		Choices: []Choice{{
+			Index:        0,
+			Message:      message,
+			FinishReason: anthropicFinishReason(r.StopReason),
+		}},
+		Usage: r.Usage.usage(),
+	}
+}
+
+// anthropicEvent is the data of any event in stream mode, whose fields are used according to Type.
+type anthropicEvent struct {
+	Type    string            `json:"type"`
+	Message anthropicResponse `json:"message"` // message_start
+	Index   int               `json:"index"`   // content_block_*
+	// ContentBlock is in content_block_start.
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"` // message_delta
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"` // message_delta, which is cumulative
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicStream keeps what later chunks need from earlier events.
+type anthropicStream struct {
+	base       ChatCompletionBase
+	usage      anthropicUsage // input ones from message_start
+	toolIndex  map[int]int    // content block index to tool call index
+	signature  string         // held until the final chunk, see chunk
+	terminated bool
+}
+
+// chunk converts event to a chunk, ok is false if there is nothing to output.
+func (s *anthropicStream) chunk(event anthropicEvent) (chunk ChatCompletionChunk, ok bool) {
+	chunk = ChatCompletionChunk{
+		ChatCompletionBase: s.base,
+		Choices:            []ChunkChoice{{Index: 0, Delta: Message{}, FinishReason: nil}},
+		Usage:              nil,
+	}
+	delta := &chunk.Choices[0].Delta
+	switch event.Type {
+	case "message_start":
+		s.base = ChatCompletionBase{
+			ID:                event.Message.ID,
+			Created:           time.Now().Unix(),
+			Model:             event.Message.Model,
+			SystemFingerprint: "",
+		}
+		s.usage = event.Message.Usage
+		chunk.ChatCompletionBase = s.base
+		delta.Role = event.Message.Role
+		return chunk, true
+	case "content_block_start":
+		if event.ContentBlock.Type != "tool_use" {
+			return chunk, false // text and thinking come with deltas
+		}
+		index := len(s.toolIndex)
+		s.toolIndex[event.Index] = index
+		delta.ToolCalls = []ToolCall{{
+			Index:    index,
+			ID:       event.ContentBlock.ID,
+			Type:     ToolTypeFunction,
+			Function: FunctionCall{Name: event.ContentBlock.Name, Arguments: ""},
+		}}
+		return chunk, true
+	case "content_block_delta":
+		switch event.Delta.Type {
+		case "text_delta":
+			delta.Content = event.Delta.Text
+		case "thinking_delta":
+			delta.ReasoningContent = event.Delta.Thinking
+		case "input_json_delta":
+			delta.ToolCalls = []ToolCall{{
+				Index:    s.toolIndex[event.Index],
+				Function: FunctionCall{Arguments: event.Delta.PartialJSON},
+			}}
+		case "signature_delta":
+			// A chunk of signature only would look like an empty one to consumers, hold it to the final chunk.
+			s.signature += event.Delta.Signature
+			return chunk, false
+		default:
+			return chunk, false
+		}
+		return chunk, true
+	case "message_delta":
+		finishReason := anthropicFinishReason(event.Delta.StopReason)
+		usage := s.usage
+		usage.OutputTokens = event.Usage.OutputTokens
+		u := usage.usage()
+		chunk.Choices[0].FinishReason = &finishReason
+		chunk.Usage = &u
+		delta.ReasoningSignature = s.signature
+		return chunk, true
+	case "message_stop":
+		s.terminated = true
+		return chunk, false
+	default: // ping, content_block_stop
+		return chunk, false
+	}
+}
+
+// translateAnthropicStream is [translateStream] of DialectAnthropic.
+func translateAnthropicStream(body io.ReadCloser, output chan<- ChatCompletionChunkOrError) error {
+	defer closer.CloseAndWarnIfFail(body)
+	defer close(output)
+
+	// Events are in lines of `event: <type>` and `data: <json>`,
+	// where the type is also in the data, so that only data lines matter.
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, 1<<20) // a tool_use input could be a long line
+	stream := &anthropicStream{toolIndex: make(map[int]int)}
+	for scanner.Scan() {
+		after, found := strings.CutPrefix(scanner.Text(), "data: ")
+		if !found {
+			continue
+		}
+		if stream.terminated {
+			return fmt.Errorf("extra data after message_stop %q", after)
+		}
+
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(after), &event); err != nil {
+			return err
+		}
+		if event.Type == "error" {
+			// Such as overloaded_error in the middle, see translateStream for why it's sent as okay.
+			e := &Error{}
+			e.Inner.Type = event.Error.Type
+			e.Inner.Message = event.Error.Message
+			output <- ChatCompletionChunkOrError{Error: e}
+			continue
+		}
+		if chunk, ok := stream.chunk(event); ok {
+			output <- ChatCompletionChunkOrError{ChatCompletionChunk: chunk, Error: nil}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,131 @@
+package openai
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewAnthropicRequest(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "be brief"},
+		NewUserMessage("what time"),
+		{
+			Role:               "assistant",
+			ReasoningContent:   "ask clock",
+			ReasoningSignature: "sig",
+			ToolCalls: []ToolCall{
+				{ID: "a", Type: ToolTypeFunction, Function: FunctionCall{Name: "clock", Arguments: `{}`}},
+				{ID: "b", Type: ToolTypeFunction, Function: FunctionCall{Name: "clock", Arguments: `{"zone`}},
+			},
+		},
+		NewToolMessage("a", "noon"),
+		NewToolMessage("b", "error: bad arguments"),
+	}
+	request := NewRequest(messages, "claude", ReasoningEffortHigh).
+		WithTools([]Tool{NewFunctionTool("clock", "now", nil)}, &ToolChoice{Mode: ToolChoiceModeRequired})
+	data, err := json.Marshal(newAnthropicRequest(RequestWhole{Request: request, Stream: true}))
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	want := `{"model":"claude","system":"be brief","messages":[` +
+		`{"role":"user","content":[{"type":"text","text":"what time"}]},` +
+		`{"role":"assistant","content":[{"type":"thinking","thinking":"ask clock","signature":"sig"},` +
+		`{"type":"tool_use","id":"a","name":"clock","input":{}},{"type":"tool_use","id":"b","name":"clock","input":{}}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"a","content":"noon"},` +
+		`{"type":"tool_result","tool_use_id":"b","content":"error: bad arguments"}]}],` +
+		`"max_tokens":32000,"thinking":{"type":"enabled","budget_tokens":10000},` +
+		`"tools":[{"name":"clock","description":"now","input_schema":{"type":"object"}}],` +
+		`"tool_choice":{"type":"any"},"stream":true}`
+	if string(data) != want {
+		t.Errorf("newAnthropicRequest got %s, want %s", data, want)
+	}
+}
+
+func TestTranslateAnthropicStream(t *testing.T) {
+	// Trimmed from the documented example, with a tool_use added.
+	events := []string{
+		`event: message_start`,
+		`data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude","stop_reason":null,"usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":1}}}`,
+		``,
+		`event: content_block_start`,
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		``,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}`,
+		``,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		``,
+		`data: {"type":"content_block_stop","index":0}`,
+		``,
+		`event: ping`,
+		`data: {"type": "ping"}`,
+		``,
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		``,
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Let me check."}}`,
+		``,
+		`data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"clock","input":{}}}`,
+		``,
+		`data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"zone\":"}}`,
+		``,
+		`data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"UTC\"}"}}`,
+		``,
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":20}}`,
+		``,
+		`data: {"type":"message_stop"}`,
+		``,
+	}
+	body := io.NopCloser(strings.NewReader(strings.Join(events, "\n")))
+	ch := make(chan ChatCompletionChunkOrError)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- translateAnthropicStream(body, ch)
+	}()
+	aggregator := NewAggregator()
+	var chunks int
+	for coe := range ch {
+		if coe.Error != nil {
+			t.Fatalf("unexpected error chunk %v", coe.Error)
+		}
+		aggregator.Aggregate(coe.ChatCompletionChunk)
+		chunks++
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("translateAnthropicStream() error = %v", err)
+	}
+
+	if chunks != 7 {
+		t.Errorf("chunks got %d, want 7", chunks)
+	}
+	want := Message{
+		Role:               "assistant",
+		Content:            "Let me check.",
+		ReasoningContent:   "hmm",
+		ReasoningSignature: "sig",
+		ToolCalls: []ToolCall{
+			{Index: 0, ID: "toolu_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "clock", Arguments: `{"zone":"UTC"}`}},
+		},
+	}
+	if got := aggregator.Choices[0].Message; !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate got %+v, want %+v", got, want)
+	}
+	if aggregator.Choices[0].FinishReason != FinishReasonToolCalls {
+		t.Errorf("FinishReason got %q", aggregator.Choices[0].FinishReason)
+	}
+	if aggregator.ID != "msg_1" {
+		t.Errorf("ID got %q", aggregator.ID)
+	}
+	wantUsage := Usage{
+		PromptTokens:          15,
+		CompletionTokens:      20,
+		TotalTokens:           35,
+		PromoteTokensDetails:  PromoteTokensDetails{CachedTokens: 5},
+		PromptCacheHitTokens:  5,
+		PromptCacheMissTokens: 10,
+	}
+	if aggregator.Usage != wantUsage {
+		t.Errorf("Usage got %+v, want %+v", aggregator.Usage, wantUsage)
+	}
+}
@@ -1,13 +1,15 @@
 package openai
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 )
 
 // ChatModel is an enum class to represent a Large Language Model.
+// Constants here are the well-known ones, while more could be routed by provider.Registry.
 //
-// See [ pricer.PriceOrDefault ] to find what obstacles making Price an enum class ability.
+// See [ pricer.Table ] to find what obstacles making Price an enum class ability.
 // Developers have tried that for 2 times, but keeps finding it better not to do so.
 type ChatModel string // it's openai.ChatModel
 
@@ -16,17 +18,6 @@ const (
 	ChatModelDeepSeekV4Pro   ChatModel = "deepseek-v4-pro"
 )
 
-func (cm ChatModel) ConcurrentLimit() int {
-	switch cm {
-	case ChatModelDeepSeekV4Flash:
-		return 2500
-	case ChatModelDeepSeekV4Pro:
-		return 500
-	default:
-		panic(fmt.Errorf("unknown chat model %s for ConcurrentLimit", cm))
-	}
-}
-
 type ReasoningEffort string
 
 // Define enums for possibilities, may not all used now.
@@ -46,8 +37,15 @@ type Request struct {
 	// [ReasoningEffort] is an OpenAI's standard, while [Thinking] is a DeepSeek API dialect.
 	// ref https://developers.openai.com/api/reference/resources/chat/subresources/completions/methods/create
 	// ref https://api-docs.deepseek.com/api/create-chat-completion
+	// A Client would adapt them to its Dialect.
 	ReasoningEffort ReasoningEffort `json:"reasoning_effort,omitempty"`
-	Thinking        Thinking        `json:"thinking"`
+	Thinking        *Thinking       `json:"thinking,omitempty"`
+	Tools           []Tool          `json:"tools,omitempty"`
+	ToolChoice      *ToolChoice     `json:"tool_choice,omitempty"` // nil as upstream default, auto if Tools exist
+	Sampling
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// MaxCompletionTokens replaces MaxTokens in DialectOpenAI, set by adapt only.
+	MaxCompletionTokens *int `json:"max_completion_tokens,omitempty"`
 }
 
 func NewRequest(messages []Message, model ChatModel, thinking ReasoningEffort) Request {
@@ -57,17 +55,24 @@ func NewRequest(messages []Message, model ChatModel, thinking ReasoningEffort) R
 			Messages:        messages,
 			Model:           model,
 			ReasoningEffort: "", // JSON omitempty, otherwise 400
-			Thinking:        Thinking{Type: ThinkingTypeDisabled},
+			Thinking:        &Thinking{Type: ThinkingTypeDisabled},
 		}
 	}
 	return Request{
 		Messages:        messages,
 		Model:           model,
 		ReasoningEffort: thinking,
-		Thinking:        Thinking{Type: ThinkingTypeEnabled},
+		Thinking:        &Thinking{Type: ThinkingTypeEnabled},
 	}
 }
 
+// WithTools returns a copy of r that offers tools to upstream, choice is optional.
+func (r Request) WithTools(tools []Tool, choice *ToolChoice) Request {
+	r.Tools = tools
+	r.ToolChoice = choice
+	return r
+}
+
 type ThinkingType string
 
 const (
@@ -82,12 +87,114 @@ type Thinking struct {
 type RequestWhole struct {
 	Request
 	Stream bool `json:"stream"`
+	// StreamOptions is set by the dialect, as some send no usage in a stream unless asked.
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	// IncludeUsage asks for usage in one more chunk at the end, whose choices are empty.
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type ToolType string
+
+const ToolTypeFunction ToolType = "function"
+
+// Tool is a tool schema offered to upstream in [Request].
+type Tool struct {
+	Type     ToolType           `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+func NewFunctionTool(name string, description string, parameters json.RawMessage) Tool {
+	return Tool{
+		Type: ToolTypeFunction,
+		Function: FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+type FunctionDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Parameters is a JSON Schema object, kept raw as we never inspect it.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+type ToolChoiceMode string
+
+//goland:noinspection GoUnusedConst
+const (
+	ToolChoiceModeNone     ToolChoiceMode = "none"
+	ToolChoiceModeAuto     ToolChoiceMode = "auto"
+	ToolChoiceModeRequired ToolChoiceMode = "required"
+)
+
+// ToolChoice is either a mode or a named function in JSON.
+// If FunctionName is not empty, Mode is ignored and the named function is forced.
+type ToolChoice struct {
+	Mode         ToolChoiceMode
+	FunctionName string
+}
+
+type namedToolChoice struct {
+	Type     ToolType `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+func (tc ToolChoice) MarshalJSON() ([]byte, error) {
+	if tc.FunctionName == "" {
+		return json.Marshal(tc.Mode)
+	}
+	var named namedToolChoice
+	named.Type = ToolTypeFunction
+	named.Function.Name = tc.FunctionName
+	return json.Marshal(named)
+}
+
+func (tc *ToolChoice) UnmarshalJSON(data []byte) error {
+	var mode ToolChoiceMode
+	if err := json.Unmarshal(data, &mode); err == nil {
+		*tc = ToolChoice{Mode: mode, FunctionName: ""}
+		return nil
+	}
+	var named namedToolChoice
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	*tc = ToolChoice{Mode: "", FunctionName: named.Function.Name}
+	return nil
+}
+
+// ToolCall is what upstream asks for in an assistant [Message].
+// In stream mode, it comes in pieces distinguished by Index, see [ChatCompletion.Aggregate].
+type ToolCall struct {
+	Index    int          `json:"index,omitempty"` // only meaningful in ChunkChoice.Delta
+	ID       string       `json:"id,omitempty"`
+	Type     ToolType     `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name string `json:"name,omitempty"`
+	// Arguments is a JSON object in string generated by upstream, which may be invalid.
+	Arguments string `json:"arguments"`
 }
 
 type Message struct {
 	Role             string `json:"role"`
 	Content          string `json:"content"`
 	ReasoningContent string `json:"reasoning_content,omitempty"`
+	// ReasoningSignature proves ReasoningContent is untampered in DialectAnthropic, which requires it
+	// to pass back thinking in tool calling. It's opaque to clients, thus never in JSON, but saved with steps.
+	ReasoningSignature string     `json:"-"`
+	ToolCalls          []ToolCall `json:"tool_calls,omitempty"`   // only in assistant role
+	ToolCallID         string     `json:"tool_call_id,omitempty"` // only in tool role
 }
 
 func NewUserMessage(content string) Message {
@@ -97,14 +204,46 @@ func NewUserMessage(content string) Message {
 	}
 }
 
+func NewSystemMessage(content string) Message {
+	return Message{
+		Role:    "system",
+		Content: content,
+	}
+}
+
+// NewToolMessage creates the reply to a [ToolCall] whose ID is toolCallID.
+func NewToolMessage(toolCallID string, content string) Message {
+	return Message{
+		Role:       "tool",
+		Content:    content,
+		ToolCallID: toolCallID,
+	}
+}
+
 // HistoryRecord on an item in [Response] converts it to that in [Request].
 // At present, it drops the CoT field as the requirement from
 // https://api-docs.deepseek.com/guides/reasoning_model#multi-round-conversation
+// Except for a tool calling one, whose CoT shall be passed back within the same turn as
+// https://api-docs.deepseek.com/guides/thinking_mode#tool-calls
 func (m Message) HistoryRecord() Message {
+	var reasoningContent, reasoningSignature string
+	var toolCalls []ToolCall
+	if len(m.ToolCalls) > 0 {
+		reasoningContent = m.ReasoningContent
+		reasoningSignature = m.ReasoningSignature
+		toolCalls = make([]ToolCall, len(m.ToolCalls))
+		for i, tc := range m.ToolCalls {
+			tc.Index = 0 // chunk only field, drop it as JSON omitempty
+			toolCalls[i] = tc
+		}
+	}
 	return Message{
-		Role:             m.Role,
-		Content:          m.Content,
-		ReasoningContent: "",
+		Role:               m.Role,
+		Content:            m.Content,
+		ReasoningContent:   reasoningContent,
+		ReasoningSignature: reasoningSignature,
+		ToolCalls:          toolCalls,
+		ToolCallID:         m.ToolCallID,
 	}
 }
 
@@ -157,6 +296,10 @@ func (cc *ChatCompletion) Aggregate(chunk ChatCompletionChunk) {
 	if chunk.Usage != nil {
 		cc.Usage = *chunk.Usage
 	}
+	if len(chunk.Choices) == 0 && len(cc.Choices) == 1 {
+		// The usage one asked by StreamOptions, after the one with FinishReason.
+		return
+	}
 
 	if len(chunk.Choices) != 1 || len(cc.Choices) != 1 {
 		// I have never seen such kind of responses, just not supported yet.
@@ -178,6 +321,28 @@ func (cc *ChatCompletion) Aggregate(chunk ChatCompletionChunk) {
 	cc.Choices[0].Message.Role += neo.Delta.Role
 	cc.Choices[0].Message.Content += neo.Delta.Content
 	cc.Choices[0].Message.ReasoningContent += neo.Delta.ReasoningContent
+	cc.Choices[0].Message.ReasoningSignature += neo.Delta.ReasoningSignature
+	for _, delta := range neo.Delta.ToolCalls {
+		cc.Choices[0].Message.ToolCalls = aggregateToolCall(cc.Choices[0].Message.ToolCalls, delta)
+	}
+}
+
+// aggregateToolCall merges delta into calls at the position of its Index, returns the updated calls.
+// As observed, the first piece of a call brings ID, Type and Name, later ones only bring partial Arguments.
+func aggregateToolCall(calls []ToolCall, delta ToolCall) []ToolCall {
+	for len(calls) <= delta.Index {
+		calls = append(calls, ToolCall{Index: len(calls)})
+	}
+	target := &calls[delta.Index]
+	if delta.ID != "" {
+		target.ID = delta.ID
+	}
+	if delta.Type != "" {
+		target.Type = delta.Type
+	}
+	target.Function.Name += delta.Function.Name
+	target.Function.Arguments += delta.Function.Arguments
+	return calls
 }
 
 type ChatCompletionBase struct {
@@ -230,6 +395,23 @@ type Usage struct {
 	PromptCacheMissTokens   int                     `json:"prompt_cache_miss_tokens"`
 }
 
+// Add sums up u and o, which accounts a chat with several rounds to upstream.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + o.PromptTokens,
+		CompletionTokens: u.CompletionTokens + o.CompletionTokens,
+		TotalTokens:      u.TotalTokens + o.TotalTokens,
+		PromoteTokensDetails: PromoteTokensDetails{
+			CachedTokens: u.PromoteTokensDetails.CachedTokens + o.PromoteTokensDetails.CachedTokens,
+		},
+		CompletionTokensDetails: CompletionTokensDetails{
+			ReasoningTokens: u.CompletionTokensDetails.ReasoningTokens + o.CompletionTokensDetails.ReasoningTokens,
+		},
+		PromptCacheHitTokens:  u.PromptCacheHitTokens + o.PromptCacheHitTokens,
+		PromptCacheMissTokens: u.PromptCacheMissTokens + o.PromptCacheMissTokens,
+	}
+}
+
 type PromoteTokensDetails struct {
 	CachedTokens int `json:"cached_tokens"`
 }
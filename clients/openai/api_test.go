@@ -0,0 +1,80 @@
+package openai
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestChatCompletion_AggregateToolCalls(t *testing.T) {
+	// Captured pieces in stream mode, trimmed to fields matter.
+	chunks := []string{
+		`{"id":"x","choices":[{"index":0,"delta":{"role":"assistant","content":null}}]}`,
+		`{"id":"x","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_0","type":"function","function":{"name":"clock","arguments":""}}]}}]}`,
+		`{"id":"x","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"zone\""}}]}}]}`,
+		`{"id":"x","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":\"UTC\"}"}}]}}]}`,
+		`{"id":"x","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_1","type":"function","function":{"name":"calculator","arguments":"{}"}}]}}]}`,
+		`{"id":"x","choices":[{"index":0,"delta":{"content":""},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":1}}`,
+	}
+	aggregator := NewAggregator()
+	for _, chunk := range chunks {
+		var c ChatCompletionChunk
+		if err := json.Unmarshal([]byte(chunk), &c); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		aggregator.Aggregate(c)
+	}
+
+	want := []ToolCall{
+		{Index: 0, ID: "call_0", Type: ToolTypeFunction, Function: FunctionCall{Name: "clock", Arguments: `{"zone":"UTC"}`}},
+		{Index: 1, ID: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "calculator", Arguments: `{}`}},
+	}
+	got := aggregator.Choices[0].Message.ToolCalls
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate got %+v, want %+v", got, want)
+	}
+	if aggregator.Choices[0].FinishReason != FinishReasonToolCalls {
+		t.Errorf("Aggregate FinishReason got %q", aggregator.Choices[0].FinishReason)
+	}
+
+	data, err := json.Marshal(aggregator.Choices[0].Message.HistoryRecord())
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	//goland:noinspection SpellCheckingInspection
+	wantJSON := `{"role":"assistant","content":"","tool_calls":[` +
+		`{"id":"call_0","type":"function","function":{"name":"clock","arguments":"{\"zone\":\"UTC\"}"}},` +
+		`{"id":"call_1","type":"function","function":{"name":"calculator","arguments":"{}"}}]}`
+	if string(data) != wantJSON {
+		t.Errorf("HistoryRecord got %s, want %s", data, wantJSON)
+	}
+}
+
+func TestToolChoice_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		tc   ToolChoice
+		want string
+	}{
+		{"mode", ToolChoice{Mode: ToolChoiceModeRequired}, `"required"`},
+		{"named", ToolChoice{FunctionName: "clock"}, `{"type":"function","function":{"name":"clock"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.tc)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("MarshalJSON got %s, want %s", data, tt.want)
+			}
+			var back ToolChoice
+			if err := json.Unmarshal(data, &back); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if back != tt.tc {
+				t.Errorf("UnmarshalJSON got %+v, want %+v", back, tt.tc)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// backoff decides whether and when to retry a request to upstream.
+// Retrying is only done before the response body is handed out, so that no byte is streamed twice.
+type backoff struct {
+	attempts int           // including the first one
+	base     time.Duration // of the first retry, doubled by each later one
+	ceiling  time.Duration
+}
+
+var defaultBackoff = backoff{
+	attempts: 4,
+	base:     500 * time.Millisecond,
+	ceiling:  8 * time.Second,
+}
+
+// next returns the delay before retrying the failed attempt, which is zero-based.
+// Retry is false if err is not retryable, attempts are used up, or ctx would be done before the delay.
+func (b backoff) next(ctx context.Context, attempt int, err error) (delay time.Duration, retry bool) {
+	if attempt+1 >= b.attempts || ctx.Err() != nil {
+		return 0, false
+	}
+	var e *Error
+	if errors.As(err, &e) && !e.Retryable() {
+		return 0, false
+	}
+	// Otherwise, it's a retryable status, or a network failure only retried if it's before connected.
+	// Once the request may have reached upstream, which could be charged, it's never sent again.
+	var op *net.OpError
+	if e == nil && !(errors.As(err, &op) && op.Op == "dial") {
+		return 0, false
+	}
+
+	// Full jitter, so that clients rejected together would not come back together.
+	// ref https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+	delay = time.Duration(rand.Int64N(int64(min(b.base<<attempt, b.ceiling)) + 1))
+	if e != nil && e.RetryAfter > delay {
+		delay = e.RetryAfter // upstream knows better
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return 0, false
+	}
+	return delay, true
+}
@@ -3,9 +3,11 @@ package openai
 import "time"
 
 // Chat is a combination of question and its possible answer.
+// Steps are messages in between, typically tool calls and their replies, in order.
 type Chat struct {
 	Input   string          `json:"input"`
 	Created time.Time       `json:"created"`
+	Steps   []Message       `json:"steps,omitempty"`
 	Result  *ChatCompletion `json:"result"`
 }
 
@@ -16,6 +18,9 @@ func (c Chat) Valid() bool {
 func (c Chat) HistoryRecords() []Message {
 	var ret []Message
 	ret = append(ret, NewUserMessage(c.Input))
+	for _, step := range c.Steps {
+		ret = append(ret, step.HistoryRecord())
+	}
 	ret = append(ret, c.Result.Choices[0].Message.HistoryRecord())
 	return ret
 }
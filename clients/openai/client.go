@@ -11,34 +11,86 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type Client struct {
 	baseURL string
 	apiKey  string
+	dialect Dialect
+	backoff backoff
+	// limiters by upstream model, see SetConcurrentLimit
+	limiters map[ChatModel]*limiter
 }
 
-func New(baseURL, apiKey string) *Client {
+// New creates a Client, apiKey could be empty for local servers without authentication.
+func New(baseURL, apiKey string, dialect Dialect) *Client {
 	return &Client{
 		baseURL: baseURL,
 		apiKey:  apiKey,
+		dialect: dialect,
+		backoff: defaultBackoff,
 	}
 }
 
 func (c *Client) chat(ctx context.Context, request RequestWhole) (body io.ReadCloser, err error) {
-	data, err := json.Marshal(request)
+	request.Request = c.dialect.adapt(request.Request)
+	request.StreamOptions = c.dialect.streamOptions(request.Stream)
+	var payload any = request
+	path := "/chat/completions"
+	if c.dialect == DialectAnthropic {
+		payload = newAnthropicRequest(request)
+		path = "/messages"
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// The slot is held among retries, as rejected ones are likely due to upstream busy.
+	release, err := c.acquire(ctx, request.Model)
 	if err != nil {
 		return nil, err
 	}
+	url := c.baseURL + path
+	for attempt := 0; ; attempt++ {
+		body, err = c.post(ctx, url, data)
+		if err == nil {
+			return releaseOnClose{ReadCloser: body, release: release}, nil
+		}
+		delay, retry := c.backoff.next(ctx, attempt, err)
+		if !retry {
+			release()
+			return nil, err
+		}
+		slog.Warn("retry upstream", "url", url, "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
 
-	url := c.baseURL + "/chat/completions"
+// post sends data once, returns the body of a 200 response, or an [*Error] for other statuses.
+func (c *Client) post(ctx context.Context, url string, data []byte) (body io.ReadCloser, err error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
+	switch {
+	case c.apiKey == "":
+	case c.dialect == DialectAnthropic:
+		req.Header.Add("x-api-key", c.apiKey)
+	default:
+		req.Header.Add("Authorization", "Bearer "+c.apiKey)
+	}
+	if c.dialect == DialectAnthropic {
+		req.Header.Add("anthropic-version", anthropicVersion)
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -51,7 +103,7 @@ func (c *Client) chat(ctx context.Context, request RequestWhole) (body io.ReadCl
 		if e != nil {
 			payload = []byte(fmt.Sprintf("read payload error: %s", e.Error()))
 		}
-		return nil, fmt.Errorf("unexpected status %v body %s", resp.Status, string(payload))
+		return nil, newStatusError(resp, payload)
 	}
 	return resp.Body, nil
 }
@@ -66,6 +118,13 @@ func (c *Client) OneShot(ctx context.Context, request Request) (*ChatCompletion,
 	}
 	defer closer.CloseAndWarnIfFail(body)
 
+	if c.dialect == DialectAnthropic {
+		var response anthropicResponse
+		if err := json.NewDecoder(body).Decode(&response); err != nil {
+			return nil, err
+		}
+		return response.chatCompletion(), nil
+	}
 	var response Response
 	if err := json.NewDecoder(body).Decode(&response); err != nil {
 		return nil, err
@@ -73,6 +132,14 @@ func (c *Client) OneShot(ctx context.Context, request Request) (*ChatCompletion,
 	return &response.ChatCompletion, nil
 }
 
+// translateStream returns that of the dialect.
+func (c *Client) translateStream() func(body io.ReadCloser, output chan<- ChatCompletionChunkOrError) error {
+	if c.dialect == DialectAnthropic {
+		return translateAnthropicStream
+	}
+	return translateStream
+}
+
 // translateStream read and parse message body and output to channel.
 // Once it's done, both body and output would be closed.
 func translateStream(body io.ReadCloser, output chan<- ChatCompletionChunkOrError) error {
@@ -145,23 +212,6 @@ func tryDecode(line string) (e *Error, ok bool) {
 	return ret, true
 }
 
-var ErrUpstream = fmt.Errorf("upstream error")
-
-type Error struct {
-	Inner struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-		Param   string `json:"param"`
-		Code    string `json:"code"`
-	} `json:"error"`
-}
-
-func (e Error) Is(target error) bool { return target == ErrUpstream }
-
-func (e Error) Error() string {
-	return fmt.Sprintf("%+v", e.Inner)
-}
-
 type ChatCompletionChunkOrError struct {
 	ChatCompletionChunk
 	Error error
@@ -182,8 +232,9 @@ func (c *Client) OneShotStreamFast(
 	}
 
 	ch := make(chan ChatCompletionChunkOrError)
+	translate := c.translateStream()
 	go func(b io.ReadCloser, o chan<- ChatCompletionChunkOrError) {
-		if err := translateStream(b, o); err != nil {
+		if err := translate(b, o); err != nil {
 			// Consider the outer function should have returned,
 			// my best effort would be log error here.
 			slog.Warn("translateStream", "err", err)
@@ -229,7 +280,7 @@ func (c *Client) OneShotStream(
 			o <- chunk
 		}
 	}(aggregated, input, ch)
-	if err := translateStream(body, input); err != nil {
+	if err := c.translateStream()(body, input); err != nil {
 		return nil, err
 	}
 	return aggregated, nil
@@ -1,9 +1,15 @@
 package openai
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSSEDataLineError(t *testing.T) {
@@ -41,3 +47,203 @@ func TestSSEDataLineError(t *testing.T) {
 		})
 	}
 }
+
+func TestError_Class(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   ErrorClass
+		retry  bool
+	}{
+		{"auth", 401, `{"error":{"message":"Authentication Fails","type":"authentication_error"}}`, ErrorClassAuth, false},
+		{"balance", 402, `{"error":{"message":"Insufficient Balance"}}`, ErrorClassInsufficientBalance, false},
+		{"rate", 429, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`, ErrorClassRateLimited, true},
+		{"overloaded", 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, ErrorClassOverloaded, true},
+		{"bad", 400, `{"error":{"message":"bad","type":"invalid_request_error"}}`, ErrorClassBadRequest, false},
+		{"risk", 400, `{"error":{"message":"Content Exists Risk","type":"invalid_request_error"}}`, ErrorClassContentRisk, false},
+		{"not JSON", 502, `<html>Bad Gateway</html>`, ErrorClassServer, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newStatusError(&http.Response{StatusCode: tt.status, Header: http.Header{}}, []byte(tt.body))
+			if got := e.Class(); got != tt.want {
+				t.Errorf("Class() got %v, want %v", got, tt.want)
+			}
+			if got := e.Retryable(); got != tt.retry {
+				t.Errorf("Retryable() got %v, want %v", got, tt.retry)
+			}
+			if e.Inner.Message == "" {
+				t.Errorf("newStatusError lost message of %s", tt.body)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 5, 6, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"Wed, 06 May 2026 00:00:10 GMT", 10 * time.Second},
+		{"Tue, 05 May 2026 00:00:10 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	var calls atomic.Int32
+	var overloaded atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch {
+		case overloaded.Load():
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case n == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case n == 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte(`{"id":"x","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	client := New(server.URL, "", DialectPlain)
+	client.backoff = backoff{attempts: 3, base: time.Millisecond, ceiling: time.Millisecond}
+	cc, err := client.OneShot(context.Background(), NewRequest(nil, "m", ReasoningEffortNone))
+	if err != nil {
+		t.Fatalf("OneShot() error = %v", err)
+	}
+	if cc.Choices[0].Message.Content != "ok" || calls.Load() != 3 {
+		t.Errorf("OneShot() got %+v after %d calls", cc, calls.Load())
+	}
+
+	calls.Store(0)
+	overloaded.Store(true)
+	_, err = client.OneShot(context.Background(), NewRequest(nil, "m", ReasoningEffortNone))
+	if got := HTTPStatus(err); got != http.StatusServiceUnavailable {
+		t.Errorf("HTTPStatus() got %d of %v", got, err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("attempts got %d, want 3", calls.Load())
+	}
+}
+
+func TestClient_RetryNetwork(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// Hung up once the request has reached here, which may have been charged.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		_ = conn.Close()
+	}))
+	defer server.Close()
+
+	client := New(server.URL, "", DialectPlain)
+	client.backoff = backoff{attempts: 3, base: time.Millisecond, ceiling: time.Millisecond}
+	if _, err := client.OneShot(context.Background(), NewRequest(nil, "m", ReasoningEffortNone)); err == nil {
+		t.Error("OneShot() error = nil after hung up")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("attempts got %d, want 1 as the request has been sent", calls.Load())
+	}
+
+	// Nothing is sent if it fails to connect, thus retried.
+	server.Close()
+	_, err := client.post(context.Background(), server.URL, nil)
+	if _, retry := client.backoff.next(context.Background(), 0, err); !retry {
+		t.Errorf("next() retry = false of %v", err)
+	}
+}
+
+func TestClient_ConcurrentLimit(t *testing.T) {
+	proceed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-proceed
+		_, _ = w.Write([]byte(`{"id":"x","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+	defer close(proceed)
+
+	client := New(server.URL, "", DialectPlain)
+	client.SetConcurrentLimit("m", 1)
+	request := NewRequest(nil, "m", ReasoningEffortNone)
+	done := make(chan error)
+	go func() {
+		_, err := client.OneShot(context.Background(), request)
+		done <- err
+	}()
+	for load, _ := client.Load("m"); load.InFlight != 1; load, _ = client.Load("m") {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.OneShot(ctx, request)
+	if !errors.Is(err, ErrBusy) || HTTPStatus(err) != http.StatusServiceUnavailable {
+		t.Errorf("OneShot() over limit error = %v", err)
+	}
+
+	proceed <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatalf("OneShot() error = %v", err)
+	}
+	if load, _ := client.Load("m"); load != (Load{Limit: 1, InFlight: 0, Waiting: 0}) {
+		t.Errorf("Load() after all done got %+v", load)
+	}
+	if _, ok := client.Load("unlimited"); ok {
+		t.Errorf("Load() of unlimited model got ok")
+	}
+}
+
+func TestClient_OneShotStreamUsage(t *testing.T) {
+	var asked atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req RequestWhole
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		asked.Store(req.StreamOptions != nil && req.StreamOptions.IncludeUsage)
+		w.Header().Set("Content-Type", "text/event-stream")
+		// As OpenAI does, usage comes in its own chunk without choices, after the one with the finish reason.
+		for _, chunk := range []string{
+			`{"id":"x","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"}}]}`,
+			`{"id":"x","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+			`{"id":"x","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+			`[DONE]`,
+		} {
+			_, _ = w.Write([]byte("data: " + chunk + "\n\n"))
+		}
+	}))
+	defer server.Close()
+
+	client := New(server.URL, "", DialectOpenAI)
+	ch := make(chan ChatCompletionChunkOrError)
+	go func() {
+		for range ch {
+		}
+	}()
+	cc, err := client.OneShotStream(context.Background(), NewRequest(nil, "m", ReasoningEffortNone), ch)
+	if err != nil {
+		t.Fatalf("OneShotStream() error = %v", err)
+	}
+	if !asked.Load() {
+		t.Error("OneShotStream() did not ask for usage by stream_options")
+	}
+	if cc.Choices[0].Message.Content != "ok" || cc.Choices[0].FinishReason != FinishReasonStop ||
+		cc.Usage.PromptTokens != 3 || cc.Usage.CompletionTokens != 2 {
+		t.Errorf("OneShotStream() got %+v", cc)
+	}
+}
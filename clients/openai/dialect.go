@@ -0,0 +1,64 @@
+package openai
+
+// Dialect is how a vendor speaks differently from others in the OpenAI compatible API.
+// [Request] is built in the DeepSeek way by [NewRequest], and [Client] translates it by its Dialect.
+type Dialect string
+
+const (
+	DialectDeepSeek Dialect = "deepseek" // thinking with reasoning_effort
+	DialectOpenAI   Dialect = "openai"   // reasoning_effort only
+	// DialectPlain speaks neither thinking nor reasoning_effort, as the least common denominator,
+	// which suits local servers such as llama.cpp, Ollama and vLLM.
+	DialectPlain Dialect = "plain"
+	// DialectAnthropic speaks the Anthropic Messages API rather than the OpenAI compatible one, see anthropic.go.
+	DialectAnthropic Dialect = "anthropic"
+)
+
+func (d Dialect) Valid() bool {
+	switch d {
+	case DialectDeepSeek, DialectOpenAI, DialectPlain, DialectAnthropic:
+		return true
+	default:
+		return false
+	}
+}
+
+// streamOptions returns what to ask for in a stream, nil if it's nothing more than the default.
+// DialectDeepSeek sends usage with the finish reason, DialectAnthropic sends it in its own events.
+func (d Dialect) streamOptions(stream bool) *StreamOptions {
+	switch {
+	case !stream:
+		return nil
+	case d == DialectOpenAI, d == DialectPlain:
+		return &StreamOptions{IncludeUsage: true}
+	default:
+		return nil
+	}
+}
+
+func (d Dialect) adapt(r Request) Request {
+	switch d {
+	case DialectOpenAI:
+		r.Thinking = nil
+		switch r.ReasoningEffort {
+		case ReasoningEffortNone:
+			r.ReasoningEffort = "" // model default, as not all models accept none
+		case ReasoningEffortMax:
+			r.ReasoningEffort = "xhigh"
+		default:
+		}
+		// max_tokens is deprecated and rejected by reasoning models.
+		r.MaxCompletionTokens, r.MaxTokens = r.MaxTokens, nil
+		return r
+	case DialectPlain:
+		r.Thinking = nil
+		r.ReasoningEffort = ""
+		return r
+	case DialectDeepSeek:
+		return degradeFormat(r, true)
+	case DialectAnthropic:
+		return degradeFormat(r, false)
+	default:
+		return r
+	}
+}
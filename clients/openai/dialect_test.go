@@ -0,0 +1,30 @@
+package openai
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDialect_adapt(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		effort  ReasoningEffort
+		want    string
+	}{
+		{DialectDeepSeek, ReasoningEffortHigh, `{"messages":null,"model":"m","reasoning_effort":"high","thinking":{"type":"enabled"}}`},
+		{DialectOpenAI, ReasoningEffortMax, `{"messages":null,"model":"m","reasoning_effort":"xhigh"}`},
+		{DialectOpenAI, ReasoningEffortNone, `{"messages":null,"model":"m"}`},
+		{DialectPlain, ReasoningEffortHigh, `{"messages":null,"model":"m"}`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect)+"/"+string(tt.effort), func(t *testing.T) {
+			data, err := json.Marshal(tt.dialect.adapt(NewRequest(nil, "m", tt.effort)))
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("adapt got %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
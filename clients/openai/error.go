@@ -0,0 +1,147 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUpstream = fmt.Errorf("upstream error")
+
+// Error is what upstream says on failure, either as the body of a non-200 response, or as a line in stream.
+type Error struct {
+	Inner struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Param   string `json:"param"`
+		Code    string `json:"code"`
+	} `json:"error"`
+	Status     int           `json:"-"` // HTTP status, zero if it comes in stream
+	RetryAfter time.Duration `json:"-"` // from the header, zero if absent
+}
+
+func (e Error) Is(target error) bool { return target == ErrUpstream }
+
+func (e Error) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("%+v", e.Inner)
+	}
+	return fmt.Sprintf("status %d %+v", e.Status, e.Inner)
+}
+
+// ErrorClass is what callers could do about an [Error], which is vendor independent.
+type ErrorClass string
+
+const (
+	ErrorClassAuth                ErrorClass = "auth"                 // API key of the provider is wrong
+	ErrorClassRateLimited         ErrorClass = "rate_limited"         // retryable
+	ErrorClassInsufficientBalance ErrorClass = "insufficient_balance" // top up the account of the provider
+	ErrorClassOverloaded          ErrorClass = "overloaded"           // retryable
+	ErrorClassBadRequest          ErrorClass = "bad_request"          // typically my fault of not well-prepared request
+	ErrorClassContentRisk         ErrorClass = "content_risk"         // rejected for the content, never retry
+	ErrorClassServer              ErrorClass = "server"               // retryable
+)
+
+// contentRiskMessage is captured from DeepSeek, both in 400 and in stream, see TestSSEDataLineError.
+const contentRiskMessage = "Content Exists Risk"
+
+// Class classifies e by its status, or by its type if it comes in stream.
+// ref https://api-docs.deepseek.com/quick_start/error_codes
+// ref https://docs.anthropic.com/en/api/errors
+func (e Error) Class() ErrorClass {
+	if strings.Contains(e.Inner.Message, contentRiskMessage) {
+		return ErrorClassContentRisk
+	}
+	switch e.Status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrorClassAuth
+	case http.StatusPaymentRequired:
+		return ErrorClassInsufficientBalance
+	case http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case http.StatusServiceUnavailable, 529: // 529 is overloaded of Anthropic
+		return ErrorClassOverloaded
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return ErrorClassBadRequest
+	case 0:
+	default:
+		return ErrorClassServer
+	}
+	switch e.Inner.Type {
+	case "authentication_error", "permission_error":
+		return ErrorClassAuth
+	case "rate_limit_error":
+		return ErrorClassRateLimited
+	case "overloaded_error":
+		return ErrorClassOverloaded
+	case "invalid_request_error":
+		return ErrorClassBadRequest
+	default:
+		return ErrorClassServer
+	}
+}
+
+// Retryable is whether the same request may succeed later.
+func (e Error) Retryable() bool {
+	switch e.Class() {
+	case ErrorClassRateLimited, ErrorClassOverloaded, ErrorClassServer:
+		return true
+	default:
+		return false
+	}
+}
+
+// newStatusError creates an [Error] from a non-200 response, whose body may not be JSON.
+func newStatusError(resp *http.Response, body []byte) *Error {
+	ret := &Error{}
+	if err := json.Unmarshal(body, ret); err != nil || ret.Inner.Message == "" {
+		ret.Inner.Message = strings.TrimSpace(string(body))
+	}
+	ret.Status = resp.StatusCode
+	ret.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return ret
+}
+
+// parseRetryAfter parses the header in either delay-seconds or HTTP-date, zero if absent or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// HTTPStatus suggests what our callers shall see, if err comes from a [Client].
+// Upstream faults other than those callers could act on are reported as 502.
+func HTTPStatus(err error) int {
+	if errors.Is(err, ErrBusy) {
+		return http.StatusServiceUnavailable // not upstream's fault, but the same to callers
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		return http.StatusBadGateway // such as network failures
+	}
+	switch e.Class() {
+	case ErrorClassRateLimited:
+		return http.StatusTooManyRequests
+	case ErrorClassInsufficientBalance:
+		return http.StatusPaymentRequired
+	case ErrorClassOverloaded:
+		return http.StatusServiceUnavailable
+	case ErrorClassBadRequest:
+		return http.StatusBadRequest
+	case ErrorClassContentRisk:
+		return http.StatusUnavailableForLegalReasons
+	default: // including auth, which is our misconfiguration rather than callers' fault
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,66 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type ResponseFormatType string
+
+//goland:noinspection GoUnusedConst
+const (
+	ResponseFormatTypeText       ResponseFormatType = "text"
+	ResponseFormatTypeJSONObject ResponseFormatType = "json_object"
+	ResponseFormatTypeJSONSchema ResponseFormatType = "json_schema"
+)
+
+// ResponseFormat asks upstream to reply in JSON, which is also known as JSON mode and structured outputs.
+// ref https://platform.openai.com/docs/guides/structured-outputs
+type ResponseFormat struct {
+	Type       ResponseFormatType `json:"type"`
+	JSONSchema *JSONSchema        `json:"json_schema,omitempty"` // only for json_schema
+}
+
+type JSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      bool            `json:"strict,omitempty"`
+}
+
+// WithResponseFormat returns a copy of r with format.
+func (r Request) WithResponseFormat(format *ResponseFormat) Request {
+	r.ResponseFormat = format
+	return r
+}
+
+// formatInstruction tells upstream what to reply in words,
+// for dialects without json_schema, whose replies would be validated by callers anyway.
+// DeepSeek also requires the word json in messages for json_object.
+// ref https://api-docs.deepseek.com/guides/json_mode
+func formatInstruction(format *ResponseFormat) Message {
+	content := "Reply with a JSON object only, without any prose or Markdown code fence."
+	if format.Type == ResponseFormatTypeJSONSchema && format.JSONSchema != nil {
+		content = fmt.Sprintf(
+			"Reply with a JSON value only, without any prose or Markdown code fence, which conforms to this JSON Schema:\n%s",
+			format.JSONSchema.Schema,
+		)
+	}
+	return NewSystemMessage(content)
+}
+
+// degradeFormat adapts r for dialects supporting json_object at most,
+// jsonObject is whether the dialect supports json_object.
+func degradeFormat(r Request, jsonObject bool) Request {
+	if r.ResponseFormat == nil || r.ResponseFormat.Type == ResponseFormatTypeText {
+		return r
+	}
+	r.Messages = append(r.Messages[:len(r.Messages):len(r.Messages)], formatInstruction(r.ResponseFormat))
+	if jsonObject && r.ResponseFormat.Type == ResponseFormatTypeJSONSchema {
+		r.ResponseFormat = &ResponseFormat{Type: ResponseFormatTypeJSONObject, JSONSchema: nil}
+	}
+	if !jsonObject {
+		r.ResponseFormat = nil
+	}
+	return r
+}
@@ -0,0 +1,96 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrBusy is returned when no concurrent slot of a model is available before the request context is done.
+var ErrBusy = errors.New("upstream busy")
+
+// limiter holds concurrent slots of a model, which is shared by all callers of a [Client].
+type limiter struct {
+	slots   chan struct{}
+	waiting atomic.Int64
+}
+
+func newLimiter(limit int) *limiter {
+	return &limiter{slots: make(chan struct{}, limit)}
+}
+
+// acquire waits for a slot until ctx is done, whose release must be called once done.
+func (l *limiter) acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		l.waiting.Add(1)
+		defer l.waiting.Add(-1)
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w with %d in queue: %w", ErrBusy, l.waiting.Load(), ctx.Err())
+		}
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-l.slots })
+	}, nil
+}
+
+// Load is how busy a model is.
+type Load struct {
+	Limit    int `json:"limit"`
+	InFlight int `json:"inFlight"`
+	Waiting  int `json:"waiting"` // the queue depth
+}
+
+func (l *limiter) load() Load {
+	return Load{
+		Limit:    cap(l.slots),
+		InFlight: len(l.slots),
+		Waiting:  int(l.waiting.Load()),
+	}
+}
+
+// releaseOnClose releases the slot once the body is closed, which is when a stream ends.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r releaseOnClose) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
+
+// SetConcurrentLimit limits concurrent requests of model, typically the limit of the upstream account.
+// Requests over the limit wait in queue until their context is done, then fail with [ErrBusy].
+// It must be called before any request, as limits are read without lock.
+func (c *Client) SetConcurrentLimit(model ChatModel, limit int) {
+	if c.limiters == nil {
+		c.limiters = make(map[ChatModel]*limiter)
+	}
+	c.limiters[model] = newLimiter(limit)
+}
+
+// Load returns how busy model is, ok is false if it is unlimited.
+func (c *Client) Load(model ChatModel) (load Load, ok bool) {
+	l, ok := c.limiters[model]
+	if !ok {
+		return Load{}, false
+	}
+	return l.load(), true
+}
+
+// acquire a slot of model, release is a no-op if model is unlimited.
+func (c *Client) acquire(ctx context.Context, model ChatModel) (release func(), err error) {
+	l, ok := c.limiters[model]
+	if !ok {
+		return func() {}, nil
+	}
+	return l.acquire(ctx)
+}
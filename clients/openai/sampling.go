@@ -0,0 +1,93 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sampling is optional parameters on how upstream generates, nil or empty as upstream default.
+// Not all vendors accept all of them, see [Dialect.Check].
+type Sampling struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+// maxStop is the most vendors accept, as DeepSeek documents.
+const maxStop = 16
+
+var ErrInvalidSampling = errors.New("invalid sampling")
+
+// Validate checks s in ranges documented by OpenAI, which others follow.
+func (s Sampling) Validate() error {
+	inRange := func(name string, v *float64, lower, upper float64) error {
+		if v != nil && (*v < lower || *v > upper) {
+			return fmt.Errorf("%w: %s %v not in [%v, %v]", ErrInvalidSampling, name, *v, lower, upper)
+		}
+		return nil
+	}
+	if err := errors.Join(
+		inRange("temperature", s.Temperature, 0, 2),
+		inRange("top_p", s.TopP, 0, 1),
+		inRange("presence_penalty", s.PresencePenalty, -2, 2),
+		inRange("frequency_penalty", s.FrequencyPenalty, -2, 2),
+	); err != nil {
+		return err
+	}
+	if s.MaxTokens != nil && *s.MaxTokens <= 0 {
+		return fmt.Errorf("%w: max_tokens %d not positive", ErrInvalidSampling, *s.MaxTokens)
+	}
+	if len(s.Stop) > maxStop {
+		return fmt.Errorf("%w: %d stop sequences over %d", ErrInvalidSampling, len(s.Stop), maxStop)
+	}
+	for _, stop := range s.Stop {
+		if stop == "" {
+			return fmt.Errorf("%w: empty stop sequence", ErrInvalidSampling)
+		}
+	}
+	return nil
+}
+
+// Valid is whether e is one of the defined, empty is invalid as callers shall choose a default.
+func (e ReasoningEffort) Valid() bool {
+	switch e {
+	case ReasoningEffortNone, ReasoningEffortHigh, ReasoningEffortMax:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithSampling returns a copy of r with s.
+func (r Request) WithSampling(s Sampling) Request {
+	r.Sampling = s
+	return r
+}
+
+// Check reports parameters of r that the dialect rejects, rather than letting upstream reply 400 later.
+// Those silently ignored by upstream, such as temperature in DeepSeek thinking mode, are not reported.
+func (d Dialect) Check(r Request) error {
+	switch d {
+	case DialectOpenAI:
+		if len(r.Stop) > 4 {
+			return fmt.Errorf("%w: %d stop sequences over 4 of %s", ErrInvalidSampling, len(r.Stop), d)
+		}
+	case DialectAnthropic:
+		switch {
+		case r.PresencePenalty != nil || r.FrequencyPenalty != nil:
+			return fmt.Errorf("%w: penalties unsupported by %s", ErrInvalidSampling, d)
+		case r.Seed != nil:
+			return fmt.Errorf("%w: seed unsupported by %s", ErrInvalidSampling, d)
+		case r.Temperature != nil && *r.Temperature > 1:
+			return fmt.Errorf("%w: temperature %v over 1 of %s", ErrInvalidSampling, *r.Temperature, d)
+		case r.Temperature != nil && anthropicThinkingBudget(r.ReasoningEffort) > 0:
+			return fmt.Errorf("%w: temperature with thinking unsupported by %s", ErrInvalidSampling, d)
+		}
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package preset
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/query"
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository struct {
+	q *query.Query
+}
+
+func NewRepository(db *gorm.DB) (*Repository, error) {
+	return &Repository{
+		q: query.Use(db),
+	}, nil
+}
+
+func (r *Repository) FindByUserID(ctx context.Context, userID int) ([]*model.Preset, error) {
+	return r.q.Preset.WithContext(ctx).
+		Where(r.q.Preset.UserID.Eq(userID)).
+		Order(r.q.Preset.Name).
+		Find()
+}
+
+func (r *Repository) FindByUserIDAndName(ctx context.Context, userID int, name string) (*model.Preset, error) {
+	return r.q.Preset.WithContext(ctx).
+		Where(r.q.Preset.UserID.Eq(userID)).
+		Where(r.q.Preset.Name.Eq(name)).
+		First()
+}
+
+// Save creates item, or replaces the system prompt of the one with the same name.
+func (r *Repository) Save(ctx context.Context, item *model.Preset) error {
+	return r.q.Preset.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: r.q.Preset.UserID.ColumnName().String()}, {Name: r.q.Preset.Name.ColumnName().String()}},
+			DoUpdates: clause.AssignmentColumns([]string{r.q.Preset.SystemPrompt.ColumnName().String()}),
+		}).
+		Create(item)
+}
+
+// DeleteByUserIDAndID returns [gorm.ErrRecordNotFound] if the user has no such preset.
+func (r *Repository) DeleteByUserIDAndID(ctx context.Context, userID int, id int) error {
+	info, err := r.q.Preset.WithContext(ctx).
+		Where(r.q.Preset.UserID.Eq(userID)).
+		Where(r.q.Preset.ID.Eq(id)).
+		Delete()
+	if err != nil {
+		return err
+	}
+	if info.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Package provider routes a model to the upstream that serves it.
+package provider
+
+import (
+	"aiagent/clients/openai"
+	"aiagent/helpers/tokens"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrUnknownModel = errors.New("unknown model")
+
+// Config is what providers are there and which models they serve.
+type Config struct {
+	Providers []ProviderConfig `json:"providers"`
+	// Models is the routing table, whose first item is the default model.
+	Models []ModelConfig `json:"models"`
+}
+
+type ProviderConfig struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"baseURL"`
+	// APIKeyEnv is the name of an environment variable holding the key, so that the file could be shared.
+	// APIKey would be used only if APIKeyEnv is empty, and both empty is fine for local servers.
+	APIKeyEnv string         `json:"apiKeyEnv,omitempty"`
+	APIKey    string         `json:"apiKey,omitempty"`
+	Dialect   openai.Dialect `json:"dialect"`
+}
+
+type ModelConfig struct {
+	Name     openai.ChatModel `json:"name"` // what users ask for
+	Provider string           `json:"provider"`
+	// UpstreamName is what the provider calls it, the same to Name if empty.
+	// It's useful for local servers, whose model names are usually long and volatile paths or tags.
+	UpstreamName openai.ChatModel `json:"upstreamName,omitempty"`
+	// ConcurrentLimit is the limit of upstream, zero as unknown, thus unlimited.
+	// It's enforced on all callers, which wait in queue for slots.
+	// Models routed to the same upstream model of a provider share its slots, so their limits must agree,
+	// even if one is unknown, which would be queued on slots of the others otherwise.
+	ConcurrentLimit int `json:"concurrentLimit,omitempty"`
+	// MaxOutputTokens is the most max_tokens the model accepts, zero as unknown.
+	MaxOutputTokens int `json:"maxOutputTokens,omitempty"`
+	// ContextWindow is the most tokens a request and its answer take together, zero as unknown.
+	// History is compacted to fit in it by Compaction, never if unknown.
+	ContextWindow int        `json:"contextWindow,omitempty"`
+	Compaction    Compaction `json:"compaction,omitempty"`
+	// WindowTurns is how many latest turns are kept at most in [CompactionWindow].
+	WindowTurns int `json:"windowTurns,omitempty"`
+	// Tokenizer is the vocabulary to count tokens offline, by dialect if empty, see [Route.Vocab].
+	Tokenizer tokens.Vocab `json:"tokenizer,omitempty"`
+}
+
+// Compaction is how history is cut to fit in the context window.
+type Compaction string
+
+const (
+	CompactionDrop   Compaction = "drop"   // drop the oldest turns until it fits, the default
+	CompactionWindow Compaction = "window" // keep WindowTurns latest turns at most, then drop as CompactionDrop
+	// CompactionSummarize summarizes older turns with the digest model, the summary is stored and reused.
+	CompactionSummarize Compaction = "summarize"
+)
+
+func (c Compaction) Valid() bool {
+	switch c {
+	case "", CompactionDrop, CompactionWindow, CompactionSummarize:
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultConfig is what aiagent used to be hardcoded, which is DeepSeek only.
+func DefaultConfig(deepSeekAPIKey string) Config {
+	return Config{
+		Providers: []ProviderConfig{{
+			Name:      "deepseek",
+			BaseURL:   "https://api.deepseek.com",
+			APIKeyEnv: "",
+			APIKey:    deepSeekAPIKey,
+			Dialect:   openai.DialectDeepSeek,
+		}},
+		Models: []ModelConfig{{
+			Name:            openai.ChatModelDeepSeekV4Pro,
+			Provider:        "deepseek",
+			UpstreamName:    "",
+			ConcurrentLimit: 500,
+		}, {
+			Name:            openai.ChatModelDeepSeekV4Flash,
+			Provider:        "deepseek",
+			UpstreamName:    "",
+			ConcurrentLimit: 2500,
+		}},
+	}
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret Config
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// Route is where a model goes.
+type Route struct {
+	ModelConfig
+	Dialect openai.Dialect
+	Client  *openai.Client
+}
+
+// Check reports what in r the model can't accept.
+func (r *Route) Check(req openai.Request) error {
+	if err := req.Sampling.Validate(); err != nil {
+		return err
+	}
+	if r.MaxOutputTokens > 0 && req.MaxTokens != nil && *req.MaxTokens > r.MaxOutputTokens {
+		return fmt.Errorf("%w: max_tokens %d over %d of %s",
+			openai.ErrInvalidSampling, *req.MaxTokens, r.MaxOutputTokens, r.Name)
+	}
+	return r.Dialect.Check(req)
+}
+
+// UpstreamModel is what shall be used in [openai.Request].
+func (r *Route) UpstreamModel() openai.ChatModel {
+	if r.UpstreamName == "" {
+		return r.Name
+	}
+	return r.UpstreamName
+}
+
+// Vocab is what counts tokens of the model offline.
+// OpenAI models since gpt-4o take o200k_base, others take cl100k_base, which is close but not exact.
+func (r *Route) Vocab() tokens.Vocab {
+	switch {
+	case r.Tokenizer != "":
+		return r.Tokenizer
+	case r.Dialect == openai.DialectOpenAI:
+		return tokens.VocabO200k
+	default:
+		return tokens.VocabCl100k
+	}
+}
+
+type Registry struct {
+	routes       map[openai.ChatModel]*Route
+	models       []ModelConfig // in order of config
+	defaultModel openai.ChatModel
+}
+
+// upstreamModel is a model as a provider calls it, which models routed to it share.
+type upstreamModel struct {
+	provider string
+	model    openai.ChatModel
+}
+
+func New(config Config) (*Registry, error) {
+	clients := make(map[string]*openai.Client)
+	dialects := make(map[string]openai.Dialect)
+	for _, p := range config.Providers {
+		if _, ok := clients[p.Name]; ok {
+			return nil, fmt.Errorf("duplicated provider %s", p.Name)
+		}
+		if !p.Dialect.Valid() {
+			return nil, fmt.Errorf("provider %s has unknown dialect %q", p.Name, p.Dialect)
+		}
+		apiKey := p.APIKey
+		if p.APIKeyEnv != "" {
+			apiKey = os.Getenv(p.APIKeyEnv)
+		}
+		clients[p.Name] = openai.New(p.BaseURL, apiKey, p.Dialect)
+		dialects[p.Name] = p.Dialect
+	}
+
+	if len(config.Models) == 0 {
+		return nil, errors.New("no model is routed")
+	}
+	routes := make(map[openai.ChatModel]*Route)
+	limits := make(map[upstreamModel]ModelConfig) // the first one routed to each
+	for _, m := range config.Models {
+		if _, ok := routes[m.Name]; ok {
+			return nil, fmt.Errorf("duplicated model %s", m.Name)
+		}
+		client, ok := clients[m.Provider]
+		if !ok {
+			return nil, fmt.Errorf("model %s routes to unknown provider %s", m.Name, m.Provider)
+		}
+		if !m.Compaction.Valid() {
+			return nil, fmt.Errorf("model %s has unknown compaction %q", m.Name, m.Compaction)
+		}
+		if m.Compaction == CompactionWindow && m.WindowTurns <= 0 {
+			return nil, fmt.Errorf("model %s compacts by window without windowTurns", m.Name)
+		}
+		if m.Tokenizer != "" && !m.Tokenizer.Valid() {
+			return nil, fmt.Errorf("model %s has unknown tokenizer %q", m.Name, m.Tokenizer)
+		}
+		route := &Route{ModelConfig: m, Dialect: dialects[m.Provider], Client: client}
+		key := upstreamModel{provider: m.Provider, model: route.UpstreamModel()}
+		if other, ok := limits[key]; ok && other.ConcurrentLimit != m.ConcurrentLimit {
+			return nil, fmt.Errorf("model %s limits %d concurrent requests to %s of %s, but %s limits %d",
+				m.Name, m.ConcurrentLimit, key.model, m.Provider, other.Name, other.ConcurrentLimit)
+		}
+		limits[key] = m
+		if m.ConcurrentLimit > 0 {
+			client.SetConcurrentLimit(route.UpstreamModel(), m.ConcurrentLimit)
+		}
+		routes[m.Name] = route
+	}
+	return &Registry{
+		routes:       routes,
+		models:       config.Models,
+		defaultModel: config.Models[0].Name,
+	}, nil
+}
+
+// Route finds where model goes, empty model as the default one.
+func (r *Registry) Route(model string) (*Route, error) {
+	if model == "" {
+		model = string(r.defaultModel)
+	}
+	ret, ok := r.routes[openai.ChatModel(model)]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownModel, model)
+	}
+	return ret, nil
+}
+
+// Models lists routed models, which is safe to show as there is no secret.
+func (r *Registry) Models() []ModelConfig {
+	return r.models
+}
+
+// ModelLoad is how busy a model is.
+type ModelLoad struct {
+	Name openai.ChatModel `json:"name"`
+	openai.Load
+}
+
+// Load lists load of models with ConcurrentLimit.
+func (r *Registry) Load() []ModelLoad {
+	var ret []ModelLoad
+	for _, m := range r.models {
+		route := r.routes[m.Name]
+		if load, ok := route.Client.Load(route.UpstreamModel()); ok {
+			ret = append(ret, ModelLoad{Name: m.Name, Load: load})
+		}
+	}
+	return ret
+}
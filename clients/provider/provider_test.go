@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"aiagent/clients/openai"
+	"errors"
+	"testing"
+)
+
+func TestRegistry_Route(t *testing.T) {
+	config := DefaultConfig("")
+	config.Providers = append(config.Providers, ProviderConfig{
+		Name:    "ollama",
+		BaseURL: "http://localhost:11434/v1",
+		Dialect: openai.DialectPlain,
+	})
+	config.Models = append(config.Models, ModelConfig{
+		Name:         "local",
+		Provider:     "ollama",
+		UpstreamName: "qwen3:8b",
+	})
+	r, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		model    string
+		upstream openai.ChatModel
+		wantErr  error
+	}{
+		{"default", "", openai.ChatModelDeepSeekV4Pro, nil},
+		{"same name", string(openai.ChatModelDeepSeekV4Flash), openai.ChatModelDeepSeekV4Flash, nil},
+		{"alias", "local", "qwen3:8b", nil},
+		{"unknown", "deepseek-chat", "", ErrUnknownModel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Route(tt.model)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Route() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.UpstreamModel() != tt.upstream {
+				t.Errorf("Route() UpstreamModel got %v, want %v", got.UpstreamModel(), tt.upstream)
+			}
+		})
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"no model", Config{Providers: DefaultConfig("").Providers}},
+		{"unknown provider", Config{Models: DefaultConfig("").Models}},
+		{"unknown dialect", Config{
+			Providers: []ProviderConfig{{Name: "x", Dialect: "anthropic-ish"}},
+			Models:    []ModelConfig{{Name: "m", Provider: "x"}},
+		}},
+		{"unknown compaction", Config{
+			Providers: DefaultConfig("").Providers,
+			Models:    []ModelConfig{{Name: "m", Provider: "deepseek", Compaction: "forget"}},
+		}},
+		{"window without turns", Config{
+			Providers: DefaultConfig("").Providers,
+			Models:    []ModelConfig{{Name: "m", Provider: "deepseek", Compaction: CompactionWindow}},
+		}},
+		{"unknown tokenizer", Config{
+			Providers: DefaultConfig("").Providers,
+			Models:    []ModelConfig{{Name: "m", Provider: "deepseek", Tokenizer: "p50k_base"}},
+		}},
+		{"conflicting limits of one upstream model", Config{
+			Providers: DefaultConfig("").Providers,
+			Models: []ModelConfig{
+				{Name: "m", Provider: "deepseek", UpstreamName: "deepseek-v4-pro", ConcurrentLimit: 10},
+				{Name: "n", Provider: "deepseek", UpstreamName: "deepseek-v4-pro", ConcurrentLimit: 20},
+			},
+		}},
+		{"unknown limit of a limited upstream model", Config{
+			Providers: DefaultConfig("").Providers,
+			Models: []ModelConfig{
+				{Name: "m", Provider: "deepseek", UpstreamName: "deepseek-v4-pro", ConcurrentLimit: 10},
+				{Name: "n", Provider: "deepseek", UpstreamName: "deepseek-v4-pro"},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); err == nil {
+				t.Errorf("New() want error got nil")
+			}
+		})
+	}
+}
+
+func TestNew_sharedLimit(t *testing.T) {
+	config := Config{
+		Providers: DefaultConfig("").Providers,
+		Models: []ModelConfig{
+			{Name: "m", Provider: "deepseek", UpstreamName: "deepseek-v4-pro", ConcurrentLimit: 10},
+			{Name: "n", Provider: "deepseek", UpstreamName: "deepseek-v4-pro", ConcurrentLimit: 10},
+		},
+	}
+	r, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for _, load := range r.Load() {
+		if load.Limit != 10 {
+			t.Errorf("Load() of %s got %+v, want limit 10", load.Name, load.Load)
+		}
+	}
+}
+
+func TestRoute_Check(t *testing.T) {
+	ptr := func(v float64) *float64 { return &v }
+	tokens := 100_000
+	config := Config{
+		Providers: []ProviderConfig{{Name: "a", Dialect: openai.DialectAnthropic}},
+		Models:    []ModelConfig{{Name: "claude", Provider: "a", MaxOutputTokens: 64_000}},
+	}
+	r, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	route, _ := r.Route("")
+
+	tests := []struct {
+		name     string
+		effort   openai.ReasoningEffort
+		sampling openai.Sampling
+		valid    bool
+	}{
+		{"default", openai.ReasoningEffortHigh, openai.Sampling{}, true},
+		{"temperature", openai.ReasoningEffortNone, openai.Sampling{Temperature: ptr(0.3)}, true},
+		{"temperature out of range", openai.ReasoningEffortNone, openai.Sampling{Temperature: ptr(-1)}, false},
+		{"temperature over dialect", openai.ReasoningEffortNone, openai.Sampling{Temperature: ptr(1.5)}, false},
+		{"temperature with thinking", openai.ReasoningEffortHigh, openai.Sampling{Temperature: ptr(0.3)}, false},
+		{"penalty", openai.ReasoningEffortNone, openai.Sampling{PresencePenalty: ptr(1)}, false},
+		{"max tokens", openai.ReasoningEffortNone, openai.Sampling{MaxTokens: &tokens}, false},
+		{"empty stop", openai.ReasoningEffortNone, openai.Sampling{Stop: []string{""}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := route.Check(openai.NewRequest(nil, "claude", tt.effort).WithSampling(tt.sampling))
+			if (err == nil) != tt.valid {
+				t.Errorf("Check() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package report
+
+import (
+	"aiagent/clients/query"
+	"context"
+	"slices"
+
+	"gorm.io/gen/field"
+	"gorm.io/gorm"
+)
+
+// Dimension is what usages are grouped by.
+type Dimension string
+
+const (
+	DimensionDay     Dimension = "day" // of the chat in local time of the server
+	DimensionModel   Dimension = "model"
+	DimensionSession Dimension = "session" // with its user, as scoped IDs are per user
+	DimensionUser    Dimension = "user"
+)
+
+// Dimensions are all those supported, in the order of columns.
+var Dimensions = []Dimension{DimensionDay, DimensionModel, DimensionUser, DimensionSession}
+
+// Row sums up results in a group, whose dimensions not grouped by are left zero.
+// Rows of the same group are told apart by Unit, as costs in different currencies never add up.
+type Row struct {
+	Day             string `json:",omitempty"`
+	Model           string `json:",omitempty"`
+	UserID          *int   `json:",omitempty"`
+	SessionScopedID *int   `json:",omitempty"`
+
+	Chats                int64 // answered, each with one result
+	PromptTokens         int64
+	CompletionTokens     int64
+	CachedTokens         int64
+	ReasoningTokens      int64
+	PromptCacheHitTokens int64
+	CacheHitRatio        float64 // PromptCacheHitTokens / PromptTokens, 0 if no prompt
+	ReasoningShare       float64 // ReasoningTokens / CompletionTokens, 0 if no completion
+
+	CostNano int64 // in billionths of Unit, by prices when results are saved
+	// Unit is empty for results not priced, whose models have no price at the time,
+	// or which are saved before costs are stored until they are backfilled.
+	Unit string
+}
+
+// Filter limits results to report.
+type Filter struct {
+	UserID *int  // nil for all users
+	Since  int64 // in unix milliseconds of chats, inclusive
+	Until  int64 // exclusive
+}
+
+type Repository struct {
+	q *query.Query
+}
+
+func NewRepository(db *gorm.DB) (*Repository, error) {
+	return &Repository{
+		q: query.Use(db),
+	}, nil
+}
+
+// Sum returns usages of results matching filter, grouped by dimensions and in order of them.
+// Dimensions unknown are ignored, the caller shall validate them against [Dimensions].
+func (r *Repository) Sum(ctx context.Context, filter *Filter, dimensions []Dimension) ([]*Row, error) {
+	res, c, s := r.q.Result, r.q.Chat, r.q.Session
+	var groups []field.Expr
+	var aliases []string
+	group := func(expr field.Expr, alias string) {
+		groups = append(groups, expr.As(alias))
+		aliases = append(aliases, alias)
+	}
+	for _, dimension := range Dimensions {
+		if !slices.Contains(dimensions, dimension) {
+			continue
+		}
+		switch dimension {
+		case DimensionDay:
+			group(field.NewUnsafeFieldRaw("date(? / 1000, 'unixepoch', 'localtime')", c.CreateTime), "day")
+		case DimensionModel:
+			group(res.Model, "model")
+		case DimensionUser:
+			group(s.UserID, "user_id")
+		case DimensionSession:
+			if !slices.Contains(dimensions, DimensionUser) {
+				group(s.UserID, "user_id")
+			}
+			group(s.ScopedID, "session_scoped_id")
+		}
+	}
+	group(res.CostUnit, "unit")
+
+	selects := append(groups,
+		res.ID.Count().As("chats"),
+		res.PromptTokens.Sum().As("prompt_tokens"),
+		res.CompletionTokens.Sum().As("completion_tokens"),
+		res.CachedTokens.Sum().As("cached_tokens"),
+		res.ReasoningTokens.Sum().As("reasoning_tokens"),
+		res.PromptCacheHitTokens.Sum().As("prompt_cache_hit_tokens"),
+		ratio(res.PromptCacheHitTokens, res.PromptTokens).As("cache_hit_ratio"),
+		ratio(res.ReasoningTokens, res.CompletionTokens).As("reasoning_share"),
+		field.NewUnsafeFieldRaw("IFNULL(SUM(?), 0)", res.CostNano).As("cost_nano"), // null if none is priced
+	)
+	do := res.WithContext(ctx).
+		Select(selects...).
+		Join(c, c.ID.EqCol(res.ChatID)).
+		Join(s, s.ID.EqCol(c.SessionID)).
+		Where(c.CreateTime.Gte(filter.Since), c.CreateTime.Lt(filter.Until))
+	if filter.UserID != nil {
+		do = do.Where(s.UserID.Eq(*filter.UserID))
+	}
+	// By aliases rather than expressions, which are not the same in SQL once their vars are bound again.
+	var columns []field.Expr
+	for _, alias := range aliases {
+		columns = append(columns, field.NewUnsafeFieldRaw(alias))
+	}
+	var ret []*Row
+	err := do.Group(columns...).Order(columns...).Scan(&ret)
+	return ret, err
+}
+
+// ratio is the sum of part over that of whole, 0 if the latter is.
+func ratio(part, whole field.Expr) field.Expr {
+	return field.NewUnsafeFieldRaw("IFNULL(1.0 * SUM(?) / NULLIF(SUM(?), 0), 0)", part, whole)
+}
@@ -0,0 +1,23 @@
+package session
+
+import (
+	"aiagent/clients/generated"
+	"aiagent/clients/model"
+	"context"
+	"strings"
+)
+
+// Search returns at most limit chats of the user matching all terms, in inputs or answers, the most relevant first.
+// Terms are matched as they are, case-insensitively, rather than as FTS5 query syntax.
+func (r *Repository) Search(ctx context.Context, userID int, terms []string, limit int) ([]model.SearchHit, error) {
+	return generated.SearchQuery[any](r.db).FindHitsByUserID(ctx, match(terms), userID, limit)
+}
+
+// match quotes each of terms as an FTS5 string, all of which shall match.
+func match(terms []string) string {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(quoted, " ")
+}
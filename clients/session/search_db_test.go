@@ -0,0 +1,128 @@
+//go:build sqlite_fts5
+
+package session
+
+import (
+	"aiagent/clients/model"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newSearchRepository returns a [Repository] on an in-memory DB of docs/ddl.sql, where users 1 and 2
+// each have a session with a chat, and that of user 1 is answered.
+func newSearchRepository(t *testing.T) (*Repository, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open("file::memory:?_foreign_keys=on"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1) // each connection opens its own memory database
+	ddl, err := os.ReadFile("../../docs/ddl.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		string(ddl),
+		"INSERT INTO users VALUES (1, 'u', 0), (2, 'v', 0)",
+		"INSERT INTO sessions (id, name, user_id, scoped_id, system_prompt) VALUES (10, 'a', 1, 1, ''), (20, 'b', 2, 1, '')",
+		"INSERT INTO chats (id, session_id, input, create_time) VALUES (100, 10, 'how does gorm preload work', 0)",
+		"INSERT INTO chats (id, session_id, input, create_time) VALUES (200, 20, 'gorm preload of user two', 0)",
+		`INSERT INTO results (id, chat_id, chat_completion_id, created, model, system_fingerprint, finish_reason, role, content,
+			reasoning_content, prompt_tokens, completion_tokens, cached_tokens, reasoning_tokens, prompt_cache_hit_tokens,
+			reasoning_effort, stop, status, cost_nano, cost_unit, route)
+			VALUES (1000, 100, 'c', 0, 'm', '', 'stop', 'assistant', 'call Preload on the query', '', 0, 0, 0, 0, 0,
+			'', 'null', 'complete', NULL, '', 'm')`,
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ret, err := NewRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret, db
+}
+
+func TestRepository_Search(t *testing.T) {
+	r, db := newSearchRepository(t)
+	ctx := context.Background()
+	search := func(t *testing.T, userID int, terms ...string) []model.SearchHit {
+		t.Helper()
+		hits, err := r.Search(ctx, userID, terms, 10)
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		return hits
+	}
+	exec := func(t *testing.T, sql string) {
+		t.Helper()
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("only of the user", func(t *testing.T) {
+		hits := search(t, 1, "preload")
+		if len(hits) != 2 {
+			t.Fatalf("Search() = %+v, want the input and the answer of chat 100", hits)
+		}
+		got := map[string]string{}
+		for _, hit := range hits {
+			if hit.ChatID != 100 || hit.SessionScopedID != 1 || hit.SessionName != "a" {
+				t.Errorf("Search() hit %+v, want of chat 100 in session a", hit)
+			}
+			got[hit.Source] = hit.Snippet
+		}
+		want := map[string]string{"input": "how does gorm **preload** work", "content": "call **Preload** on the query"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Search() snippets = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("all terms", func(t *testing.T) {
+		hits := search(t, 2, "gorm", "two")
+		if len(hits) != 1 || hits[0].ChatID != 200 || hits[0].Snippet != "**gorm** preload of user **two**" {
+			t.Errorf("Search() = %+v, want the input of chat 200", hits)
+		}
+		if hits := search(t, 2, "gorm", "three"); len(hits) != 0 {
+			t.Errorf("Search() = %+v, want none", hits)
+		}
+	})
+
+	t.Run("reindexed once edited", func(t *testing.T) {
+		exec(t, "UPDATE chats SET input = 'how do triggers work' WHERE id = 100")
+		exec(t, "UPDATE results SET content = 'as the query runs' WHERE id = 1000")
+		if hits := search(t, 1, "preload"); len(hits) != 0 {
+			t.Errorf("Search() of what's edited away = %+v, want none", hits)
+		}
+		hits := search(t, 1, "triggers")
+		if len(hits) != 1 || hits[0].Source != "input" || hits[0].Snippet != "how do **triggers** work" {
+			t.Errorf("Search() of the edited input = %+v", hits)
+		}
+		hits = search(t, 1, "query runs")
+		if len(hits) != 1 || hits[0].Source != "content" || hits[0].Snippet != "as the **query runs**" {
+			t.Errorf("Search() of the edited content = %+v", hits)
+		}
+	})
+
+	t.Run("unindexed once deleted", func(t *testing.T) {
+		exec(t, "DELETE FROM results WHERE id = 1000")
+		exec(t, "DELETE FROM chats WHERE id = 100")
+		if hits := search(t, 1, "work"); len(hits) != 0 {
+			t.Errorf("Search() of what's deleted = %+v, want none", hits)
+		}
+		if hits := search(t, 1, "query"); len(hits) != 0 {
+			t.Errorf("Search() of what's deleted = %+v, want none", hits)
+		}
+	})
+}
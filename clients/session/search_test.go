@@ -0,0 +1,23 @@
+package session
+
+import "testing"
+
+func Test_match(t *testing.T) {
+	tests := []struct {
+		name  string
+		terms []string
+		want  string
+	}{
+		{"one", []string{"gorm"}, `"gorm"`},
+		{"all of", []string{"gorm", "preload"}, `"gorm" "preload"`},
+		{"syntax as it is", []string{"NOT", "a*", "col:x", "(y)"}, `"NOT" "a*" "col:x" "(y)"`},
+		{"quote escaped", []string{`say "hi"`}, `"say ""hi"""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := match(tt.terms); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -40,6 +40,7 @@ func (r *Repository) FindWithChats(ctx context.Context, id int) (*model.Session,
 	return r.q.Session.WithContext(ctx).
 		Where(r.q.Session.ID.Eq(id)).
 		Preload(r.q.Session.Chats).
+		Preload(r.q.Session.Chats.Steps).
 		Preload(r.q.Session.Chats.Result).
 		First()
 }
@@ -49,50 +50,143 @@ func (r *Repository) FindWithChatsByUserIDAndScopedID(ctx context.Context, userI
 		Where(r.q.Session.UserID.Eq(userID)).
 		Where(r.q.Session.ScopedID.Eq(scopedID)).
 		Preload(r.q.Session.Chats).
+		Preload(r.q.Session.Chats.Steps).
 		Preload(r.q.Session.Chats.Result).
 		First()
 }
 
+func (r *Repository) FindWithChatsByUserID(ctx context.Context, userID int) ([]*model.Session, error) {
+	return r.q.Session.WithContext(ctx).
+		Where(r.q.Session.UserID.Eq(userID)).
+		Preload(r.q.Session.Chats).
+		Preload(r.q.Session.Chats.Result).
+		Find()
+}
+
 func (r *Repository) Save(ctx context.Context, item model.Session) error {
 	return r.q.Session.WithContext(ctx).Save(&item)
 }
 
-func (r *Repository) Create(ctx context.Context, userID int, name string) error {
+func (r *Repository) Create(ctx context.Context, userID int, name string, systemPrompt string) error {
 	return r.q.Transaction(func(tx *query.Query) error {
-		users, err := tx.User.WithContext(ctx).Where(tx.User.ID.Eq(userID)).Find()
+		scopedID, err := nextScopedID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		return tx.Session.WithContext(ctx).Create(&model.Session{
+			ID:              0,
+			Name:            name,
+			UserID:          userID,
+			ScopedID:        scopedID,
+			SystemPrompt:    systemPrompt,
+			ActiveChatID:    nil,
+			OriginSessionID: nil,
+			OriginChatID:    nil,
+			Chats:           nil,
+		})
+	})
+}
+
+// nextScopedID allocates a scoped ID for a new session of the user in tx.
+func nextScopedID(ctx context.Context, tx *query.Query, userID int) (int, error) {
+	users, err := tx.User.WithContext(ctx).Where(tx.User.ID.Eq(userID)).Find()
+	if err != nil {
+		return 0, err
+	}
+	var scopedID int
+	if len(users) == 0 {
+		// 404 means we have lost synchronization with its user-auth module,
+		// which could be a designed behavior as lazy sync.
+		// Because it passed auth, here we trust it. Create a place-holder user.
+		if err := tx.User.WithContext(ctx).Create(&model.User{
+			ID:               userID,
+			Nickname:         "auto",
+			SessionsSequence: scopedID,
+		}); err != nil {
+			return 0, err
+		}
+	} else {
+		scopedID = users[0].SessionsSequence
+	}
+	scopedID++
+	if _, err := tx.User.WithContext(ctx).
+		Where(tx.User.ID.Eq(userID)).
+		Update(tx.User.SessionsSequence, scopedID); err != nil {
+		return 0, err
+	}
+	return scopedID, nil
+}
+
+// Fork creates a session of the same user as origin, with copies of chats on the path to the chat on chatID,
+// whose copy is active. Origin must have its chats loaded.
+func (r *Repository) Fork(ctx context.Context, origin *model.Session, chatID int, name string) (id int, _ error) {
+	var ret int
+	err := r.q.Transaction(func(tx *query.Query) error {
+		scopedID, err := nextScopedID(ctx, tx, origin.UserID)
 		if err != nil {
 			return err
 		}
-		var scopedID int
-		if len(users) == 0 {
-			// 404 means we have lost synchronization with its user-auth module,
-			// which could be a designed behavior as lazy sync.
-			// Because it passed auth, here we trust it. Create a place-holder user.
-			if err := tx.User.WithContext(ctx).Create(&model.User{
-				ID:               userID,
-				Nickname:         "auto",
-				SessionsSequence: scopedID,
-			}); err != nil {
+		neo := &model.Session{
+			ID:              0,
+			Name:            name,
+			UserID:          origin.UserID,
+			ScopedID:        scopedID,
+			SystemPrompt:    origin.SystemPrompt,
+			ActiveChatID:    nil,
+			OriginSessionID: &origin.ID,
+			OriginChatID:    &chatID,
+			Chats:           nil,
+		}
+		if err := tx.Session.WithContext(ctx).Create(neo); err != nil {
+			return err
+		}
+
+		var parentID *int
+		for _, chat := range origin.PathTo(chatID) {
+			dup := copyChat(chat, neo.ID, parentID)
+			if err := tx.Chat.WithContext(ctx).Create(dup); err != nil {
 				return err
 			}
-		} else {
-			scopedID = users[0].SessionsSequence
+			parentID = &dup.ID
 		}
-		scopedID++
-		if _, err := tx.User.WithContext(ctx).
-			Where(tx.User.ID.Eq(userID)).
-			Update(tx.User.SessionsSequence, scopedID); err != nil {
+		if _, err := tx.Session.WithContext(ctx).
+			Where(tx.Session.ID.Eq(neo.ID)).
+			Update(tx.Session.ActiveChatID, *parentID); err != nil {
 			return err
 		}
-
-		return tx.Session.WithContext(ctx).Create(&model.Session{
-			ID:       0,
-			Name:     name,
-			UserID:   userID,
-			ScopedID: scopedID,
-			Chats:    nil,
-		})
+		ret = neo.ID
+		return nil
 	})
+	return ret, err
+}
+
+// copyChat returns a deep copy of chat to be created in the session on sessionID, following the chat on parentID.
+func copyChat(chat *model.Chat, sessionID int, parentID *int) *model.Chat {
+	var steps []*model.Step
+	for _, step := range chat.Steps {
+		dup := *step
+		dup.ID = 0     // leave null for generated PK
+		dup.ChatID = 0 // leave null for FK fulfilling
+		steps = append(steps, &dup)
+	}
+	var result *model.Result
+	if chat.Result != nil {
+		dup := *chat.Result
+		dup.ID = 0
+		dup.ChatID = 0
+		result = &dup
+	}
+	return &model.Chat{
+		ChatPart: model.ChatPart{
+			ID:         0,
+			SessionID:  sessionID,
+			CreateTime: chat.CreateTime, // when it was said, rather than copied
+		},
+		ParentID: parentID,
+		Input:    chat.Input,
+		Steps:    steps,
+		Result:   result,
+	}
 }
 
 func (r *Repository) FindLastIDByName(ctx context.Context, name string) (int, error) {
@@ -160,3 +254,27 @@ func (r *Repository) UpdateName(ctx context.Context, id int, name string) error
 		Update(ctx)
 	return err
 }
+
+// UpdateSystemPrompt returns [gorm.ErrRecordNotFound] if there is no such session.
+func (r *Repository) UpdateSystemPrompt(ctx context.Context, userID int, scopedID int, systemPrompt string) error {
+	rowsAffected, err := gorm.G[model.Session](r.db).
+		Where(generated.Session.UserID.Eq(userID)).
+		Where(generated.Session.ScopedID.Eq(scopedID)).
+		Set(generated.Session.SystemPrompt.Set(systemPrompt)).
+		Update(ctx)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *Repository) UpdateActiveChatID(ctx context.Context, id int, chatID int) error {
+	_, err := gorm.G[model.Session](r.db).
+		Where(generated.Session.ID.Eq(id)).
+		Set(generated.Session.ActiveChatID.Set(chatID)).
+		Update(ctx)
+	return err
+}
@@ -1,18 +1,40 @@
 -- KEEP SYNC with ddl.sql
 CREATE TABLE chats
 (
-    id          INTEGER PRIMARY KEY ASC,
-    session_id  INTEGER NOT NULL,
-    input       TEXT    NOT NULL,
-    create_time INTEGER NOT NULL,
-    FOREIGN KEY (session_id) REFERENCES sessions (id)
+    id              INTEGER PRIMARY KEY ASC,
+    session_id      INTEGER NOT NULL,
+    input           TEXT    NOT NULL,
+    create_time     INTEGER NOT NULL,
+    parent_id       INTEGER,          -- the previous turn, null for the first one
+    idempotency_key TEXT,             -- of the request creating it, null if none
+    request_digest  TEXT,             -- of the request with idempotency_key, which the key is bound to, null if none
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (parent_id) REFERENCES chats (id)
 ) STRICT;
 
 -- KEEP SYNC with ddl.sql
 CREATE INDEX idx_chats_session_id_id ON chats (session_id, id);
+-- KEEP SYNC with ddl.sql, also to upgrade a database created before usage reports
+CREATE INDEX idx_chats_create_time ON chats (create_time); -- of usage reports over a time range
+
+-- Upgrade chats created before they form a tree, where a superseded one branches from the same parent.
+-- Run the upgrade of sessions in session.sql after it.
+-- ALTER TABLE chats ADD COLUMN superseded INTEGER NOT NULL DEFAULT 0; -- if it's older than that
+-- ALTER TABLE chats ADD COLUMN parent_id INTEGER REFERENCES chats (id);
+-- UPDATE chats
+-- SET parent_id = (SELECT max(p.id)
+--                  FROM chats p
+--                  WHERE p.session_id = chats.session_id
+--                    AND p.id < chats.id
+--                    AND p.superseded = 0);
+
+-- Upgrade chats created before idempotency keys.
+-- ALTER TABLE chats ADD COLUMN idempotency_key TEXT;
+-- Upgrade chats created before idempotency keys are bound to their requests, whose keys are bound to none.
+-- ALTER TABLE chats ADD COLUMN request_digest TEXT;
 
 INSERT INTO chats
-VALUES (NULL, 13, 'an input', 1000);
+VALUES (NULL, 13, 'an input', 1000, NULL, NULL, NULL);
 
 -- KEEP SYNC with ddl.sql
 CREATE TABLE results
@@ -35,12 +57,139 @@ CREATE TABLE results
     reasoning_tokens        INTEGER NOT NULL,
     prompt_cache_hit_tokens INTEGER NOT NULL,
 
+    reasoning_effort        TEXT    NOT NULL, -- empty for those before it's stored
+    temperature             REAL,             -- null as upstream default, so as the following
+    top_p                   REAL,
+    max_tokens              INTEGER,
+    stop                    TEXT    NOT NULL, -- JSON array, null if none
+    presence_penalty        REAL,
+    frequency_penalty       REAL,
+    seed                    INTEGER,
+
+    status                  TEXT    NOT NULL, -- complete, length, interrupted, upstream_error or cancelled
+
+    cost_nano               INTEGER,          -- in billionths of cost_unit by the price when saved, null if before
+    cost_unit               TEXT    NOT NULL, -- ISO 4217, empty if cost_nano is null
+    route                   TEXT    NOT NULL, -- model in the routing table priced by, empty if before
+
     FOREIGN KEY (chat_id) REFERENCES chats (id)
 ) STRICT;
 
+-- KEEP SYNC with ddl.sql, also to upgrade a database created before usage reports
+CREATE INDEX idx_results_chat_id ON results (chat_id);
+
+-- Upgrade results created before sampling parameters are stored.
+-- ALTER TABLE results ADD COLUMN reasoning_effort TEXT NOT NULL DEFAULT '';
+-- ALTER TABLE results ADD COLUMN temperature REAL;
+-- ALTER TABLE results ADD COLUMN top_p REAL;
+-- ALTER TABLE results ADD COLUMN max_tokens INTEGER;
+-- ALTER TABLE results ADD COLUMN stop TEXT NOT NULL DEFAULT 'null';
+-- ALTER TABLE results ADD COLUMN presence_penalty REAL;
+-- ALTER TABLE results ADD COLUMN frequency_penalty REAL;
+-- ALTER TABLE results ADD COLUMN seed INTEGER;
+
+-- Upgrade results created before partial answers are stored with a status.
+-- ALTER TABLE results ADD COLUMN status TEXT NOT NULL DEFAULT 'complete';
+-- UPDATE results
+-- SET status = CASE finish_reason
+--                  WHEN 'stop' THEN 'complete'
+--                  WHEN 'length' THEN 'length'
+--                  WHEN '' THEN 'interrupted'
+--                  ELSE 'upstream_error' END;
+
+-- Upgrade results created before costs are stored, then price them by ./aiagent --mode=backfill
+-- ALTER TABLE results ADD COLUMN cost_nano INTEGER;
+-- ALTER TABLE results ADD COLUMN cost_unit TEXT NOT NULL DEFAULT '';
+
+-- Upgrade results created before routes are stored, which are priced by model as upstream names it.
+-- ALTER TABLE results ADD COLUMN route TEXT NOT NULL DEFAULT '';
+
 INSERT INTO results
-VALUES (NULL, 1, 'uuid', 2000, 'deepseek-chat', 'dev', 'stop', 'hijack', 'content', 'reason', 5, 4, 3, 2, 1);
+VALUES (NULL, 1, 'uuid', 2000, 'deepseek-chat', 'dev', 'stop', 'hijack', 'content', 'reason', 5, 4, 3, 2, 1,
+        'high', 0.7, NULL, 1024, '["\n\n"]', NULL, NULL, 42, 'complete', 0, 'XXX', 'deepseek-chat');
+
+-- KEEP SYNC with ddl.sql, also to upgrade a database created before full-text search,
+-- which needs the binary built with -tags sqlite_fts5, then fills indexes of existing rows by rebuild below.
+CREATE VIRTUAL TABLE chats_fts USING fts5(input, content='chats', content_rowid='id', tokenize='trigram');
+
+-- KEEP SYNC with ddl.sql
+CREATE TRIGGER chats_fts_insert AFTER INSERT ON chats BEGIN
+    INSERT INTO chats_fts (rowid, input) VALUES (new.id, new.input);
+END;
+CREATE TRIGGER chats_fts_delete AFTER DELETE ON chats BEGIN
+    INSERT INTO chats_fts (chats_fts, rowid, input) VALUES ('delete', old.id, old.input);
+END;
+CREATE TRIGGER chats_fts_update AFTER UPDATE OF input ON chats BEGIN
+    INSERT INTO chats_fts (chats_fts, rowid, input) VALUES ('delete', old.id, old.input);
+    INSERT INTO chats_fts (rowid, input) VALUES (new.id, new.input);
+END;
+
+-- KEEP SYNC with ddl.sql
+CREATE VIRTUAL TABLE results_fts USING fts5(content, content='results', content_rowid='id', tokenize='trigram');
+
+-- KEEP SYNC with ddl.sql
+CREATE TRIGGER results_fts_insert AFTER INSERT ON results BEGIN
+    INSERT INTO results_fts (rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER results_fts_delete AFTER DELETE ON results BEGIN
+    INSERT INTO results_fts (results_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+CREATE TRIGGER results_fts_update AFTER UPDATE OF content ON results BEGIN -- a partial answer continued
+    INSERT INTO results_fts (results_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO results_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+-- Upgrade a database created before full-text search, after the tables and triggers above.
+-- INSERT INTO chats_fts (chats_fts) VALUES ('rebuild');
+-- INSERT INTO results_fts (results_fts) VALUES ('rebuild');
+
+-- KEEP SYNC with ddl.sql
+CREATE TABLE compactions
+(
+    id          INTEGER PRIMARY KEY ASC,
+    session_id  INTEGER NOT NULL,
+    chat_id     INTEGER NOT NULL, -- the last chat summarized, with those on the path to it
+    summary     TEXT    NOT NULL,
+    create_time INTEGER NOT NULL,
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (chat_id) REFERENCES chats (id)
+) STRICT;
+
+-- KEEP SYNC with ddl.sql
+CREATE INDEX idx_compactions_session_id ON compactions (session_id);
+
+INSERT INTO compactions
+VALUES (NULL, 13, 1, 'The user said an input.', 3000);
+
+-- KEEP SYNC with ddl.sql
+CREATE TABLE steps
+(
+    id                  INTEGER PRIMARY KEY ASC,
+    chat_id             INTEGER NOT NULL,
+    role                TEXT    NOT NULL,
+    content             TEXT    NOT NULL,
+    reasoning_content   TEXT    NOT NULL,
+    tool_calls          TEXT    NOT NULL, -- JSON array of ToolCall, null if none
+    tool_call_id        TEXT    NOT NULL,
+    reasoning_signature TEXT    NOT NULL, -- of reasoning_content in DialectAnthropic, empty if none
+    FOREIGN KEY (chat_id) REFERENCES chats (id)
+) STRICT;
+
+-- KEEP SYNC with ddl.sql
+CREATE INDEX idx_steps_chat_id_id ON steps (chat_id, id);
+
+-- Upgrade steps created before signatures of thinking are stored, whose thinking is dropped in DialectAnthropic.
+-- ALTER TABLE steps ADD COLUMN reasoning_signature TEXT NOT NULL DEFAULT '';
+
+INSERT INTO steps
+VALUES (NULL, 1, 'assistant', '', 'reason', '[{"id":"call_0","type":"function","function":{"name":"clock","arguments":"{}"}}]', '', 'sig'),
+       (NULL, 1, 'tool', '2026-05-06T12:00:00Z', '', 'null', 'call_0', '');
 
 SELECT *
 FROM chats
          JOIN results r on chats.id = r.chat_id;
+
+SELECT *
+FROM chats
+         JOIN steps s on chats.id = s.chat_id
+ORDER BY chats.id, s.id;
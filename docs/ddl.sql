@@ -4,25 +4,48 @@
 
 CREATE TABLE sessions
 (
-    id        INTEGER PRIMARY KEY ASC,
-    name      TEXT    NOT NULL,
-    user_id   INTEGER NOT NULL,
-    scoped_id INTEGER NOT NULL,
-    FOREIGN KEY (user_id) REFERENCES users (id)
+    id                INTEGER PRIMARY KEY ASC,
+    name              TEXT    NOT NULL,
+    user_id           INTEGER NOT NULL,
+    scoped_id         INTEGER NOT NULL,
+    system_prompt     TEXT    NOT NULL, -- empty for none
+    active_chat_id    INTEGER,          -- the end of the branch to follow, null if no chat
+    origin_session_id INTEGER,          -- where it's forked from, null if not a fork, so as the following
+    origin_chat_id    INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (active_chat_id) REFERENCES chats (id),
+    FOREIGN KEY (origin_session_id) REFERENCES sessions (id),
+    FOREIGN KEY (origin_chat_id) REFERENCES chats (id)
 ) STRICT;
 
 CREATE INDEX idx_sessions_user_id_scoped_id ON sessions (user_id, scoped_id);
 
+CREATE TABLE presets
+(
+    id            INTEGER PRIMARY KEY ASC,
+    user_id       INTEGER NOT NULL,
+    name          TEXT    NOT NULL,
+    system_prompt TEXT    NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+) STRICT;
+
+CREATE UNIQUE INDEX idx_presets_user_id_name ON presets (user_id, name);
+
 CREATE TABLE chats
 (
-    id          INTEGER PRIMARY KEY ASC,
-    session_id  INTEGER NOT NULL,
-    input       TEXT    NOT NULL,
-    create_time INTEGER NOT NULL,
-    FOREIGN KEY (session_id) REFERENCES sessions (id)
+    id              INTEGER PRIMARY KEY ASC,
+    session_id      INTEGER NOT NULL,
+    input           TEXT    NOT NULL,
+    create_time     INTEGER NOT NULL,
+    parent_id       INTEGER,          -- the previous turn, null for the first one
+    idempotency_key TEXT,             -- of the request creating it, null if none
+    request_digest  TEXT,             -- of the request with idempotency_key, which the key is bound to, null if none
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (parent_id) REFERENCES chats (id)
 ) STRICT;
 
 CREATE INDEX idx_chats_session_id_id ON chats (session_id, id);
+CREATE INDEX idx_chats_create_time ON chats (create_time); -- of usage reports over a time range
 
 CREATE TABLE results
 (
@@ -44,9 +67,101 @@ CREATE TABLE results
     reasoning_tokens        INTEGER NOT NULL,
     prompt_cache_hit_tokens INTEGER NOT NULL,
 
+    reasoning_effort        TEXT    NOT NULL, -- empty for those before it's stored
+    temperature             REAL,             -- null as upstream default, so as the following
+    top_p                   REAL,
+    max_tokens              INTEGER,
+    stop                    TEXT    NOT NULL, -- JSON array, null if none
+    presence_penalty        REAL,
+    frequency_penalty       REAL,
+    seed                    INTEGER,
+
+    status                  TEXT    NOT NULL, -- complete, length, interrupted, upstream_error or cancelled
+
+    cost_nano               INTEGER,          -- in billionths of cost_unit by the price when saved, null if before
+    cost_unit               TEXT    NOT NULL, -- ISO 4217, empty if cost_nano is null
+    route                   TEXT    NOT NULL, -- model in the routing table priced by, empty if before
+
+    FOREIGN KEY (chat_id) REFERENCES chats (id)
+) STRICT;
+
+CREATE INDEX idx_results_chat_id ON results (chat_id);
+
+-- Full-text search over what users asked and what were answered, tokenized by trigram to match CJK too.
+-- Both index their tables as external content, and are kept in sync by the triggers following them.
+CREATE VIRTUAL TABLE chats_fts USING fts5(input, content='chats', content_rowid='id', tokenize='trigram');
+
+CREATE TRIGGER chats_fts_insert AFTER INSERT ON chats BEGIN
+    INSERT INTO chats_fts (rowid, input) VALUES (new.id, new.input);
+END;
+CREATE TRIGGER chats_fts_delete AFTER DELETE ON chats BEGIN
+    INSERT INTO chats_fts (chats_fts, rowid, input) VALUES ('delete', old.id, old.input);
+END;
+CREATE TRIGGER chats_fts_update AFTER UPDATE OF input ON chats BEGIN
+    INSERT INTO chats_fts (chats_fts, rowid, input) VALUES ('delete', old.id, old.input);
+    INSERT INTO chats_fts (rowid, input) VALUES (new.id, new.input);
+END;
+
+CREATE VIRTUAL TABLE results_fts USING fts5(content, content='results', content_rowid='id', tokenize='trigram');
+
+CREATE TRIGGER results_fts_insert AFTER INSERT ON results BEGIN
+    INSERT INTO results_fts (rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER results_fts_delete AFTER DELETE ON results BEGIN
+    INSERT INTO results_fts (results_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+CREATE TRIGGER results_fts_update AFTER UPDATE OF content ON results BEGIN -- a partial answer continued
+    INSERT INTO results_fts (results_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO results_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TABLE compactions
+(
+    id          INTEGER PRIMARY KEY ASC,
+    session_id  INTEGER NOT NULL,
+    chat_id     INTEGER NOT NULL, -- the last chat summarized, with those on the path to it
+    summary     TEXT    NOT NULL,
+    create_time INTEGER NOT NULL,
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (chat_id) REFERENCES chats (id)
+) STRICT;
+
+CREATE INDEX idx_compactions_session_id ON compactions (session_id);
+
+CREATE TABLE jobs
+(
+    id           INTEGER PRIMARY KEY ASC,
+    user_id      INTEGER NOT NULL,
+    session_id   INTEGER NOT NULL,
+    chat_id      INTEGER,          -- the chat generated, null until it's over or if nothing is saved
+    state        TEXT    NOT NULL, -- queued, running, done or failed
+    payload      TEXT    NOT NULL, -- JSON of the chat request
+    callback_url TEXT    NOT NULL, -- empty for none
+    error        TEXT    NOT NULL,
+    create_time  INTEGER NOT NULL,
+    update_time  INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (chat_id) REFERENCES chats (id)
+) STRICT;
+
+CREATE INDEX idx_jobs_state ON jobs (state);
+
+CREATE TABLE steps
+(
+    id                  INTEGER PRIMARY KEY ASC,
+    chat_id             INTEGER NOT NULL,
+    role                TEXT    NOT NULL,
+    content             TEXT    NOT NULL,
+    reasoning_content   TEXT    NOT NULL,
+    tool_calls          TEXT    NOT NULL, -- JSON array of ToolCall, null if none
+    tool_call_id        TEXT    NOT NULL,
+    reasoning_signature TEXT    NOT NULL, -- of reasoning_content in DialectAnthropic, empty if none
     FOREIGN KEY (chat_id) REFERENCES chats (id)
 ) STRICT;
 
+CREATE INDEX idx_steps_chat_id_id ON steps (chat_id, id);
+
 CREATE TABLE users
 (
     id                INTEGER PRIMARY KEY ASC,
@@ -55,4 +170,29 @@ CREATE TABLE users
 ) STRICT;
 
 INSERT INTO users (id, nickname, sessions_sequence)
-VALUES (0, 'creator', 1000);
\ No newline at end of file
+VALUES (0, 'creator', 1000);
+
+CREATE TABLE budgets
+(
+    user_id        INTEGER PRIMARY KEY ASC,
+    daily_tokens   INTEGER NOT NULL, -- 0 for no limit, so are the others
+    monthly_tokens INTEGER NOT NULL,
+    daily_cost     INTEGER NOT NULL, -- in billionths of unit
+    monthly_cost   INTEGER NOT NULL,
+    unit           TEXT    NOT NULL, -- ISO 4217 currency, empty if no cost is limited
+    FOREIGN KEY (user_id) REFERENCES users (id)
+) STRICT;
+
+CREATE TABLE usages
+(
+    user_id           INTEGER NOT NULL,
+    day               TEXT    NOT NULL, -- YYYY-MM-DD in local time of the server
+    model             TEXT    NOT NULL,
+    prompt_tokens     INTEGER NOT NULL,
+    cached_tokens     INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL,
+    cost              INTEGER NOT NULL, -- in billionths of unit, by the price when it's spent
+    unit              TEXT    NOT NULL, -- ISO 4217 currency, empty if the model is not priced
+    PRIMARY KEY (user_id, day, model, unit), -- as costs in different currencies never add up
+    FOREIGN KEY (user_id) REFERENCES users (id)
+) STRICT;
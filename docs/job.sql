@@ -0,0 +1,22 @@
+-- Upgrade a database created before jobs, by running this file as it is.
+
+-- KEEP SYNC with ddl.sql
+CREATE TABLE jobs
+(
+    id           INTEGER PRIMARY KEY ASC,
+    user_id      INTEGER NOT NULL,
+    session_id   INTEGER NOT NULL,
+    chat_id      INTEGER,          -- the chat generated, null until it's over or if nothing is saved
+    state        TEXT    NOT NULL, -- queued, running, done or failed
+    payload      TEXT    NOT NULL, -- JSON of the chat request
+    callback_url TEXT    NOT NULL, -- empty for none
+    error        TEXT    NOT NULL,
+    create_time  INTEGER NOT NULL,
+    update_time  INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (chat_id) REFERENCES chats (id)
+) STRICT;
+
+-- KEEP SYNC with ddl.sql
+CREATE INDEX idx_jobs_state ON jobs (state);
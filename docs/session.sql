@@ -1,25 +1,64 @@
 -- KEEP SYNC with ddl.sql
 CREATE TABLE sessions
 (
-    id        INTEGER PRIMARY KEY ASC,
-    name      TEXT    NOT NULL,
-    user_id   INTEGER,
-    scoped_id INTEGER NOT NULL
+    id                INTEGER PRIMARY KEY ASC,
+    name              TEXT    NOT NULL,
+    user_id           INTEGER,
+    scoped_id         INTEGER NOT NULL,
+    system_prompt     TEXT    NOT NULL, -- empty for none
+    active_chat_id    INTEGER,          -- the end of the branch to follow, null if no chat
+    origin_session_id INTEGER,          -- where it's forked from, null if not a fork, so as the following
+    origin_chat_id    INTEGER
 ) STRICT;
 
 -- KEEP SYNC with ddl.sql
 CREATE INDEX idx_sessions_user_id_scoped_id ON sessions (user_id, scoped_id);
 
+-- Upgrade sessions created before system prompts.
+-- ALTER TABLE sessions ADD COLUMN system_prompt TEXT NOT NULL DEFAULT '';
+
+-- Upgrade sessions created before chats form a tree, after the upgrade of chats in chat.sql.
+-- ALTER TABLE sessions ADD COLUMN active_chat_id INTEGER REFERENCES chats (id);
+-- UPDATE sessions
+-- SET active_chat_id = (SELECT max(id) FROM chats WHERE session_id = sessions.id AND superseded = 0);
+-- ALTER TABLE chats DROP COLUMN superseded;
+
+-- Upgrade sessions created before they could be forked.
+-- ALTER TABLE sessions ADD COLUMN origin_session_id INTEGER REFERENCES sessions (id);
+-- ALTER TABLE sessions ADD COLUMN origin_chat_id INTEGER REFERENCES chats (id);
+
 INSERT INTO sessions
-VALUES (NULL, 'one', NULL, 0),
-       (NULL, 'two', NULL, 0),
-       (NULL, 'one', NULL, 0),
-       (NULL, 'alex', 17, 0),
-       (NULL, 'alex_more', 17, 0);;
+VALUES (NULL, 'one', NULL, 0, '', NULL, NULL, NULL),
+       (NULL, 'two', NULL, 0, '', NULL, NULL, NULL),
+       (NULL, 'one', NULL, 0, '', NULL, NULL, NULL),
+       (NULL, 'alex', 17, 0, 'You are a pirate.', NULL, NULL, NULL),
+       (NULL, 'alex_more', 17, 0, '', NULL, NULL, NULL);;
 
 SELECT id, name, user_id
 FROM sessions;
 
+-- KEEP SYNC with ddl.sql
+CREATE TABLE presets
+(
+    id            INTEGER PRIMARY KEY ASC,
+    user_id       INTEGER NOT NULL,
+    name          TEXT    NOT NULL,
+    system_prompt TEXT    NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+) STRICT;
+
+-- KEEP SYNC with ddl.sql
+CREATE UNIQUE INDEX idx_presets_user_id_name ON presets (user_id, name);
+
+INSERT INTO presets
+VALUES (NULL, 17, 'code reviewer', 'You review code like a long-time maintainer.'),
+       (NULL, 17, 'translator', 'Translate what I say into English.');
+
+SELECT name, system_prompt
+FROM presets
+WHERE user_id = 17
+ORDER BY name;
+
 SELECT chats.id, session_id, create_time
 FROM chats
          LEFT JOIN sessions ON chats.session_id = sessions.id
@@ -9,4 +9,33 @@ CREATE TABLE users
 -- provide id 0 user for compatibility on FK user_id
 -- KEEP SYNC with ddl.sql
 INSERT INTO users (id, nickname, sessions_sequence)
-VALUES (0, 'creator', 1000);
\ No newline at end of file
+VALUES (0, 'creator', 1000);
+
+-- Upgrade a database created before budgets by the tables below.
+
+-- KEEP SYNC with ddl.sql
+CREATE TABLE budgets
+(
+    user_id        INTEGER PRIMARY KEY ASC,
+    daily_tokens   INTEGER NOT NULL, -- 0 for no limit, so are the others
+    monthly_tokens INTEGER NOT NULL,
+    daily_cost     INTEGER NOT NULL, -- in billionths of unit
+    monthly_cost   INTEGER NOT NULL,
+    unit           TEXT    NOT NULL, -- ISO 4217 currency, empty if no cost is limited
+    FOREIGN KEY (user_id) REFERENCES users (id)
+) STRICT;
+
+-- KEEP SYNC with ddl.sql
+CREATE TABLE usages
+(
+    user_id           INTEGER NOT NULL,
+    day               TEXT    NOT NULL, -- YYYY-MM-DD in local time of the server
+    model             TEXT    NOT NULL,
+    prompt_tokens     INTEGER NOT NULL,
+    cached_tokens     INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL,
+    cost              INTEGER NOT NULL, -- in billionths of unit, by the price when it's spent
+    unit              TEXT    NOT NULL, -- ISO 4217 currency, empty if the model is not priced
+    PRIMARY KEY (user_id, day, model, unit), -- as costs in different currencies never add up
+    FOREIGN KEY (user_id) REFERENCES users (id)
+) STRICT;
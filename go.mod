@@ -4,6 +4,7 @@ go 1.26
 
 require (
 	github.com/hyisen/wf v1.7.0
+	github.com/tiktoken-go/tokenizer v0.8.1 // used by helpers/tokens
 	golang.org/x/term v0.43.0 // used by tools/client UI
 	golang.org/x/text v0.37.0 // used by tools/client Cost
 	gorm.io/cli/gorm v0.2.4
@@ -14,6 +15,7 @@ require (
 
 require (
 	filippo.io/edwards25519 v1.2.0 // indirect
+	github.com/dlclark/regexp2/v2 v2.5.1 // indirect
 	github.com/go-sql-driver/mysql v1.10.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
@@ -12,52 +12,25 @@ type PriceMillPerMToken struct {
 	CachedInput int
 	Output      int
 	Unit        currency.Unit
+	PercentOff  int // of a discount at the time priced, 0 for none
 }
 
-// PriceOrDefault gets input model's price, get zero(free) cost if unmatch.
-//
-// One may consider making [PriceMillPerMToken.Cost] a method of [ChatModel] to accomplish this polymorphism.
-// But since price don't belong to package where [openai.ChatModel] exists, pulling out the enum would create
-// dependency loop between the old and new package [ChatModel] exists.
-// As [OpenAIUsage] and [openai.Request] would require each other's package.
-// One solution is preventing package openai from using [ChatModel] directly,
-// but using String or other interface instead. I introduced enum to help developers finding ChatModel,
-// another layer is not acceptable, thus I leave the cost as an extension of model here.
-// At present, I hold the idea that CostManager.Find(model) is better than model.Cost().
-func PriceOrDefault(model openai.ChatModel) PriceMillPerMToken {
-	// https://api-docs.deepseek.com/zh-cn/quick_start/pricing/
-	// snapshot of deepseek-reasoner from May.6th 2026
-	switch model {
-	case openai.ChatModelDeepSeekV4Flash:
-		return PriceMillPerMToken{
-			Input:       1_000,
-			CachedInput: 20,
-			Output:      2_000,
-			Unit:        currency.CNY,
-		}
-	case openai.ChatModelDeepSeekV4Pro:
-		return PriceMillPerMToken{
-			Input:       3_000,
-			CachedInput: 25,
-			Output:      6_000,
-			Unit:        currency.CNY,
-		}
-	default:
-		return PriceMillPerMToken{
-			Input:       0,
-			CachedInput: 0,
-			Output:      0,
-			Unit:        currency.XXX,
-		}
-	}
+func (p PriceMillPerMToken) Cost(s TokenUsageStat) string {
+	return FormatNano(p.Nano(s), p.Unit)
 }
 
-func (p PriceMillPerMToken) Cost(s TokenUsageStat) string {
-	var ppb int // Parts Per Billion = mill unit per Million
-	ppb += p.Input * s.InputTokens()
-	ppb += p.CachedInput * s.CachedInputTokens()
-	ppb += p.Output * s.OutputTokens()
-	return fmt.Sprintf("%.3f %s", float64(ppb)/1_000_000_000, p.Unit.String())
+// Nano returns the cost of s in billionths of p.Unit, which sums up without rounding.
+func (p PriceMillPerMToken) Nano(s TokenUsageStat) int64 {
+	var ppb int64 // Parts Per Billion = mill unit per Million
+	ppb += int64(p.Input) * int64(s.InputTokens())
+	ppb += int64(p.CachedInput) * int64(s.CachedInputTokens())
+	ppb += int64(p.Output) * int64(s.OutputTokens())
+	return ppb * int64(100-p.PercentOff) / 100
+}
+
+// FormatNano formats nano billionths of unit as [PriceMillPerMToken.Cost] does.
+func FormatNano(nano int64, unit currency.Unit) string {
+	return fmt.Sprintf("%.3f %s", float64(nano)/1_000_000_000, unit.String())
 }
 
 type TokenUsageStat interface {
@@ -42,3 +42,27 @@ func TestPriceMillPerMToken_Cost(t *testing.T) {
 		})
 	}
 }
+
+func TestPriceMillPerMToken_Nano(t *testing.T) {
+	p := PriceMillPerMToken{Input: 3_000, CachedInput: 25, Output: 6_000, Unit: currency.CNY}
+	tests := []struct {
+		name string
+		s    TokenUsageStat
+		off  int
+		want int64
+	}{
+		{"none", Stat([3]int{0, 0, 0}), 0, 0},
+		{"one of each", Stat([3]int{1, 1, 1}), 0, 9_025},
+		{"beyond int32", Stat([3]int{1_000_000, 0, 1_000_000}), 0, 9_000_000_000},
+		{"half off", Stat([3]int{1, 1, 1}), 50, 4_512},
+		{"free", Stat([3]int{1, 1, 1}), 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.PercentOff = tt.off
+			if got := p.Nano(tt.s); got != tt.want {
+				t.Errorf("Nano() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package pricer
+
+import (
+	"aiagent/clients/openai"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"golang.org/x/text/currency"
+)
+
+var ErrUnknownPrice = errors.New("unknown price")
+
+// Config is prices of models over time, see docs/prices.json.
+type Config struct {
+	Prices []PriceConfig `json:"prices"`
+}
+
+type PriceConfig struct {
+	Model openai.ChatModel `json:"model"`
+	// EffectiveFrom is since when the price is charged, until a later one of the same model takes effect.
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	Input         int       `json:"input"` // mill of Unit per million tokens, so are the following
+	CachedInput   int       `json:"cachedInput"`
+	Output        int       `json:"output"`
+	Unit          string    `json:"unit"` // ISO 4217, each price could be in its own
+	// Discounts are off the price in windows of each day, such as off-peak hours, the first one matched applies.
+	Discounts []Discount `json:"discounts,omitempty"`
+}
+
+// Discount is PercentOff the price in a window of each day, in UTC as upstreams announce.
+type Discount struct {
+	From       string `json:"from"` // HH:MM, inclusive
+	To         string `json:"to"`   // HH:MM, exclusive, earlier than From if it crosses midnight
+	PercentOff int    `json:"percentOff"`
+}
+
+// DefaultConfig is what aiagent used to be hardcoded.
+//
+// https://api-docs.deepseek.com/zh-cn/quick_start/pricing/
+// snapshot of deepseek-reasoner from May.6th 2026
+func DefaultConfig() Config {
+	since := time.Date(2026, 5, 6, 0, 0, 0, 0, time.UTC)
+	return Config{Prices: []PriceConfig{{
+		Model:         openai.ChatModelDeepSeekV4Flash,
+		EffectiveFrom: since,
+		Input:         1_000,
+		CachedInput:   20,
+		Output:        2_000,
+		Unit:          currency.CNY.String(),
+		Discounts:     nil,
+	}, {
+		Model:         openai.ChatModelDeepSeekV4Pro,
+		EffectiveFrom: since,
+		Input:         3_000,
+		CachedInput:   25,
+		Output:        6_000,
+		Unit:          currency.CNY.String(),
+		Discounts:     nil,
+	}}}
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret Config
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// Table finds the price of a model at a time.
+//
+// One may consider making [PriceMillPerMToken.Cost] a method of [ChatModel] to accomplish this polymorphism.
+// But since price don't belong to package where [openai.ChatModel] exists, pulling out the enum would create
+// dependency loop between the old and new package [ChatModel] exists.
+// As [OpenAIUsage] and [openai.Request] would require each other's package.
+// One solution is preventing package openai from using [ChatModel] directly,
+// but using String or other interface instead. I introduced enum to help developers finding ChatModel,
+// another layer is not acceptable, thus I leave the cost as an extension of model here.
+// At present, I hold the idea that CostManager.Find(model) is better than model.Cost().
+type Table struct {
+	prices map[openai.ChatModel][]price // in order of effectiveFrom
+}
+
+type price struct {
+	PriceMillPerMToken
+	effectiveFrom time.Time
+	discounts     []window
+}
+
+// window is a discount from and to minutes of a day.
+type window struct {
+	from, to   int
+	percentOff int
+}
+
+// contains returns whether t in UTC falls in w.
+func (w window) contains(t time.Time) bool {
+	t = t.UTC()
+	minute := t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return w.from <= minute && minute < w.to
+	}
+	return w.from <= minute || minute < w.to
+}
+
+func NewTable(config Config) (*Table, error) {
+	prices := make(map[openai.ChatModel][]price)
+	for _, c := range config.Prices {
+		unit, err := currency.ParseISO(c.Unit)
+		if err != nil {
+			return nil, fmt.Errorf("price of %s has bad unit %q: %w", c.Model, c.Unit, err)
+		}
+		if c.Input < 0 || c.CachedInput < 0 || c.Output < 0 {
+			return nil, fmt.Errorf("price of %s is negative", c.Model)
+		}
+		p := price{
+			PriceMillPerMToken: PriceMillPerMToken{
+				Input:       c.Input,
+				CachedInput: c.CachedInput,
+				Output:      c.Output,
+				Unit:        unit,
+				PercentOff:  0,
+			},
+			effectiveFrom: c.EffectiveFrom,
+			discounts:     nil,
+		}
+		for _, d := range c.Discounts {
+			w, err := newWindow(d)
+			if err != nil {
+				return nil, fmt.Errorf("price of %s: %w", c.Model, err)
+			}
+			p.discounts = append(p.discounts, w)
+		}
+		if slices.ContainsFunc(prices[c.Model], func(o price) bool { return o.effectiveFrom.Equal(c.EffectiveFrom) }) {
+			return nil, fmt.Errorf("duplicated price of %s effective from %s", c.Model, c.EffectiveFrom)
+		}
+		prices[c.Model] = append(prices[c.Model], p)
+	}
+	for _, ps := range prices {
+		slices.SortFunc(ps, func(a, b price) int { return a.effectiveFrom.Compare(b.effectiveFrom) })
+	}
+	return &Table{prices: prices}, nil
+}
+
+func newWindow(d Discount) (window, error) {
+	from, err := time.Parse("15:04", d.From)
+	if err != nil {
+		return window{}, fmt.Errorf("bad discount from %q, want HH:MM", d.From)
+	}
+	to, err := time.Parse("15:04", d.To)
+	if err != nil {
+		return window{}, fmt.Errorf("bad discount to %q, want HH:MM", d.To)
+	}
+	if d.PercentOff <= 0 || d.PercentOff > 100 {
+		return window{}, fmt.Errorf("discount %d%% off out of (0, 100]", d.PercentOff)
+	}
+	if from.Equal(to) {
+		return window{}, fmt.Errorf("discount from %s to %s is empty", d.From, d.To)
+	}
+	return window{
+		from:       from.Hour()*60 + from.Minute(),
+		to:         to.Hour()*60 + to.Minute(),
+		percentOff: d.PercentOff,
+	}, nil
+}
+
+// Default is the table of [DefaultConfig].
+func Default() *Table {
+	ret, err := NewTable(DefaultConfig())
+	if err != nil {
+		// DefaultConfig is valid, as it's covered by tests.
+		panic(err)
+	}
+	return ret
+}
+
+// Price returns the price of model effective at, discounted if at falls in a window.
+// [ErrUnknownPrice] tells the model is not priced, or not yet at then, which is never taken as free.
+func (t *Table) Price(model openai.ChatModel, at time.Time) (PriceMillPerMToken, error) {
+	prices := t.prices[model]
+	i, _ := slices.BinarySearchFunc(prices, at, func(p price, at time.Time) int {
+		if p.effectiveFrom.After(at) {
+			return 1
+		}
+		return -1 // so that i is after all effective ones
+	})
+	if i == 0 {
+		return PriceMillPerMToken{}, fmt.Errorf("%w of %s at %s", ErrUnknownPrice, model, at.Format(time.RFC3339))
+	}
+	p := prices[i-1]
+	ret := p.PriceMillPerMToken
+	for _, w := range p.discounts {
+		if w.contains(at) {
+			ret.PercentOff = w.percentOff
+			break
+		}
+	}
+	return ret, nil
+}
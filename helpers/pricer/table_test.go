@@ -0,0 +1,93 @@
+package pricer
+
+import (
+	"aiagent/clients/openai"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDefault(t *testing.T) {
+	at := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	for _, model := range []openai.ChatModel{openai.ChatModelDeepSeekV4Flash, openai.ChatModelDeepSeekV4Pro} {
+		if _, err := Default().Price(model, at); err != nil {
+			t.Errorf("Price(%s) error = %v", model, err)
+		}
+	}
+}
+
+func TestTable_Price(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+	table, err := NewTable(Config{Prices: []PriceConfig{
+		{Model: "m", EffectiveFrom: day(10), Input: 2, Unit: "USD"},
+		{Model: "m", EffectiveFrom: day(1), Input: 1, Unit: "CNY",
+			Discounts: []Discount{{From: "16:30", To: "00:30", PercentOff: 50}, {From: "00:00", To: "08:00", PercentOff: 25}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		model       openai.ChatModel
+		at          time.Time
+		wantInput   int
+		wantUnit    string
+		wantPercent int
+		wantErr     bool
+	}{
+		{"before any", "m", day(1).Add(-time.Second), 0, "", 0, true},
+		{"unknown model", "n", day(5), 0, "", 0, true},
+		{"first effective", "m", day(1).Add(12 * time.Hour), 1, "CNY", 0, false},
+		{"discount from inclusive", "m", day(5).Add(16*time.Hour + 30*time.Minute), 1, "CNY", 50, false},
+		{"discount across midnight", "m", day(5).Add(20 * time.Minute), 1, "CNY", 50, false},
+		{"discount to exclusive, next one matched", "m", day(5).Add(30 * time.Minute), 1, "CNY", 25, false},
+		{"discount in other zone", "m", day(5).Add(17 * time.Hour).In(time.FixedZone("UTC+8", 8*3600)), 1, "CNY", 50, false},
+		{"later effective", "m", day(10), 2, "USD", 0, false},
+		{"later still effective", "m", day(20).Add(20 * time.Minute), 2, "USD", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := table.Price(tt.model, tt.at)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Price() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrUnknownPrice) {
+					t.Errorf("Price() error = %v, want %v", err, ErrUnknownPrice)
+				}
+				return
+			}
+			if got.Input != tt.wantInput || got.Unit.String() != tt.wantUnit || got.PercentOff != tt.wantPercent {
+				t.Errorf("Price() = %+v, want input %d in %s with %d%% off", got, tt.wantInput, tt.wantUnit, tt.wantPercent)
+			}
+		})
+	}
+}
+
+func TestNewTable(t *testing.T) {
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		price   PriceConfig
+		wantErr bool
+	}{
+		{"valid", PriceConfig{Model: "m", EffectiveFrom: since, Unit: "CNY"}, false},
+		{"bad unit", PriceConfig{Model: "m", EffectiveFrom: since, Unit: "yuan"}, true},
+		{"negative", PriceConfig{Model: "m", EffectiveFrom: since, Output: -1, Unit: "CNY"}, true},
+		{"bad window", PriceConfig{Model: "m", EffectiveFrom: since, Unit: "CNY",
+			Discounts: []Discount{{From: "4pm", To: "00:30", PercentOff: 50}}}, true},
+		{"empty window", PriceConfig{Model: "m", EffectiveFrom: since, Unit: "CNY",
+			Discounts: []Discount{{From: "16:30", To: "16:30", PercentOff: 50}}}, true},
+		{"over 100% off", PriceConfig{Model: "m", EffectiveFrom: since, Unit: "CNY",
+			Discounts: []Discount{{From: "16:30", To: "00:30", PercentOff: 101}}}, true},
+		{"duplicated", PriceConfig{Model: "d", EffectiveFrom: since, Unit: "CNY"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Prices: []PriceConfig{{Model: "d", EffectiveFrom: since, Unit: "USD"}, tt.price}}
+			if _, err := NewTable(config); (err != nil) != tt.wantErr {
+				t.Errorf("NewTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+// Package schema validates JSON against a subset of JSON Schema, which is what structured outputs use.
+// Unsupported keywords are ignored rather than rejected, so the validation is never stricter than upstream.
+// ref https://json-schema.org/understanding-json-schema/reference
+// ref https://platform.openai.com/docs/guides/structured-outputs#supported-schemas
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+type Schema struct {
+	root *node
+	defs map[string]*node // $defs and definitions of root, which $ref refers to
+}
+
+type node struct {
+	// boolean schema, nil if it's an object one
+	boolean *bool
+
+	Type                 types             `json:"type"`
+	Properties           map[string]*node  `json:"properties"`
+	Required             []string          `json:"required"`
+	AdditionalProperties *node             `json:"additionalProperties"`
+	Items                *node             `json:"items"`
+	Enum                 []json.RawMessage `json:"enum"`
+	Const                json.RawMessage   `json:"const"`
+	Minimum              *float64          `json:"minimum"`
+	Maximum              *float64          `json:"maximum"`
+	MinLength            *int              `json:"minLength"`
+	MaxLength            *int              `json:"maxLength"`
+	MinItems             *int              `json:"minItems"`
+	MaxItems             *int              `json:"maxItems"`
+	AnyOf                []*node           `json:"anyOf"`
+	AllOf                []*node           `json:"allOf"`
+	Ref                  string            `json:"$ref"`
+	Defs                 map[string]*node  `json:"$defs"`
+	Definitions          map[string]*node  `json:"definitions"`
+}
+
+func (n *node) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		n.boolean = &b
+		return nil
+	}
+	type plain node // drop the method to avoid recursion
+	return json.Unmarshal(data, (*plain)(n))
+}
+
+// types is either a single type or an array of them in JSON.
+type types []string
+
+func (t *types) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = types{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*t = multiple
+	return nil
+}
+
+func Parse(data []byte) (*Schema, error) {
+	var root node
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parse schema: %w", err)
+	}
+	defs := make(map[string]*node)
+	for name, def := range root.Definitions {
+		defs["#/definitions/"+name] = def
+	}
+	for name, def := range root.Defs {
+		defs["#/$defs/"+name] = def
+	}
+	ret := &Schema{root: &root, defs: defs}
+	if err := ret.checkRefs(&root); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// checkRefs fails on refs which can't be resolved, so that Validate never does.
+func (s *Schema) checkRefs(n *node) error {
+	if n == nil {
+		return nil
+	}
+	if n.Ref != "" && n.Ref != "#" {
+		if _, ok := s.defs[n.Ref]; !ok {
+			return fmt.Errorf("parse schema: unsupported $ref %q", n.Ref)
+		}
+	}
+	children := []*node{n.AdditionalProperties, n.Items}
+	children = append(children, n.AnyOf...)
+	children = append(children, n.AllOf...)
+	for _, m := range []map[string]*node{n.Properties, n.Defs, n.Definitions} {
+		for _, child := range m {
+			children = append(children, child)
+		}
+	}
+	for _, child := range children {
+		if err := s.checkRefs(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks data is a JSON instance of s, the error tells where and why not.
+func (s *Schema) Validate(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var instance any
+	if err := decoder.Decode(&instance); err != nil {
+		return fmt.Errorf("not JSON: %w", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("not JSON: extra data after the value")
+	}
+	return s.validate(s.root, instance, "$")
+}
+
+func (s *Schema) validate(n *node, instance any, path string) error {
+	if n.boolean != nil {
+		if !*n.boolean {
+			return fmt.Errorf("%s: not allowed", path)
+		}
+		return nil
+	}
+	switch n.Ref {
+	case "":
+	case "#":
+		return s.validate(s.root, instance, path)
+	default:
+		return s.validate(s.defs[n.Ref], instance, path)
+	}
+
+	if len(n.Type) > 0 && !slices.ContainsFunc(n.Type, func(t string) bool { return typeOf(instance, t) }) {
+		return fmt.Errorf("%s: want %s, got %s", path, strings.Join(n.Type, " or "), describe(instance))
+	}
+	if n.Const != nil && !equal(n.Const, instance) {
+		return fmt.Errorf("%s: want const %s", path, n.Const)
+	}
+	if n.Enum != nil && !slices.ContainsFunc(n.Enum, func(e json.RawMessage) bool { return equal(e, instance) }) {
+		return fmt.Errorf("%s: not in enum", path)
+	}
+	for _, sub := range n.AllOf {
+		if err := s.validate(sub, instance, path); err != nil {
+			return err
+		}
+	}
+	if len(n.AnyOf) > 0 && !slices.ContainsFunc(n.AnyOf, func(sub *node) bool {
+		return s.validate(sub, instance, path) == nil
+	}) {
+		return fmt.Errorf("%s: matches none of anyOf", path)
+	}
+
+	switch v := instance.(type) {
+	case map[string]any:
+		return s.validateObject(n, v, path)
+	case []any:
+		return s.validateArray(n, v, path)
+	case string:
+		length := len([]rune(v))
+		if n.MinLength != nil && length < *n.MinLength {
+			return fmt.Errorf("%s: shorter than %d", path, *n.MinLength)
+		}
+		if n.MaxLength != nil && length > *n.MaxLength {
+			return fmt.Errorf("%s: longer than %d", path, *n.MaxLength)
+		}
+	case json.Number:
+		f, _ := v.Float64() // decoded by json, always a valid number
+		if n.Minimum != nil && f < *n.Minimum {
+			return fmt.Errorf("%s: less than %v", path, *n.Minimum)
+		}
+		if n.Maximum != nil && f > *n.Maximum {
+			return fmt.Errorf("%s: greater than %v", path, *n.Maximum)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(n *node, object map[string]any, path string) error {
+	for _, name := range n.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s: missing required %q", path, name)
+		}
+	}
+	// in order, so that the error is stable
+	for _, name := range slices.Sorted(maps.Keys(object)) {
+		sub, ok := n.Properties[name]
+		if !ok {
+			sub = n.AdditionalProperties
+		}
+		if sub == nil {
+			continue
+		}
+		if err := s.validate(sub, object[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(n *node, array []any, path string) error {
+	if n.MinItems != nil && len(array) < *n.MinItems {
+		return fmt.Errorf("%s: fewer items than %d", path, *n.MinItems)
+	}
+	if n.MaxItems != nil && len(array) > *n.MaxItems {
+		return fmt.Errorf("%s: more items than %d", path, *n.MaxItems)
+	}
+	if n.Items == nil {
+		return nil
+	}
+	for i, item := range array {
+		if err := s.validate(n.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func typeOf(instance any, t string) bool {
+	switch v := instance.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		f, _ := v.Float64()
+		return t == "integer" && f == math.Trunc(f)
+	default:
+		return false
+	}
+}
+
+func describe(instance any) string {
+	for _, t := range []string{"null", "boolean", "string", "array", "object", "integer", "number"} {
+		if typeOf(instance, t) {
+			return t
+		}
+	}
+	return fmt.Sprintf("%T", instance)
+}
+
+// equal compares in decoded values, so that 1.0 equals 1 and key order does not matter.
+func equal(raw json.RawMessage, instance any) bool {
+	var want any
+	if err := json.Unmarshal(raw, &want); err != nil {
+		return false
+	}
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return false
+	}
+	var got any
+	if err := json.Unmarshal(data, &got); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(want, got)
+}
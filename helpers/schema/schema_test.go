@@ -0,0 +1,68 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchema_Validate(t *testing.T) {
+	s, err := Parse([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+			"kind": {"enum": ["a", "b"]},
+			"note": {"type": ["string", "null"]}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"$defs": {"tag": {"type": "string", "maxLength": 3}}
+	}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		instance string
+		wantErr  string // empty for valid
+	}{
+		{"valid", `{"name":"n","age":3,"tags":["x"],"kind":"a","note":null}`, ""},
+		{"integer in float", `{"name":"n","age":3.0}`, ""},
+		{"not JSON", `{"name":`, "not JSON"},
+		{"prose around", `{"name":"n","age":1} done`, "not JSON"},
+		{"missing", `{"name":"n"}`, `$: missing required "age"`},
+		{"type", `{"name":"n","age":"3"}`, "$.age: want integer, got string"},
+		{"fraction", `{"name":"n","age":3.5}`, "$.age: want integer, got number"},
+		{"minimum", `{"name":"n","age":-1}`, "$.age: less than 0"},
+		{"minLength", `{"name":"","age":1}`, "$.name: shorter than 1"},
+		{"ref", `{"name":"n","age":1,"tags":["long"]}`, "$.tags[0]: longer than 3"},
+		{"maxItems", `{"name":"n","age":1,"tags":["a","b","c"]}`, "$.tags: more items than 2"},
+		{"enum", `{"name":"n","age":1,"kind":"c"}`, "$.kind: not in enum"},
+		{"additional", `{"name":"n","age":1,"extra":1}`, "$.extra: not allowed"},
+		{"root type", `[]`, "$: want object, got array"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Validate([]byte(tt.instance))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, schema := range []string{`{"type": 1}`, `{"$ref": "https://example.com/s.json"}`, `[]`} {
+		if _, err := Parse([]byte(schema)); err == nil {
+			t.Errorf("Parse(%s) want error got nil", schema)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package tokens counts tokens of a text offline, by BPE vocabularies embedded in the binary.
+// Only vocabularies of OpenAI are embedded. Others, DeepSeek for example, are counted by [VocabCl100k],
+// which is close as they are byte-level BPE of similar sizes, but not exact.
+package tokens
+
+import (
+	"aiagent/clients/openai"
+	"fmt"
+	"sync"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// Vocab is a BPE vocabulary.
+type Vocab string
+
+const (
+	VocabCl100k Vocab = "cl100k_base" // OpenAI models before gpt-4o, and the stand-in for models of others
+	VocabO200k  Vocab = "o200k_base"  // OpenAI models since gpt-4o
+)
+
+// codecs are loaded on first use, as each takes tens of milliseconds and megabytes.
+var codecs = map[Vocab]func() tokenizer.Codec{
+	VocabCl100k: sync.OnceValue(func() tokenizer.Codec { return mustGet(tokenizer.Cl100kBase) }),
+	VocabO200k:  sync.OnceValue(func() tokenizer.Codec { return mustGet(tokenizer.O200kBase) }),
+}
+
+func mustGet(encoding tokenizer.Encoding) tokenizer.Codec {
+	ret, err := tokenizer.Get(encoding)
+	if err != nil {
+		// Encodings here are all embedded in the library.
+		panic(fmt.Errorf("get codec %s: %w", encoding, err))
+	}
+	return ret
+}
+
+func (v Vocab) Valid() bool {
+	_, ok := codecs[v]
+	return ok
+}
+
+// perMessage is the overhead of a message for its role and separators in chat templates.
+const perMessage = 4
+
+// Count returns how many tokens text takes in v, which must be valid.
+func Count(v Vocab, text string) int {
+	ret, err := codecs[v]().Count(text)
+	if err != nil {
+		// Never seen, as the split regexp matches any text. Bytes are more than tokens anyway.
+		return len(text)
+	}
+	return ret
+}
+
+// Messages returns how many tokens messages take in a request in v, which must be valid.
+func Messages(v Vocab, messages []openai.Message) int {
+	var ret int
+	for _, m := range messages {
+		ret += perMessage + Count(v, m.Content) + Count(v, m.ReasoningContent) + Count(v, m.ToolCallID)
+		for _, call := range m.ToolCalls {
+			ret += Count(v, call.Function.Name) + Count(v, call.Function.Arguments)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,47 @@
+package tokens
+
+import (
+	"aiagent/clients/openai"
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		vocab Vocab
+		text  string
+		want  int
+	}{
+		{"empty", VocabO200k, "", 0},
+		{"ascii", VocabO200k, "hello world", 2},
+		{"ascii cl100k", VocabCl100k, "hello world", 2},
+		{"cjk", VocabO200k, "你好世界", 2},
+		{"cjk cl100k", VocabCl100k, "你好世界", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Count(tt.vocab, tt.text); got != tt.want {
+				t.Errorf("Count(%s, %q) = %v, want %v", tt.vocab, tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessages(t *testing.T) {
+	messages := []openai.Message{
+		openai.NewUserMessage("hello world"),
+		{Role: "assistant", ToolCalls: []openai.ToolCall{{Function: openai.FunctionCall{Name: "now", Arguments: "{}"}}}},
+	}
+	if got, want := Messages(VocabO200k, messages), perMessage+2+perMessage+1+1; got != want {
+		t.Errorf("Messages() = %v, want %v", got, want)
+	}
+}
+
+func TestVocab_Valid(t *testing.T) {
+	if !VocabCl100k.Valid() || !VocabO200k.Valid() {
+		t.Error("embedded vocab is invalid")
+	}
+	if Vocab("p50k_base").Valid() || Vocab("").Valid() {
+		t.Error("unknown vocab is valid")
+	}
+}
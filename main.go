@@ -1,11 +1,21 @@
 package main
 
 import (
+	"aiagent/clients/budget"
 	"aiagent/clients/chat"
+	"aiagent/clients/compaction"
+	"aiagent/clients/job"
+	"aiagent/clients/mcp"
 	"aiagent/clients/openai"
+	"aiagent/clients/preset"
+	"aiagent/clients/provider"
+	"aiagent/clients/report"
 	"aiagent/clients/session"
 	"aiagent/console"
+	"aiagent/helpers/pricer"
 	"aiagent/service"
+	sc "aiagent/service/chat"
+	"aiagent/service/tool"
 	"context"
 	_ "embed"
 	"errors"
@@ -13,10 +23,13 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime/debug"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/hyisen/wf"
@@ -26,10 +39,24 @@ import (
 
 var DeepSeekAPIKey = flag.String("DeepSeekAPIKey", "this_is_a_secret", "API Key from platform.deepseek.com/api_keys")
 
-var mode = flag.String("mode", "server", "app mode from SmokeTest|REPL|server|migrate")
+var providersConfig = flag.String("providers", "", "path to JSON config of providers and models, empty for DeepSeek only with DeepSeekAPIKey")
+
+var pricesConfig = flag.String("prices", "", "path to JSON config of prices over time, empty for the built-in DeepSeek ones")
+
+var digestModel = flag.String("digestModel", string(openai.ChatModelDeepSeekV4Flash), "model in the routing table to generate session names")
+
+var mode = flag.String("mode", "server", "app mode from SmokeTest|REPL|server|migrate|backfill")
 
 var port = flag.Int("port", 8640, "where server mode serve on localhost")
 
+var enableTools = flag.Bool("tools", true, "offer built-in tools to upstream in server mode chats")
+
+var mcpConfig = flag.String("mcpConfig", "", "path to JSON config of MCP servers whose tools are offered, empty to disable")
+
+var jobWorkers = flag.Int("jobWorkers", 2, "how many background chat jobs run at once in server mode")
+
+var fetchAllowHosts = flag.String("fetchAllowHosts", "", "comma separated hosts that tool fetch can GET, empty to disable")
+
 func main() {
 	flag.Parse()
 	switch *mode {
@@ -41,6 +68,8 @@ func main() {
 		server()
 	case "migrate":
 		migrate()
+	case "backfill":
+		backfill()
 	default:
 		log.Fatalf("unsupported mode %s", *mode)
 	}
@@ -72,8 +101,57 @@ func migrate() {
 	}
 }
 
+// backfill prices results saved before costs are stored, by prices effective when they were created.
+// Those whose models are not priced then are left, and logged for the price table to complete.
+func backfill() {
+	prices, err := newPriceTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s?_foreign_keys=on", sqliteDatabaseFilename)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	cr, err := chat.NewRepository(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	var lastID, count, unknown int
+	for {
+		chats, err := cr.FindChatsWithoutCost(ctx, lastID, 500)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(chats) == 0 {
+			break
+		}
+		for _, c := range chats {
+			lastID = c.ID
+			if err := sc.Price(prices, c); err != nil {
+				slog.Warn("result left unpriced", "chat", c.ID, "err", err)
+				unknown++
+				continue
+			}
+			if err := cr.SaveResultCost(ctx, c.Result); err != nil {
+				log.Fatal(err)
+			}
+			count++
+		}
+		slog.Info("results priced", "count", count, "lastChatID", lastID)
+	}
+	slog.Info("backfill done", "count", count, "unpriced", unknown)
+}
+
 func server() {
-	client := openai.New("https://api.deepseek.com", *DeepSeekAPIKey)
+	providers, err := newProviderRegistry()
+	if err != nil {
+		log.Fatal(err)
+	}
+	digestRoute, err := providers.Route(*digestModel)
+	if err != nil {
+		log.Fatal(err)
+	}
 	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s?_foreign_keys=on", sqliteDatabaseFilename)))
 	if err != nil {
 		log.Fatal(err)
@@ -86,11 +164,47 @@ func server() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	pr, err := preset.NewRepository(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cpr, err := compaction.NewRepository(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jr, err := job.NewRepository(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	br, err := budget.NewRepository(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rr, err := report.NewRepository(db)
+	if err != nil {
+		log.Fatal(err)
+	}
 	bi, ok := debug.ReadBuildInfo()
 	if !ok {
 		log.Fatal("no build info")
 	}
-	s := service.New(client, sr, cr, bi)
+	tools, err := newToolRegistry(sr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	prices, err := newPriceTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, m := range providers.Models() {
+		if _, err := prices.Price(m.Name, time.Now()); err != nil {
+			slog.Warn("model routed without price, its chats are left unpriced", "err", err)
+		}
+	}
+	s := service.New(providers, digestRoute, tools, sr, cr, pr, cpr, jr, *jobWorkers, br, rr, prices, bi)
+	if err := s.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 	local, err := url.Parse(fmt.Sprintf("http://localhost:%d", *port))
 	if err != nil {
 		log.Fatal(err)
@@ -105,6 +219,90 @@ func server() {
 	}
 }
 
+func newProviderRegistry() (*provider.Registry, error) {
+	config := provider.DefaultConfig(*DeepSeekAPIKey)
+	if *providersConfig != "" {
+		loaded, err := provider.LoadConfig(*providersConfig)
+		if err != nil {
+			return nil, err
+		}
+		config = *loaded
+	}
+	ret, err := provider.New(config)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("providers registered", "providers", len(config.Providers), "models", len(config.Models))
+	return ret, nil
+}
+
+func newPriceTable() (*pricer.Table, error) {
+	config := pricer.DefaultConfig()
+	if *pricesConfig != "" {
+		loaded, err := pricer.LoadConfig(*pricesConfig)
+		if err != nil {
+			return nil, err
+		}
+		config = *loaded
+	}
+	ret, err := pricer.NewTable(config)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("prices loaded", "prices", len(config.Prices))
+	return ret, nil
+}
+
+func newToolRegistry(sr *session.Repository) (*tool.Registry, error) {
+	if !*enableTools {
+		return &tool.Registry{}, nil
+	}
+	tools := []tool.Tool{tool.Clock{}, tool.Calculator{}, tool.NewSessions(sr)}
+	if *fetchAllowHosts != "" {
+		tools = append(tools, tool.NewFetch(strings.Split(*fetchAllowHosts, ",")))
+	}
+	if *mcpConfig != "" {
+		mcpTools, err := dialMCPServers(*mcpConfig)
+		if err != nil {
+			return nil, err
+		}
+		tools = append(tools, mcpTools...)
+	}
+	ret, err := tool.NewRegistry(tools...)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("tools registered", "names", ret.Names())
+	return ret, nil
+}
+
+// dialMCPServers connects to all servers in config, and returns what they provide as tools.
+// Connections are kept for the whole life of the process, so there is no close.
+func dialMCPServers(configPath string) ([]tool.Tool, error) {
+	config, err := mcp.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	// Launching a stdio server could take a while, such as npx downloading packages.
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var ret []tool.Tool
+	for _, name := range slices.Sorted(maps.Keys(config.Servers)) {
+		client, err := mcp.Dial(ctx, name, config.Servers[name])
+		if err != nil {
+			return nil, err
+		}
+		tools, err := tool.NewMCPTools(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("MCP server connected", "name", name, "server", client.Server().ServerInfo)
+		ret = append(ret, tools...)
+	}
+	return ret, nil
+}
+
 type REPLLineHandler struct {
 	history []openai.Message
 	client  *openai.Client
@@ -134,14 +332,14 @@ func (h *REPLLineHandler) HandleInput(line string) {
 }
 
 func repl() {
-	client := openai.New("https://api.deepseek.com", *DeepSeekAPIKey)
+	client := openai.New("https://api.deepseek.com", *DeepSeekAPIKey, openai.DialectDeepSeek)
 	handler := NewREPLLineHandler(client)
 	controller := console.NewController(handler, console.NewDefaultOptions(), &console.NotMultiLineChecker{})
 	controller.Run()
 }
 
 func basic() {
-	client := openai.New("https://api.deepseek.com", *DeepSeekAPIKey)
+	client := openai.New("https://api.deepseek.com", *DeepSeekAPIKey, openai.DialectDeepSeek)
 	req := openai.NewRequest(
 		[]openai.Message{{
 			Role:    "user",
@@ -0,0 +1,158 @@
+package budget
+
+import (
+	"aiagent/clients/budget"
+	"aiagent/clients/model"
+	"aiagent/helpers/pricer"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hyisen/wf"
+	"golang.org/x/text/currency"
+	"gorm.io/gorm"
+)
+
+// Service accounts what users spend, and keeps them within their budgets.
+type Service struct {
+	budgetRepository *budget.Repository
+	now              func() time.Time
+}
+
+func NewService(budgetRepository *budget.Repository) *Service {
+	return &Service{budgetRepository: budgetRepository, now: time.Now}
+}
+
+// View is the budget of a user with what's spent in the current day and month.
+type View struct {
+	*model.Budget
+	Day   Spent
+	Month Spent
+}
+
+// Spent sums usages in a period, the cost is of those priced in the unit of the budget.
+type Spent struct {
+	Since  string // the first day of the period
+	Tokens int64
+	Cost   int64
+}
+
+// Check returns an error if the user has used up a budget, 402 for costs and 429 for tokens, which reset later.
+// What's spent by the chat to go is unknown, so that the last one could go beyond a budget.
+func (s *Service) Check(ctx context.Context, userID int) *wf.CodedError {
+	view, e := s.Find(ctx, userID)
+	if e != nil {
+		return e
+	}
+	return view.exhausted(s.now())
+}
+
+// Spend accounts result answered to the user, by the cost stored on it.
+func (s *Service) Spend(ctx context.Context, userID int, result *model.Result) {
+	usage := result.ChatCompletion().Usage
+	var cost int64
+	if result.CostNano != nil {
+		cost = *result.CostNano
+	}
+	item := &model.Usage{
+		UserID:           userID,
+		Day:              s.now().Format(model.UsageDayLayout),
+		Model:            string(result.PricedModel()),
+		PromptTokens:     int64(usage.PromptTokens),
+		CachedTokens:     int64(usage.PromoteTokensDetails.CachedTokens),
+		CompletionTokens: int64(usage.CompletionTokens),
+		Cost:             cost, // 0 in no unit if its model is not priced
+		Unit:             result.CostUnit,
+	}
+	if err := s.budgetRepository.AddUsage(ctx, item); err != nil {
+		// The answer is saved, and it's better to lose an account than the answer.
+		slog.Error("can not account usage", "usage", item, "err", err)
+	}
+}
+
+// Find returns the budget of the user, an unlimited one if none is set.
+func (s *Service) Find(ctx context.Context, userID int) (*View, *wf.CodedError) {
+	item, err := s.budgetRepository.FindByUserID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		item, err = &model.Budget{UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	day, month := periods(s.now())
+	usages, err := s.budgetRepository.FindUsagesByUserIDSince(ctx, userID, month)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return &View{
+		Budget: item,
+		Day:    sum(usages, day, item.Unit),
+		Month:  sum(usages, month, item.Unit),
+	}, nil
+}
+
+// Update replaces the budget of item.UserID.
+func (s *Service) Update(ctx context.Context, item *model.Budget) (*View, *wf.CodedError) {
+	if item.DailyTokens < 0 || item.MonthlyTokens < 0 || item.DailyCost < 0 || item.MonthlyCost < 0 {
+		return nil, wf.NewCodedErrorf(http.StatusBadRequest, "negative budget, use 0 for no limit")
+	}
+	if item.Unit == "" && (item.DailyCost > 0 || item.MonthlyCost > 0) {
+		return nil, wf.NewCodedErrorf(http.StatusBadRequest, "no Unit for costs")
+	}
+	if item.Unit != "" {
+		if _, err := currency.ParseISO(item.Unit); err != nil {
+			return nil, wf.NewCodedErrorf(http.StatusBadRequest, "bad Unit %q: %v", item.Unit, err)
+		}
+	}
+	if err := s.budgetRepository.Save(ctx, item); err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return s.Find(ctx, item.UserID)
+}
+
+// exhausted returns an error telling which budget of v is used up at now, nil if none.
+func (v *View) exhausted(now time.Time) *wf.CodedError {
+	y, m, d := now.Date()
+	tomorrow := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	nextMonth := time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location())
+	unit, _ := currency.ParseISO(v.Unit) // validated when it's saved
+	switch {
+	case v.DailyCost > 0 && v.Day.Cost >= v.DailyCost:
+		return wf.NewCodedErrorf(http.StatusPaymentRequired, "daily cost budget %s used up until %s",
+			pricer.FormatNano(v.DailyCost, unit), tomorrow.Format(time.RFC3339))
+	case v.MonthlyCost > 0 && v.Month.Cost >= v.MonthlyCost:
+		return wf.NewCodedErrorf(http.StatusPaymentRequired, "monthly cost budget %s used up until %s",
+			pricer.FormatNano(v.MonthlyCost, unit), nextMonth.Format(time.RFC3339))
+	case v.DailyTokens > 0 && v.Day.Tokens >= v.DailyTokens:
+		return wf.NewCodedErrorf(http.StatusTooManyRequests, "daily budget of %d tokens used up until %s",
+			v.DailyTokens, tomorrow.Format(time.RFC3339))
+	case v.MonthlyTokens > 0 && v.Month.Tokens >= v.MonthlyTokens:
+		return wf.NewCodedErrorf(http.StatusTooManyRequests, "monthly budget of %d tokens used up until %s",
+			v.MonthlyTokens, nextMonth.Format(time.RFC3339))
+	default:
+		return nil
+	}
+}
+
+// periods returns the first days of the day and the month of now, in [model.UsageDayLayout].
+func periods(now time.Time) (day string, month string) {
+	y, m, _ := now.Date()
+	return now.Format(model.UsageDayLayout), time.Date(y, m, 1, 0, 0, 0, 0, now.Location()).Format(model.UsageDayLayout)
+}
+
+// sum adds up usages since the day, costs only of those in unit.
+func sum(usages []*model.Usage, since string, unit string) Spent {
+	ret := Spent{Since: since, Tokens: 0, Cost: 0}
+	for _, usage := range usages {
+		if usage.Day < since {
+			continue
+		}
+		ret.Tokens += usage.Tokens()
+		if usage.Unit == unit {
+			ret.Cost += usage.Cost
+		}
+	}
+	return ret
+}
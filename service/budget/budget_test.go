@@ -0,0 +1,69 @@
+package budget
+
+import (
+	"aiagent/clients/model"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSum(t *testing.T) {
+	usages := []*model.Usage{
+		{Day: "2026-10-01", PromptTokens: 10, CompletionTokens: 5, Cost: 100, Unit: "USD"},
+		{Day: "2026-10-17", PromptTokens: 20, CompletionTokens: 10, Cost: 200, Unit: "USD"},
+		{Day: "2026-10-17", PromptTokens: 1, CompletionTokens: 2, Cost: 300, Unit: "CNY"},
+	}
+	tests := []struct {
+		name  string
+		since string
+		unit  string
+		want  Spent
+	}{
+		{"month", "2026-10-01", "USD", Spent{Since: "2026-10-01", Tokens: 48, Cost: 300}},
+		{"day", "2026-10-17", "USD", Spent{Since: "2026-10-17", Tokens: 33, Cost: 200}},
+		{"other unit", "2026-10-17", "CNY", Spent{Since: "2026-10-17", Tokens: 33, Cost: 300}},
+		{"no unit", "2026-10-01", "", Spent{Since: "2026-10-01", Tokens: 48, Cost: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sum(usages, tt.since, tt.unit); got != tt.want {
+				t.Errorf("sum() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestView_exhausted(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		budget model.Budget
+		day    Spent
+		month  Spent
+		want   int // status code, 0 for none
+	}{
+		{"unlimited", model.Budget{}, Spent{Tokens: 100, Cost: 100}, Spent{Tokens: 100, Cost: 100}, 0},
+		{"within", model.Budget{DailyTokens: 100, MonthlyCost: 1000, Unit: "USD"},
+			Spent{Tokens: 99, Cost: 0}, Spent{Tokens: 99, Cost: 999}, 0},
+		{"daily tokens", model.Budget{DailyTokens: 100}, Spent{Tokens: 100}, Spent{Tokens: 100},
+			http.StatusTooManyRequests},
+		{"monthly tokens", model.Budget{MonthlyTokens: 100}, Spent{Tokens: 0}, Spent{Tokens: 150},
+			http.StatusTooManyRequests},
+		{"daily cost", model.Budget{DailyCost: 10, Unit: "USD"}, Spent{Cost: 10}, Spent{Cost: 10},
+			http.StatusPaymentRequired},
+		{"cost before tokens", model.Budget{DailyTokens: 1, MonthlyCost: 10, Unit: "USD"},
+			Spent{Tokens: 1, Cost: 0}, Spent{Tokens: 1, Cost: 20}, http.StatusPaymentRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &View{Budget: &tt.budget, Day: tt.day, Month: tt.month}
+			got := 0
+			if e := v.exhausted(now); e != nil {
+				got = e.Code
+			}
+			if got != tt.want {
+				t.Errorf("exhausted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
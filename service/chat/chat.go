@@ -2,15 +2,22 @@ package chat
 
 import (
 	"aiagent/clients/chat"
+	"aiagent/clients/compaction"
 	"aiagent/clients/model"
 	"aiagent/clients/openai"
+	"aiagent/clients/provider"
 	"aiagent/clients/session"
+	"aiagent/helpers/pricer"
+	"aiagent/helpers/schema"
+	"aiagent/service/tool"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,18 +25,49 @@ import (
 	"gorm.io/gorm"
 )
 
+// Budgeter keeps users within their budgets.
+type Budgeter interface {
+	// Check returns an error if the user can chat no more for now.
+	Check(ctx context.Context, userID int) *wf.CodedError
+	// Spend accounts what result charges the user, whose usage and cost are those of one generation.
+	Spend(ctx context.Context, userID int, result *model.Result)
+}
+
 type Service struct {
-	client            *openai.Client
-	chatRepository    *chat.Repository
-	sessionRepository *session.Repository
+	providers            *provider.Registry
+	tools                *tool.Registry
+	chatRepository       *chat.Repository
+	sessionRepository    *session.Repository
+	compactionRepository *compaction.Repository
+	summarizer           Summarizer
+	budgeter             Budgeter
+	prices               *pricer.Table
+	streams              *streams
+	flights              *flights
 }
 
 func NewService(
-	client *openai.Client,
+	providers *provider.Registry,
+	tools *tool.Registry,
 	chatRepository *chat.Repository,
 	sessionRepository *session.Repository,
+	compactionRepository *compaction.Repository,
+	summarizer Summarizer,
+	budgeter Budgeter,
+	prices *pricer.Table,
 ) *Service {
-	return &Service{client: client, chatRepository: chatRepository, sessionRepository: sessionRepository}
+	return &Service{
+		providers:            providers,
+		tools:                tools,
+		chatRepository:       chatRepository,
+		sessionRepository:    sessionRepository,
+		compactionRepository: compactionRepository,
+		summarizer:           summarizer,
+		budgeter:             budgeter,
+		prices:               prices,
+		streams:              newStreams(),
+		flights:              newFlights(),
+	}
 }
 
 type Request struct {
@@ -40,7 +78,219 @@ type Request struct {
 
 type RequestPayload struct {
 	Content string `json:"content"`
-	Model   string `json:"model"`
+	Model   string `json:"model"` // one in the routing table of [provider.Registry], empty for the default
+	// ReasoningEffort is high if empty.
+	ReasoningEffort openai.ReasoningEffort `json:"reasoning_effort"`
+	openai.Sampling
+	// ResponseFormat is checked on the final answer, which would be repaired once if invalid.
+	ResponseFormat *openai.ResponseFormat `json:"response_format,omitempty"`
+	// PartialHistory includes partial answers in history, which are left out by default.
+	PartialHistory bool `json:"partial_history,omitempty"`
+	// Revision is told by the endpoint rather than the payload.
+	Revision Revision `json:"-"`
+	// IdempotencyKey is told by the header, a request with the same one of the session gets what the earlier has,
+	// rather than chatting again. A continuation saves no chat, thus is never deduplicated, see [RequestPayload.deduplicated].
+	IdempotencyKey string `json:"-"`
+}
+
+// deduplicated returns whether p is recalled by its IdempotencyKey rather than chatting again.
+// A continuation is not, as its key could be the one of the chat it continues, which would recall the partial answer.
+func (p *RequestPayload) deduplicated() bool {
+	return p.IdempotencyKey != "" && p.Revision != RevisionContinue
+}
+
+// Revision tells how a chat relates to the active one of its session.
+type Revision int
+
+const (
+	RevisionNone       Revision = iota // a new turn following the active one
+	RevisionRegenerate                 // a new answer to the input of the active turn, Content must be empty
+	RevisionEdit                       // a new input replacing that of the active turn
+	// RevisionContinue continues the partial answer of the active turn in place, Content must be empty.
+	RevisionContinue
+)
+
+// effort returns the ReasoningEffort with default applied.
+func (p *RequestPayload) effort() openai.ReasoningEffort {
+	if p.ReasoningEffort == "" {
+		return openai.ReasoningEffortHigh
+	}
+	return p.ReasoningEffort
+}
+
+// maxToolRounds limits how many rounds upstream could call tools in one chat.
+// Once reached, tools are forbidden in the next round to force a final answer.
+const maxToolRounds = 8
+
+// errToolRounds tells upstream still called tools once forbidden, which ends the chat rather than looping forever.
+var errToolRounds = errors.New("tool rounds exceeded")
+
+// conversation is what a chat sends to upstream, which grows as tools are called.
+type conversation struct {
+	messages []openai.Message // history and the new input
+	steps    []openai.Message // tool calls and their replies in this chat
+	usage    openai.Usage     // sum of rounds that have called tools
+	rounds   int
+	route    *provider.Route
+	effort   openai.ReasoningEffort
+	sampling openai.Sampling
+	format   *openai.ResponseFormat
+	schema   *schema.Schema // of format, nil if not required
+	repaired bool
+	scope    tool.Scope
+	// continued is the partial answer being continued, nil if not continuing.
+	continued *model.Result
+}
+
+func newConversation(
+	ses *model.Session,
+	history []openai.Message,
+	req *RequestPayload,
+	route *provider.Route,
+	formatSchema *schema.Schema,
+) *conversation {
+	return &conversation{
+		messages:  append(history, openai.NewUserMessage(req.Content)),
+		steps:     nil,
+		usage:     openai.Usage{},
+		rounds:    0,
+		route:     route,
+		effort:    req.effort(),
+		sampling:  req.Sampling,
+		format:    req.ResponseFormat,
+		schema:    formatSchema,
+		repaired:  false,
+		scope:     tool.Scope{UserID: ses.UserID, SessionID: ses.ID},
+		continued: nil,
+	}
+}
+
+// continuePrompt asks upstream to go on with a partial answer, which is sent right before it.
+// Prefilling the answer is not portable among dialects, but asking is.
+const continuePrompt = "Your reply above was cut off. Continue exactly from where it stopped, without repeating anything."
+
+func (s *Service) request(c *conversation) openai.Request {
+	messages := append(slices.Clone(c.messages), c.steps...)
+	if c.continued != nil && c.continued.Content != "" {
+		// Nothing answered is simply asked again.
+		messages = append(messages,
+			openai.Message{Role: c.continued.Role, Content: c.continued.Content},
+			openai.NewUserMessage(continuePrompt),
+		)
+	}
+	req := openai.NewRequest(messages, c.route.UpstreamModel(), c.effort).
+		WithSampling(c.sampling).
+		WithResponseFormat(c.format)
+	if s.tools.Empty() {
+		return req
+	}
+	if c.toolsForbidden() {
+		// Still offer tools as steps in messages refer to them.
+		return req.WithTools(s.tools.Tools(), &openai.ToolChoice{Mode: openai.ToolChoiceModeNone})
+	}
+	return req.WithTools(s.tools.Tools(), nil)
+}
+
+// toolsForbidden returns whether the next round must answer rather than call tools.
+// A continuation calls no tool, as its steps would come after the partial answer.
+func (c *conversation) toolsForbidden() bool {
+	return c.rounds >= maxToolRounds || c.continued != nil
+}
+
+// callsTools returns whether cc is a round that asks for tools rather than an answer.
+func callsTools(cc *openai.ChatCompletion) bool {
+	return cc.Valid() && cc.Choices[0].FinishReason == openai.FinishReasonToolCalls
+}
+
+// callTools executes tool calls in cc, records the round in c, and returns replies in order of calls.
+func (s *Service) callTools(ctx context.Context, c *conversation, cc *openai.ChatCompletion) []openai.Message {
+	message := cc.Choices[0].Message
+	c.steps = append(c.steps, message)
+	c.usage = c.usage.Add(cc.Usage)
+	c.rounds++
+
+	var replies []openai.Message
+	for _, call := range message.ToolCalls {
+		reply := s.tools.Call(ctx, c.scope, call)
+		replies = append(replies, reply)
+		c.steps = append(c.steps, reply)
+	}
+	return replies
+}
+
+// finish fills neo with steps recorded in c and the final cc,
+// whose Usage would be updated to include all rounds.
+// A continuation replaces the result of neo with the partial answer joined by cc, steps are kept as they are.
+func (c *conversation) finish(neo *model.Chat, cc *openai.ChatCompletion) {
+	cc.Usage = c.usage.Add(cc.Usage)
+	defer func() { neo.Result.Route = c.route.Name }()
+	if c.continued == nil {
+		neo.Steps = model.NewSteps(c.steps)
+		neo.Result = model.NewResult(cc, c.effort, c.sampling)
+		return
+	}
+	message := &cc.Choices[0].Message
+	message.Content = c.continued.Content + message.Content
+	if c.continued.ReasoningContent != "" {
+		message.ReasoningContent = c.continued.ReasoningContent + "\n\n" + message.ReasoningContent
+	}
+	result := model.NewResult(cc, c.effort, c.sampling)
+	result.ID, result.ChatID = c.continued.ID, c.continued.ChatID
+	neo.Result = result
+}
+
+// save saves neo filled by c, only its result if continued, as that is all that changed.
+// Its result is priced and stored with usage of all generations, but spent by the user only of this one,
+// as the partial answer continued has been spent when it's saved.
+func (s *Service) save(ctx context.Context, c *conversation, neo *model.Chat) error {
+	if err := Price(s.prices, neo); err != nil {
+		// The answer is still saved, left unpriced for the report to tell.
+		slog.Warn("can not price result", "err", err)
+	}
+	var err error
+	if c.continued != nil {
+		err = s.chatRepository.SaveResult(ctx, neo.Result)
+	} else {
+		err = s.chatRepository.Save(ctx, neo)
+	}
+	if err != nil {
+		return err
+	}
+	s.budgeter.Spend(ctx, c.scope.UserID, generated(neo.Result, c.continued))
+	return nil
+}
+
+// generated returns what result charges beyond previous, which it continues, result itself if previous is nil.
+// The cost of previous is taken off only if both are priced in the same unit, otherwise previous spent no cost of it.
+func generated(result *model.Result, previous *model.Result) *model.Result {
+	if previous == nil {
+		return result
+	}
+	ret := *result
+	ret.PromptTokens -= previous.PromptTokens
+	ret.CompletionTokens -= previous.CompletionTokens
+	ret.CachedTokens -= previous.CachedTokens
+	ret.ReasoningTokens -= previous.ReasoningTokens
+	ret.PromptCacheHitTokens -= previous.PromptCacheHitTokens
+	if result.CostNano != nil && previous.CostNano != nil && result.CostUnit == previous.CostUnit {
+		cost := *result.CostNano - *previous.CostNano
+		ret.CostNano = &cost
+	}
+	return &ret
+}
+
+// Price stores the cost of the result of c by the price of its [model.Result.PricedModel] effective when c is created.
+// If the model is not priced then, the result is left without cost and [pricer.ErrUnknownPrice] is returned.
+func Price(prices *pricer.Table, c *model.Chat) error {
+	result := c.Result
+	price, err := prices.Price(result.PricedModel(), time.UnixMilli(c.CreateTime))
+	if err != nil {
+		result.CostNano, result.CostUnit = nil, ""
+		return err
+	}
+	cost := price.Nano(pricer.OpenAIUsage(result.ChatCompletion().Usage))
+	result.CostNano, result.CostUnit = &cost, price.Unit.String()
+	return nil
 }
 
 func (s *Service) Chat(
@@ -59,33 +309,114 @@ func (s *Service) ChatSimple(
 	sessionID int,
 	req *RequestPayload,
 ) (*openai.ChatCompletion, *wf.CodedError) {
-	ses, neo, e := s.prepareChat(ctx, sessionID, req)
+	_, cc, e := s.chat(ctx, sessionID, req)
+	return cc, e
+}
+
+// ChatSaved is [Service.ChatSimple] returning the chat saved, which is nil if nothing is saved.
+// The chat could come with an error, such as upstream failed after the input saved.
+func (s *Service) ChatSaved(ctx context.Context, sessionID int, req *RequestPayload) (*model.Chat, *wf.CodedError) {
+	neo, _, e := s.chat(ctx, sessionID, req)
+	return neo, e
+}
+
+func (s *Service) chat(
+	ctx context.Context,
+	sessionID int,
+	req *RequestPayload,
+) (saved *model.Chat, _ *openai.ChatCompletion, _ *wf.CodedError) {
+	if req.deduplicated() {
+		earlier, _, f, e := s.recall(ctx, sessionID, req.IdempotencyKey, req.digest(), false)
+		if e != nil {
+			return nil, nil, e
+		}
+		if earlier != nil {
+			return earlier, earlier.Result.ChatCompletion(), nil
+		}
+		defer s.flights.land(f)
+	}
+	conv, neo, e := s.prepareChat(ctx, sessionID, req)
 	if e != nil {
-		return nil, e
+		return nil, nil, e
 	}
 
-	chatCompletion, err := s.client.OneShot(ctx, openai.NewRequest(
-		append(ses.History(), openai.NewUserMessage(req.Content)),
-		openai.ChatModel(req.Model),
-		openai.ReasoningEffortHigh,
-	))
-	if err != nil {
-		return nil, wf.NewCodedErrorf(http.StatusInternalServerError, "upstream: %v", err.Error())
+	var chatCompletion *openai.ChatCompletion
+	var invalid, exceeded error
+	for {
+		cc, err := conv.route.Client.OneShot(ctx, s.request(conv))
+		if err != nil {
+			return neo, nil, wf.NewCodedErrorf(openai.HTTPStatus(err), "upstream: %v", err.Error())
+		}
+		if callsTools(cc) && conv.toolsForbidden() {
+			// Saved as an upstream error by its finish reason, with steps so far and what's charged.
+			exceeded = errToolRounds
+			chatCompletion = cc
+			break
+		}
+		if callsTools(cc) {
+			s.callTools(ctx, conv, cc)
+			continue
+		}
+		invalid = conv.checkFormat(cc)
+		if invalid != nil && conv.repair(cc, invalid) {
+			continue
+		}
+		chatCompletion = cc
+		break
 	}
-	neo.Result = model.NewResult(chatCompletion)
+	conv.finish(neo, chatCompletion)
 
-	if err := s.chatRepository.Save(ctx, neo); err != nil {
+	if err := s.save(ctx, conv, neo); err != nil {
 		slog.Error("can not append record", "chat", neo)
-		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+		return neo, nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	if exceeded != nil {
+		return neo, nil, wf.NewCodedError(http.StatusBadGateway, exceeded)
+	}
+	if invalid != nil {
+		// Saved anyway, as it's what upstream answered and charged.
+		return neo, nil, wf.NewCodedError(http.StatusUnprocessableEntity, invalid)
 	}
-	return chatCompletion, nil
+	return neo, chatCompletion, nil
 }
 
+// Validate tells whether req could be chatted, before anything saved or sent.
+func (s *Service) Validate(req *RequestPayload) *wf.CodedError {
+	_, _, e := s.validate(req)
+	return e
+}
+
+// validate returns where req goes and the schema its answer must follow, nil if not required.
+func (s *Service) validate(req *RequestPayload) (*provider.Route, *schema.Schema, *wf.CodedError) {
+	route, err := s.providers.Route(req.Model)
+	if err != nil {
+		return nil, nil, wf.NewCodedError(http.StatusBadRequest, err)
+	}
+	if !req.effort().Valid() {
+		return nil, nil, wf.NewCodedErrorf(http.StatusBadRequest, "unknown reasoning effort %q", req.ReasoningEffort)
+	}
+	if err := route.Check(openai.NewRequest(nil, route.UpstreamModel(), req.effort()).WithSampling(req.Sampling)); err != nil {
+		return nil, nil, wf.NewCodedError(http.StatusBadRequest, err)
+	}
+	formatSchema, err := newFormatSchema(req.ResponseFormat)
+	if err != nil {
+		return nil, nil, wf.NewCodedError(http.StatusBadRequest, err)
+	}
+	return route, formatSchema, nil
+}
+
+// prepareChat validates req, then saves an input-only chat as neo, to be filled by the conversation.
 func (s *Service) prepareChat(ctx context.Context, sessionID int, req *RequestPayload) (
-	ses *model.Session,
+	conv *conversation,
 	neo *model.Chat,
 	err *wf.CodedError,
 ) {
+	// Before anything saved, so that a typo in model leaves no dangling chat.
+	route, formatSchema, err := s.validate(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	ses, e := s.sessionRepository.FindWithChats(ctx, sessionID)
 	if errors.Is(e, gorm.ErrRecordNotFound) {
 		return nil, nil, wf.NewCodedErrorf(http.StatusNotFound, "no session on id %v to chat", sessionID)
@@ -93,16 +424,44 @@ func (s *Service) prepareChat(ctx context.Context, sessionID int, req *RequestPa
 	if e != nil {
 		return nil, nil, wf.NewCodedError(http.StatusInternalServerError, e)
 	}
+	if err := s.budgeter.Check(ctx, ses.UserID); err != nil {
+		return nil, nil, err
+	}
 
-	if err := s.chatRepository.Save(ctx, &model.Chat{
+	input := &model.Chat{
 		ChatPart: model.ChatPart{
 			ID:         0,
 			SessionID:  ses.ID,
 			CreateTime: time.Now().UnixMilli(),
 		},
-		Input:  req.Content,
-		Result: nil,
-	}); err != nil {
+		ParentID: ses.ActiveChatID,
+		Input:    req.Content,
+		Steps:    nil,
+		Result:   nil,
+	}
+	if req.IdempotencyKey != "" {
+		digest := req.digest()
+		input.IdempotencyKey, input.RequestDigest = &req.IdempotencyKey, &digest
+	}
+	if req.Revision == RevisionContinue {
+		return s.prepareContinue(ctx, ses, req, route, formatSchema)
+	}
+	if req.Revision != RevisionNone {
+		active, e := revise(ses, req)
+		if e != nil {
+			return nil, nil, e
+		}
+		// A sibling of the revised one, which is kept as an alternative branch.
+		input.Input = req.Content
+		input.ParentID = active.ParentID
+		ses.ActiveChatID = active.ParentID // so that History ends before the revised one
+	}
+	// Before the input saved, so that a compaction never covers it.
+	history, ce := s.history(ctx, ses, route, req, false)
+	if ce != nil {
+		return nil, nil, ce
+	}
+	if err := s.chatRepository.Append(ctx, input); err != nil {
 		return nil, nil, wf.NewCodedError(http.StatusInternalServerError, err)
 	}
 
@@ -111,7 +470,65 @@ func (s *Service) prepareChat(ctx context.Context, sessionID int, req *RequestPa
 		return nil, nil, wf.NewCodedError(http.StatusInternalServerError, e)
 	}
 
-	return ses, neo, nil
+	return newConversation(ses, history, req, route, formatSchema), neo, nil
+}
+
+// prepareContinue makes a conversation continuing the partial answer of the active chat of ses, which is neo.
+// Nothing is saved until it's finished, as the chat is there already.
+func (s *Service) prepareContinue(
+	ctx context.Context,
+	ses *model.Session,
+	req *RequestPayload,
+	route *provider.Route,
+	formatSchema *schema.Schema,
+) (conv *conversation, neo *model.Chat, err *wf.CodedError) {
+	active, e := revise(ses, req)
+	if e != nil {
+		return nil, nil, e
+	}
+	ses.ActiveChatID = active.ParentID // so that History ends before the continued one
+	history, e := s.history(ctx, ses, route, req, false)
+	if e != nil {
+		return nil, nil, e
+	}
+	ret := newConversation(ses, history, req, route, formatSchema)
+	ret.steps = model.StepMessages(active.Steps)
+	ret.usage = active.Result.ChatCompletion().Usage
+	ret.continued = active.Result
+	// The answer comes in two parts, the repair of which could not be sent in order.
+	ret.repaired = true
+	return ret, active, nil
+}
+
+// revise returns the active chat of ses to be revised by req, whose Content is filled if regenerating or continuing.
+func revise(ses *model.Session, req *RequestPayload) (active *model.Chat, _ *wf.CodedError) {
+	if ses.ActiveChatID == nil {
+		return nil, wf.NewCodedErrorf(http.StatusConflict, "no turn in session %d-%d to revise", ses.UserID, ses.ScopedID)
+	}
+	ret := ses.FindChat(*ses.ActiveChatID)
+	switch req.Revision {
+	case RevisionRegenerate:
+		if req.Content != "" {
+			return nil, wf.NewCodedErrorf(http.StatusBadRequest, "content is given while regenerating")
+		}
+		req.Content = ret.Input
+	case RevisionEdit:
+		if req.Content == "" {
+			return nil, wf.NewCodedErrorf(http.StatusBadRequest, "no content to replace the last input")
+		}
+	case RevisionContinue:
+		if req.Content != "" {
+			return nil, wf.NewCodedErrorf(http.StatusBadRequest, "content is given while continuing")
+		}
+		if ret.Result == nil || !ret.Result.Partial() {
+			return nil, wf.NewCodedErrorf(http.StatusConflict, "chat %d has no partial answer to continue", ret.ID)
+		}
+		req.Content = ret.Input
+	default:
+		// Callers only revise with one of the above.
+		panic(fmt.Errorf("unexpected revision %d", req.Revision))
+	}
+	return ret, nil
 }
 
 func (s *Service) ChatStream(ctx context.Context, req *Request) (<-chan wf.MessageEvent, *wf.CodedError) {
@@ -127,6 +544,57 @@ func (s *Service) ChatStream(ctx context.Context, req *Request) (<-chan wf.Messa
 	return s.ChatStreamSimple(ctx, sessionID, &req.RequestPayload)
 }
 
+// Resume reattaches to the stream of the chat on chatID, with events after the one on lastEventID.
+// Streams are kept for a while after they end, so that a client coming back late still gets the rest.
+func (s *Service) Resume(
+	ctx context.Context,
+	userID int,
+	scopedID int,
+	chatID int,
+	lastEventID int,
+) (<-chan wf.MessageEvent, *wf.CodedError) {
+	sessionID, err := s.sessionRepository.FindIDByUserIDAndScopedID(ctx, userID, scopedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, wf.NewCodedErrorf(http.StatusNotFound, "no session %d-%d to resume", userID, scopedID)
+	}
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	st := s.streams.find(chatID)
+	if st == nil || st.sessionID != sessionID {
+		return nil, wf.NewCodedErrorf(http.StatusNotFound, "no stream of chat %d in session %d-%d", chatID, userID, scopedID)
+	}
+	if lastEventID < 0 || lastEventID > st.len() {
+		return nil, wf.NewCodedErrorf(http.StatusBadRequest, "no event %d in stream of chat %d", lastEventID, chatID)
+	}
+	return st.subscribe(ctx, lastEventID), nil
+}
+
+// Cancel aborts the generation of the chat on chatID, whose partial answer is saved with a cancelled status.
+// It returns once saved, so that a client could continue it right away.
+func (s *Service) Cancel(ctx context.Context, userID int, scopedID int, chatID int) *wf.CodedError {
+	sessionID, err := s.sessionRepository.FindIDByUserIDAndScopedID(ctx, userID, scopedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return wf.NewCodedErrorf(http.StatusNotFound, "no session %d-%d to cancel", userID, scopedID)
+	}
+	if err != nil {
+		return wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	st := s.streams.find(chatID)
+	if st == nil || st.sessionID != sessionID {
+		return wf.NewCodedErrorf(http.StatusNotFound, "no generation of chat %d in session %d-%d", chatID, userID, scopedID)
+	}
+	if !st.abort() {
+		return wf.NewCodedErrorf(http.StatusConflict, "generation of chat %d has ended", chatID)
+	}
+	select {
+	case <-st.done:
+		return nil
+	case <-ctx.Done():
+		return wf.NewCodedError(http.StatusGatewayTimeout, ctx.Err())
+	}
+}
+
 func detachedContext(parent context.Context) (context.Context, context.CancelFunc) {
 	ctx := context.WithoutCancel(parent)
 	if deadline, ok := parent.Deadline(); ok {
@@ -141,129 +609,237 @@ func (s *Service) ChatStreamSimple(
 	sessionID int,
 	req *RequestPayload,
 ) (<-chan wf.MessageEvent, *wf.CodedError) {
-	ses, neo, e := s.prepareChat(ctx, sessionID, req)
+	var f *flight
+	if req.deduplicated() {
+		earlier, live, started, e := s.recall(ctx, sessionID, req.IdempotencyKey, req.digest(), true)
+		if e != nil {
+			return nil, e
+		}
+		if live != nil {
+			return live.subscribe(ctx, 0), nil
+		}
+		if earlier != nil {
+			return s.replayed(ctx, earlier), nil
+		}
+		f = started
+	}
+	conv, neo, e := s.prepareChat(ctx, sessionID, req)
 	if e != nil {
+		s.flights.land(f)
 		return nil, e
 	}
 
 	detachedCtx, detachedCancelFunc := detachedContext(ctx)
+	// Cancelled by [Service.Cancel] only, whose cause tells it from a timeout.
+	detachedCtx, cancel := context.WithCancelCause(detachedCtx)
 	// If we use ctx here, once the client has gone, our chat to upstream would be forced to end, which is not ideal.
-	up, err := s.client.OneShotStreamFast(detachedCtx, openai.NewRequest(
-		append(ses.History(), openai.NewUserMessage(req.Content)),
-		openai.ChatModel(req.Model),
-		openai.ReasoningEffortHigh,
-	))
+	up, err := conv.route.Client.OneShotStreamFast(detachedCtx, s.request(conv))
 	if err != nil {
+		cancel(err)
 		detachedCancelFunc()
-		return nil, wf.NewCodedErrorf(http.StatusInternalServerError, "upstream: %v", err.Error())
+		s.flights.land(f)
+		return nil, wf.NewCodedErrorf(openai.HTTPStatus(err), "upstream: %v", err.Error())
 	}
 
-	down := make(chan wf.MessageEvent)
+	st := s.streams.open(sessionID, neo.ID, cancel)
+	if f != nil {
+		s.flights.stream(f, st)
+	}
+	// The first event tells the chat, which a client reattaches to by [Service.Resume].
+	st.emit(wf.MessageEvent{TypeOptional: "chat", Lines: []string{strconv.Itoa(neo.ID)}})
 	// If ctx done, detachedCtx would go on the record procedure.
-	go s.translateAggregateSave(detachedCtx, detachedCancelFunc, ctx, up, down, neo)
-	return down, nil
+	go s.translateAggregateSave(detachedCtx, detachedCancelFunc, up, st, f, neo, conv)
+	return st.subscribe(ctx, 0), nil
 }
 
-func (s *Service) drainStreamAndRecordValidResult(
+// translateAggregateSave runs rounds with upstream until a final answer, which would be saved in neo.
+// Events are buffered in st, where clients come and go, but the procedure goes on.
+// The first round is up, later rounds, for tool calls or a format repair, are started here.
+// Requests waiting for f, nil for none, go on once the answer is saved.
+func (s *Service) translateAggregateSave(
 	ctx context.Context,
+	cancelFunc context.CancelFunc,
 	up <-chan openai.ChatCompletionChunkOrError,
-	down chan<- wf.MessageEvent,
+	st *stream,
+	f *flight,
 	neo *model.Chat,
-	aggregator *openai.ChatCompletion,
+	conv *conversation,
 ) {
-	// In the happy path, up should have closed gracefully, but in the client-gone situation,
-	// the up should have kept going, and we shall drain all the remained out here.
-	var drainCount int
-	for chunk := range up {
-		drainCount++
-		aggregator.Aggregate(chunk.ChatCompletionChunk)
-	}
-
-	if aggregator.Valid() {
-		neo.Result = model.NewResult(aggregator)
-		if err := s.chatRepository.Save(ctx, neo); err != nil {
-			slog.Error("can not append record in stream mode", "chat", neo, "err", err)
-			// If up can be drained, most likely the client has gone, and down is not writeable.
-			if drainCount == 0 {
-				down <- NewErrorMessageEvent(err)
+	defer s.flights.land(f)
+	defer s.streams.close(neo.ID, st)
+	defer cancelFunc()
+	emit := st.emit
+
+	for {
+		aggregator, failed := translateAggregate(up, emit)
+		if callsTools(aggregator) && !failed && conv.toolsForbidden() {
+			s.recordResult(ctx, neo, conv, aggregator, true, emit)
+			emit(NewErrorMessageEvent(errToolRounds))
+			return
+		}
+		if callsTools(aggregator) && !failed {
+			for _, call := range aggregator.Choices[0].Message.ToolCalls {
+				emit(NewJSONMessageEvent("toolCall", call))
+			}
+			for _, reply := range s.callTools(ctx, conv, aggregator) {
+				emit(NewJSONMessageEvent("toolResult", reply))
+			}
+		} else {
+			invalid := conv.checkFormat(aggregator)
+			if invalid != nil {
+				// The answer has been streamed, so it's left to clients to drop it.
+				emit(NewJSONMessageEvent("formatInvalid", invalid.Error()))
+			}
+			if invalid == nil || !conv.repair(aggregator, invalid) {
+				s.recordResult(ctx, neo, conv, aggregator, failed, emit)
+				if invalid != nil {
+					emit(NewErrorMessageEvent(invalid))
+				}
+				return
 			}
 		}
-	}
 
-	if drainCount > 0 {
-		slog.Info("client has gone but the result is saved", "drained", drainCount)
+		var err error
+		up, err = conv.route.Client.OneShotStreamFast(ctx, s.request(conv))
+		if err != nil && !cancelled(ctx) {
+			slog.Error("upstream in later rounds", "rounds", conv.rounds, "err", err)
+			emit(NewErrorMessageEvent(fmt.Errorf("upstream: %w", err)))
+		}
+		if err != nil {
+			// Nothing answered, but steps so far are kept with the failure.
+			s.recordResult(ctx, neo, conv, openai.NewAggregator(), true, emit)
+			return
+		}
 	}
 }
 
-func (s *Service) translateAggregateSave(
+// recordResult saves the last round in neo, even a partial one, so that what's been streamed is never lost.
+// A partial one is told to the client by a "partial" event with its status, failed tells whether upstream has failed.
+// One cancelled by [Service.Cancel] is saved even though ctx is done, and ends with a "cancelled" event.
+func (s *Service) recordResult(
 	ctx context.Context,
-	cancelFunc context.CancelFunc,
-	clientGone context.Context,
-	up <-chan openai.ChatCompletionChunkOrError,
-	down chan<- wf.MessageEvent,
 	neo *model.Chat,
+	conv *conversation,
+	aggregator *openai.ChatCompletion,
+	failed bool,
+	emit func(wf.MessageEvent),
 ) {
-	defer close(down)
-	defer cancelFunc()
+	if !aggregator.Valid() {
+		// Nothing streamed, the failure is recorded rather than leaving the chat without a result.
+		aggregator.Model = string(conv.route.UpstreamModel())
+		aggregator.Choices[0].Message.Role = "assistant"
+	}
+	conv.finish(neo, aggregator)
+	switch {
+	// A complete answer stays so, as the cancellation comes too late to abort anything.
+	case cancelled(ctx) && (failed || neo.Result.Partial()):
+		neo.Result.Status = model.ResultStatusCancelled
+	case failed:
+		neo.Result.Status = model.ResultStatusUpstreamError
+	}
+	if err := s.save(context.WithoutCancel(ctx), conv, neo); err != nil {
+		slog.Error("can not append record in stream mode", "chat", neo, "err", err)
+		emit(NewErrorMessageEvent(err))
+	}
+	if neo.Result.Partial() {
+		emit(NewJSONMessageEvent("partial", neo.Result.Status))
+	}
+	if neo.Result.Status == model.ResultStatusCancelled {
+		emit(wf.MessageEvent{TypeOptional: "cancelled", Lines: []string{strconv.Itoa(neo.ID)}})
+	}
+}
+
+// translateAggregate emits chunks from up as events and aggregates them until up is closed.
+// Pieces of tool calls are not emitted, as they are meaningless until aggregated.
+// Failed tells whether upstream has sent an error among chunks.
+func translateAggregate(
+	up <-chan openai.ChatCompletionChunkOrError,
+	emit func(wf.MessageEvent),
+) (aggregated *openai.ChatCompletion, failed bool) {
 	aggregator := openai.NewAggregator()
-	defer s.drainStreamAndRecordValidResult(ctx, up, down, neo, aggregator)
 	var stage int
+	for coe := range up {
+		if coe.Error != nil {
+			emit(NewErrorMessageEvent(coe.Error))
+			failed = true
+			continue
+		}
+		chunk := coe.ChatCompletionChunk
+		aggregator.Aggregate(chunk)
+		stage = translate(stage, chunk, aggregator, emit)
+	}
+	if stage != 3 {
+		slog.Error("end with unexpected status", "stage", stage)
+	}
+	return aggregator, failed
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			slog.Warn("stream interrupted", "error", ctx.Err())
-			return
-		case <-clientGone.Done():
-			slog.Warn("client gone", "error", clientGone.Err())
-			return
-		case coe, ok := <-up:
-			if !ok {
-				if stage != 3 {
-					slog.Error("end with unexpected status", "stage", stage)
-				}
-				return
-			}
-			if coe.Error != nil {
-				down <- NewErrorMessageEvent(coe.Error)
-				continue
-			}
-			chunk := coe.ChatCompletionChunk
-			aggregator.Aggregate(chunk)
-			switch stage {
-			case 0:
-				stage++
-				down <- NewJSONMessageEvent("head", chunk.ChatCompletionBase)
-				down <- wf.MessageEvent{
-					TypeOptional: "role",
-					Lines:        []string{chunk.Choices[0].Delta.Role},
-				}
-			case 1:
-				if chunk.Choices[0].Delta.Content == "" {
-					down <- NewMultiLineMessageEvent(chunk.Choices[0].Delta.ReasoningContent)
-				} else {
-					stage++
-					down <- wf.MessageEvent{
-						TypeOptional: "cotEnd",
-						Lines:        nil,
-					}
-					down <- NewMultiLineMessageEvent(chunk.Choices[0].Delta.Content)
-				}
-			case 2:
-				if chunk.Usage == nil {
-					down <- NewMultiLineMessageEvent(chunk.Choices[0].Delta.Content)
-				} else {
-					stage++
-					down <- wf.MessageEvent{
-						TypeOptional: "finish",
-						Lines:        []string{*chunk.Choices[0].FinishReason},
-					}
-					down <- NewJSONMessageEvent("usage", chunk.Usage)
-				}
-			}
+// translate emits chunk at stage, returns the next stage.
+// Stages are 0 for head, 1 for CoT, 2 for content and 3 for finished.
+// It finishes by the chunk with usage, where aggregated so far tells the finish reason,
+// as those asked by [openai.StreamOptions] come without choices after the one with it.
+func translate(
+	stage int,
+	chunk openai.ChatCompletionChunk,
+	aggregated *openai.ChatCompletion,
+	emit func(wf.MessageEvent),
+) (next int) {
+	if len(chunk.Choices) == 0 {
+		if chunk.Usage != nil && (stage == 1 || stage == 2) {
+			return translateFinish(aggregated, emit)
 		}
+		return stage
+	}
+	delta := chunk.Choices[0].Delta
+	switch stage {
+	case 0:
+		emit(NewJSONMessageEvent("head", chunk.ChatCompletionBase))
+		emit(wf.MessageEvent{
+			TypeOptional: "role",
+			Lines:        []string{delta.Role},
+		})
+		return 1
+	case 1:
+		switch {
+		case chunk.Usage != nil:
+			return translateFinish(aggregated, emit)
+		case len(delta.ToolCalls) > 0:
+			return 1
+		case delta.Content == "":
+			emit(NewMultiLineMessageEvent(delta.ReasoningContent))
+			return 1
+		default:
+			emit(wf.MessageEvent{
+				TypeOptional: "cotEnd",
+				Lines:        nil,
+			})
+			emit(NewMultiLineMessageEvent(delta.Content))
+			return 2
+		}
+	case 2:
+		switch {
+		case chunk.Usage != nil:
+			return translateFinish(aggregated, emit)
+		case len(delta.ToolCalls) > 0:
+			return 2
+		default:
+			emit(NewMultiLineMessageEvent(delta.Content))
+			return 2
+		}
+	default:
+		return stage
 	}
 }
+
+func translateFinish(aggregated *openai.ChatCompletion, emit func(wf.MessageEvent)) (next int) {
+	emit(wf.MessageEvent{
+		TypeOptional: "finish",
+		Lines:        []string{aggregated.Choices[0].FinishReason},
+	})
+	emit(NewJSONMessageEvent("usage", aggregated.Usage))
+	return 3
+}
+
 func NewMultiLineMessageEvent(passage string) wf.MessageEvent {
 	return wf.MessageEvent{
 		TypeOptional: "",
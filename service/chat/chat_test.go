@@ -1,9 +1,15 @@
 package chat
 
 import (
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"aiagent/clients/provider"
+	"aiagent/helpers/pricer"
+	"aiagent/service/tool"
 	"encoding/json"
 	"strings"
 	"testing"
+	"time"
 )
 
 type Widget struct {
@@ -33,3 +39,93 @@ line3`
 		t.Errorf("JSON encode result changed got %s want %s", s, want)
 	}
 }
+
+func TestConversation_continue(t *testing.T) {
+	partial := &model.Result{
+		ID:      7,
+		ChatID:  3,
+		Role:    "assistant",
+		Content: "one, two, ",
+		Status:  model.ResultStatusLength,
+	}
+	conv := &conversation{
+		messages:  []openai.Message{openai.NewUserMessage("count to four")},
+		route:     &provider.Route{},
+		continued: partial,
+	}
+	s := &Service{tools: &tool.Registry{}}
+
+	messages := s.request(conv).Messages
+	if n := len(messages); n != 3 || messages[1].Content != partial.Content || messages[2].Content != continuePrompt {
+		t.Errorf("request() messages = %+v, want the partial answer and the prompt to continue", messages)
+	}
+
+	neo := &model.Chat{ChatPart: model.ChatPart{ID: 3}, Result: partial}
+	cc := &openai.ChatCompletion{Choices: []openai.Choice{{
+		Message:      openai.Message{Role: "assistant", Content: "three, four"},
+		FinishReason: openai.FinishReasonStop,
+	}}}
+	conv.finish(neo, cc)
+	if got := neo.Result; got.ID != 7 || got.Content != "one, two, three, four" || got.Status != model.ResultStatusComplete {
+		t.Errorf("finish() result = %+v, want continued in place", got)
+	}
+}
+
+func TestPrice(t *testing.T) {
+	created := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC).UnixMilli()
+	priced := int64(1_000*500 + 20*500 + 2_000*100)
+	tests := []struct {
+		name     string
+		chat     model.Chat
+		wantCost *int64
+		wantUnit string
+	}{
+		{
+			"priced",
+			model.Chat{
+				ChatPart: model.ChatPart{CreateTime: created},
+				Result:   &model.Result{Model: openai.ChatModelDeepSeekV4Flash, PromptTokens: 1000, CachedTokens: 500, CompletionTokens: 100},
+			},
+			&priced,
+			"CNY",
+		},
+		{
+			"by route named otherwise upstream",
+			model.Chat{
+				ChatPart: model.ChatPart{CreateTime: created},
+				Result: &model.Result{Model: "deepseek-v4-flash-2026-05-06", Route: openai.ChatModelDeepSeekV4Flash,
+					PromptTokens: 1000, CachedTokens: 500, CompletionTokens: 100},
+			},
+			&priced,
+			"CNY",
+		},
+		{
+			"unknown",
+			model.Chat{
+				ChatPart: model.ChatPart{CreateTime: created},
+				Result:   &model.Result{Model: "unknown", PromptTokens: 1000, CompletionTokens: 100},
+			},
+			nil,
+			"",
+		},
+		{
+			"before priced",
+			model.Chat{Result: &model.Result{Model: openai.ChatModelDeepSeekV4Flash, PromptTokens: 1000}},
+			nil,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Price(pricer.Default(), &tt.chat)
+			if (err != nil) != (tt.wantCost == nil) {
+				t.Fatalf("Price() error = %v", err)
+			}
+			got := tt.chat.Result
+			if (got.CostNano == nil) != (tt.wantCost == nil) || got.CostNano != nil && *got.CostNano != *tt.wantCost ||
+				got.CostUnit != tt.wantUnit {
+				t.Errorf("Price() = %v %v, want %v %v", got.CostNano, got.CostUnit, tt.wantCost, tt.wantUnit)
+			}
+		})
+	}
+}
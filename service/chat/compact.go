@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"aiagent/clients/provider"
+	"aiagent/helpers/tokens"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/hyisen/wf"
+)
+
+// Summarizer condenses chats, which follow what previous summarizes.
+// What it costs is on the service, as naming sessions is, rather than charged to the budget of the user,
+// who never asks for it.
+type Summarizer interface {
+	Summarize(ctx context.Context, previous string, chats []*model.Chat) (summary string, _ *wf.CodedError)
+}
+
+// turn is a valid chat on the active path, with its messages in history.
+type turn struct {
+	chat     *model.Chat
+	messages []openai.Message
+	tokens   int
+}
+
+// window is what history could take in a request.
+type window struct {
+	head   []openai.Message // the system prompt, which is never compacted
+	turns  []turn
+	budget int // tokens left for head, the summary and turns
+	vocab  tokens.Vocab
+}
+
+func (w *window) fits(summary []openai.Message, turns []turn) bool {
+	sum := tokens.Messages(w.vocab, w.head) + tokens.Messages(w.vocab, summary)
+	for _, t := range turns {
+		sum += t.tokens
+	}
+	return sum <= w.budget
+}
+
+// drop returns the latest turns which fit with summary.
+func (w *window) drop(summary []openai.Message, turns []turn) []turn {
+	for len(turns) > 0 && !w.fits(summary, turns) {
+		turns = turns[1:]
+	}
+	return turns
+}
+
+func (w *window) messages(summary []openai.Message, turns []turn) []openai.Message {
+	ret := slices.Concat(w.head, summary)
+	for _, t := range turns {
+		ret = append(ret, t.messages...)
+	}
+	return ret
+}
+
+// reserve returns tokens left for the answer, which is what max_tokens asks for, or the most the model outputs.
+// Neither known, a quarter of the window is reserved, as answers with reasoning are long.
+func reserve(route *provider.Route, req *RequestPayload) int {
+	switch {
+	case req.MaxTokens != nil:
+		return *req.MaxTokens
+	case route.MaxOutputTokens > 0:
+		return route.MaxOutputTokens
+	default:
+		return route.ContextWindow / 4
+	}
+}
+
+func (s *Service) newWindow(ses *model.Session, route *provider.Route, req *RequestPayload) *window {
+	var head []openai.Message
+	if ses.SystemPrompt != "" {
+		head = []openai.Message{openai.NewSystemMessage(ses.SystemPrompt)}
+	}
+	vocab := route.Vocab()
+	// The same as what History returns, but cut into turns, so that they could be dropped as a whole.
+	var turns []turn
+	for _, chat := range ses.Path() {
+		if !chat.InHistory(req.PartialHistory) {
+			continue
+		}
+		messages := chat.Chat().HistoryRecords()
+		turns = append(turns, turn{chat: chat, messages: messages, tokens: tokens.Messages(vocab, messages)})
+	}
+
+	input := []openai.Message{openai.NewUserMessage(req.Content)}
+	budget := route.ContextWindow - reserve(route, req) - tokens.Messages(vocab, input) - s.toolsTokens(vocab)
+	return &window{head: head, turns: turns, budget: budget, vocab: vocab}
+}
+
+// toolsTokens returns how many tokens tools offered in every request take.
+func (s *Service) toolsTokens(vocab tokens.Vocab) int {
+	if s.tools.Empty() {
+		return 0
+	}
+	data, err := json.Marshal(s.tools.Tools())
+	if err != nil {
+		// Tools are offered in every request, which would have failed the same way long before.
+		panic(err)
+	}
+	return tokens.Count(vocab, string(data))
+}
+
+// history returns messages of ses to be sent before the input of req,
+// which are compacted to fit in the context window of route.
+// A dry run makes no new summary, see [Service.summarize].
+func (s *Service) history(
+	ctx context.Context,
+	ses *model.Session,
+	route *provider.Route,
+	req *RequestPayload,
+	dryRun bool,
+) ([]openai.Message, *wf.CodedError) {
+	if route.ContextWindow == 0 {
+		return ses.History(req.PartialHistory), nil
+	}
+	w := s.newWindow(ses, route, req)
+	if w.fits(nil, w.turns) {
+		return w.messages(nil, w.turns), nil
+	}
+
+	switch route.Compaction {
+	case provider.CompactionWindow:
+		turns := w.turns[max(len(w.turns)-route.WindowTurns, 0):]
+		return w.messages(nil, w.drop(nil, turns)), nil
+	case provider.CompactionSummarize:
+		return s.summarize(ctx, ses, w, dryRun)
+	default:
+		return w.messages(nil, w.drop(nil, w.turns)), nil
+	}
+}
+
+func summaryMessages(summary string) []openai.Message {
+	if summary == "" {
+		return nil
+	}
+	return []openai.Message{openai.NewSystemMessage("Summary of the earlier conversation:\n" + summary)}
+}
+
+// summarize reuses the latest compaction on the path if it's enough, otherwise makes a new one from it.
+// If summarizing fails, it falls back to dropping, as a chat shall not fail for a shorter history.
+// A dry run drops as well, as the summary is unknown before made, thus the history is a little shorter than sent.
+func (s *Service) summarize(
+	ctx context.Context,
+	ses *model.Session,
+	w *window,
+	dryRun bool,
+) ([]openai.Message, *wf.CodedError) {
+	compactions, err := s.compactionRepository.FindBySessionID(ctx, ses.ID)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	// The compaction deepest on the path covers the most, those off the path are of other branches.
+	var previous string
+	last := -1
+	for _, c := range compactions {
+		for i, t := range w.turns {
+			if t.chat.ID == c.ChatID && i >= last {
+				previous, last = c.Summary, i
+			}
+		}
+	}
+	rest := w.turns[last+1:]
+	if w.fits(summaryMessages(previous), rest) {
+		return w.messages(summaryMessages(previous), rest), nil
+	}
+
+	// Keep the latest turns in half of the budget, so that the new summary is reused for turns to come.
+	half := &window{head: w.head, turns: nil, budget: w.budget / 2, vocab: w.vocab}
+	kept := half.drop(summaryMessages(previous), rest)
+	older := rest[:len(rest)-len(kept)]
+	if len(older) == 0 || dryRun {
+		return w.messages(summaryMessages(previous), w.drop(summaryMessages(previous), rest)), nil
+	}
+
+	var chats []*model.Chat
+	for _, t := range older {
+		chats = append(chats, t.chat)
+	}
+	summary, e := s.summarizer.Summarize(ctx, previous, chats)
+	if e != nil {
+		slog.Warn("summarize failed, drop instead", "session", ses.ID, "err", e)
+		return w.messages(summaryMessages(previous), w.drop(summaryMessages(previous), rest)), nil
+	}
+	if err := s.compactionRepository.Save(ctx, &model.Compaction{
+		ID:         0,
+		SessionID:  ses.ID,
+		ChatID:     older[len(older)-1].chat.ID,
+		Summary:    summary,
+		CreateTime: time.Now().UnixMilli(),
+	}); err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return w.messages(summaryMessages(summary), w.drop(summaryMessages(summary), kept)), nil
+}
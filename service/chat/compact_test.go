@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"aiagent/clients/provider"
+	"aiagent/service/tool"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// historySession returns a session of 4 turns, whose inputs start with a to d.
+// Each turn takes 4+25 for the input and 4+1 for the answer, thus 34 tokens.
+func historySession() *model.Session {
+	ptr := func(id int) *int { return &id }
+	var chats []*model.Chat
+	for i := range 4 {
+		var parentID *int
+		if i > 0 {
+			parentID = ptr(i)
+		}
+		chats = append(chats, &model.Chat{
+			ChatPart: model.ChatPart{ID: i + 1},
+			ParentID: parentID,
+			Input:    string(rune('a'+i)) + strings.Repeat(" x", 24),
+			Result: &model.Result{
+				FinishReason: openai.FinishReasonStop,
+				Role:         "assistant",
+				Content:      "ok",
+				Status:       model.ResultStatusComplete,
+			},
+		})
+	}
+	return &model.Session{ActiveChatID: ptr(4), Chats: chats}
+}
+
+// inputs returns first letters of inputs in messages.
+func inputs(messages []openai.Message) []string {
+	var ret []string
+	for _, m := range messages {
+		if m.Role == "user" {
+			ret = append(ret, m.Content[:1])
+		}
+	}
+	return ret
+}
+
+func TestService_history(t *testing.T) {
+	ses := historySession()
+	s := &Service{tools: &tool.Registry{}}
+
+	tests := []struct {
+		name  string
+		model provider.ModelConfig
+		want  []string // first letters of inputs in history
+	}{
+		{"unknown window", provider.ModelConfig{}, []string{"a", "b", "c", "d"}},
+		{"fits", provider.ModelConfig{ContextWindow: 1000, MaxOutputTokens: 100}, []string{"a", "b", "c", "d"}},
+		// 200-100-5 leaves 95 for 2 turns
+		{"drop", provider.ModelConfig{ContextWindow: 200, MaxOutputTokens: 100}, []string{"c", "d"}},
+		{"window", provider.ModelConfig{
+			ContextWindow:   200,
+			MaxOutputTokens: 100,
+			Compaction:      provider.CompactionWindow,
+			WindowTurns:     1,
+		}, []string{"d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &provider.Route{ModelConfig: tt.model}
+			messages, err := s.history(context.Background(), ses, route, &RequestPayload{Content: "e"}, false)
+			if err != nil {
+				t.Fatalf("history() error = %v", err)
+			}
+			if got := inputs(messages); !slices.Equal(got, tt.want) {
+				t.Errorf("history() inputs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
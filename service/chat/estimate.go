@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"aiagent/clients/provider"
+	"aiagent/helpers/pricer"
+	"aiagent/helpers/tokens"
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/hyisen/wf"
+	"gorm.io/gorm"
+)
+
+// Estimate is what a chat would take, counted offline before it's sent.
+type Estimate struct {
+	Model        openai.ChatModel
+	PromptTokens int // of the compacted history, the input and tools
+	// CachedTokens is the part of PromptTokens shared with the last request of the session as a prefix,
+	// which upstream is likely to hit in its cache. It's the best case, as caches expire and some count in blocks.
+	CachedTokens int
+	PromptCost   string // by the price effective now, empty if the model is not priced
+	// MaxCost is PromptCost with the most output tokens, empty if neither max_tokens nor the model limits it.
+	MaxCost string
+}
+
+// Estimate counts what chatting req would take, without saving anything or calling upstream.
+// The first round is counted only, as whether tools are called is unknown before the answer.
+func (s *Service) Estimate(ctx context.Context, req *Request) (*Estimate, *wf.CodedError) {
+	route, _, e := s.validate(&req.RequestPayload)
+	if e != nil {
+		return nil, e
+	}
+	ses, err := s.sessionRepository.FindWithChatsByUserIDAndScopedID(ctx, req.UserID, req.SessionScopedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, wf.NewCodedErrorf(http.StatusNotFound, "no session %d-%d to estimate", req.UserID, req.SessionScopedID)
+	}
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+
+	history, e := s.history(ctx, ses, route, &req.RequestPayload, true)
+	if e != nil {
+		return nil, e
+	}
+	messages := append(history, openai.NewUserMessage(req.Content))
+	previous, e := s.previousMessages(ctx, ses, route, &req.RequestPayload)
+	if e != nil {
+		return nil, e
+	}
+
+	vocab := route.Vocab()
+	ret := &Estimate{
+		Model:        route.Name,
+		PromptTokens: s.toolsTokens(vocab) + tokens.Messages(vocab, messages),
+		CachedTokens: 0,
+		PromptCost:   "",
+		MaxCost:      "",
+	}
+	if shared := commonPrefix(previous, messages); len(shared) > 0 {
+		// Tools come before messages in templates of upstream.
+		ret.CachedTokens = s.toolsTokens(vocab) + tokens.Messages(vocab, shared)
+	}
+
+	price, err := s.prices.Price(route.Name, time.Now())
+	if err != nil {
+		// Tokens are still estimated, while the cost is unknown rather than free.
+		return ret, nil
+	}
+	usage := openai.Usage{
+		PromptTokens:         ret.PromptTokens,
+		PromoteTokensDetails: openai.PromoteTokensDetails{CachedTokens: ret.CachedTokens},
+	}
+	ret.PromptCost = price.Cost(pricer.OpenAIUsage(usage))
+	switch {
+	case req.MaxTokens != nil:
+		usage.CompletionTokens = *req.MaxTokens
+	case route.MaxOutputTokens > 0:
+		usage.CompletionTokens = route.MaxOutputTokens
+	}
+	if usage.CompletionTokens > 0 {
+		ret.MaxCost = price.Cost(pricer.OpenAIUsage(usage))
+	}
+	return ret, nil
+}
+
+// previousMessages returns what the last round of the active chat of ses sent, nil if there is no such chat.
+// It's rebuilt as history is not stored, thus compacted by what's stored now with req.
+func (s *Service) previousMessages(
+	ctx context.Context,
+	ses *model.Session,
+	route *provider.Route,
+	req *RequestPayload,
+) ([]openai.Message, *wf.CodedError) {
+	if ses.ActiveChatID == nil {
+		return nil, nil
+	}
+	active := ses.FindChat(*ses.ActiveChatID)
+	before := *ses
+	before.ActiveChatID = active.ParentID
+	previous := *req
+	previous.Content = active.Input
+	ret, e := s.history(ctx, &before, route, &previous, true)
+	if e != nil {
+		return nil, e
+	}
+	ret = append(ret, openai.NewUserMessage(active.Input))
+	for _, step := range model.StepMessages(active.Steps) {
+		ret = append(ret, step.HistoryRecord())
+	}
+	return ret, nil
+}
+
+// commonPrefix returns the longest prefix of b that a starts with too.
+func commonPrefix(a, b []openai.Message) []openai.Message {
+	var i int
+	for i < len(a) && i < len(b) && reflect.DeepEqual(a[i].HistoryRecord(), b[i].HistoryRecord()) {
+		i++
+	}
+	return b[:i]
+}
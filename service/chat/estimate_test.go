@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"aiagent/clients/provider"
+	"aiagent/service/tool"
+	"context"
+	"testing"
+)
+
+func TestService_previousMessages(t *testing.T) {
+	ptr := func(id int) *int { return &id }
+	answer := &model.Result{
+		FinishReason: openai.FinishReasonStop,
+		Role:         "assistant",
+		Content:      "ok",
+		Status:       model.ResultStatusComplete,
+	}
+	ses := &model.Session{SystemPrompt: "be brief", Chats: []*model.Chat{
+		{ChatPart: model.ChatPart{ID: 1}, Input: "a", Result: answer},
+		{ChatPart: model.ChatPart{ID: 2}, ParentID: ptr(1), Input: "b", Result: answer},
+	}}
+	s := &Service{tools: &tool.Registry{}}
+	route := &provider.Route{}
+	req := &RequestPayload{Content: "c"}
+
+	tests := []struct {
+		name         string
+		activeChatID *int
+		wantPrevious int // messages the last request sent
+		wantShared   int // messages the next request shares with it as a prefix
+	}{
+		{"no turn", nil, 0, 0},
+		{"first turn", ptr(1), 2, 2},
+		// system, a, ok, b; only the answer of b is new before c
+		{"second turn", ptr(2), 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ses.ActiveChatID = tt.activeChatID
+			previous, err := s.previousMessages(context.Background(), ses, route, req)
+			if err != nil {
+				t.Fatalf("previousMessages() error = %v", err)
+			}
+			if len(previous) != tt.wantPrevious {
+				t.Errorf("previousMessages() got %d messages, want %d", len(previous), tt.wantPrevious)
+			}
+			history, _ := s.history(context.Background(), ses, route, req, true)
+			next := append(history, openai.NewUserMessage(req.Content))
+			if got := len(commonPrefix(previous, next)); got != tt.wantShared {
+				t.Errorf("commonPrefix() got %d messages, want %d", got, tt.wantShared)
+			}
+		})
+	}
+}
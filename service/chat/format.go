@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"aiagent/clients/openai"
+	"aiagent/helpers/schema"
+	"errors"
+	"fmt"
+)
+
+// jsonObject is what json_object means in schema.
+var jsonObject = func() *schema.Schema {
+	ret, err := schema.Parse([]byte(`{"type":"object"}`))
+	if err != nil {
+		panic(err) // a constant which is tested by every json_object chat
+	}
+	return ret
+}()
+
+// newFormatSchema returns what answers shall conform to, nil if nothing is required.
+func newFormatSchema(format *openai.ResponseFormat) (*schema.Schema, error) {
+	if format == nil {
+		return nil, nil
+	}
+	switch format.Type {
+	case openai.ResponseFormatTypeText:
+		return nil, nil
+	case openai.ResponseFormatTypeJSONObject:
+		return jsonObject, nil
+	case openai.ResponseFormatTypeJSONSchema:
+		if format.JSONSchema == nil || format.JSONSchema.Name == "" {
+			return nil, errors.New("response_format json_schema requires json_schema with name")
+		}
+		return schema.Parse(format.JSONSchema.Schema)
+	default:
+		return nil, fmt.Errorf("unknown response_format type %q", format.Type)
+	}
+}
+
+// checkFormat validates the answer in cc against the response format of c.
+// Partial answers are not checked, as they are incomplete anyway, and the continued part is checked as a whole.
+func (c *conversation) checkFormat(cc *openai.ChatCompletion) error {
+	if c.schema == nil || !cc.Valid() || cc.Choices[0].FinishReason != openai.FinishReasonStop {
+		return nil
+	}
+	content := cc.Choices[0].Message.Content
+	if c.continued != nil {
+		content = c.continued.Content + content
+	}
+	if err := c.schema.Validate([]byte(content)); err != nil {
+		return fmt.Errorf("answer does not conform to response_format: %w", err)
+	}
+	return nil
+}
+
+// repair records the invalid answer in cc, and asks upstream to correct it in the next round.
+// It's tried once only, ok is false if it has been done.
+func (c *conversation) repair(cc *openai.ChatCompletion, invalid error) (ok bool) {
+	if c.repaired {
+		return false
+	}
+	c.repaired = true
+	c.usage = c.usage.Add(cc.Usage)
+	c.steps = append(c.steps,
+		cc.Choices[0].Message.HistoryRecord(),
+		openai.NewUserMessage(fmt.Sprintf(
+			"Your reply is rejected: %v. Reply again with the corrected JSON only.", invalid,
+		)),
+	)
+	return true
+}
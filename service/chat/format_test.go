@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"aiagent/clients/openai"
+	"encoding/json"
+	"testing"
+)
+
+func TestConversation_checkFormatAndRepair(t *testing.T) {
+	formatSchema, err := newFormatSchema(&openai.ResponseFormat{
+		Type: openai.ResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.JSONSchema{
+			Name:   "answer",
+			Schema: json.RawMessage(`{"type":"object","properties":{"n":{"type":"integer"}},"required":["n"]}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("newFormatSchema() error = %v", err)
+	}
+	answer := func(content string) *openai.ChatCompletion {
+		return &openai.ChatCompletion{Choices: []openai.Choice{{
+			Message:      openai.Message{Role: "assistant", Content: content},
+			FinishReason: openai.FinishReasonStop,
+		}}}
+	}
+	c := &conversation{schema: formatSchema}
+
+	if err := c.checkFormat(answer(`{"n":1}`)); err != nil {
+		t.Errorf("checkFormat() valid error = %v", err)
+	}
+	invalid := c.checkFormat(answer("```json\n{\"n\":1}\n```"))
+	if invalid == nil {
+		t.Fatalf("checkFormat() fenced got nil")
+	}
+	if !c.repair(answer("fenced"), invalid) || len(c.steps) != 2 || c.steps[1].Role != "user" {
+		t.Errorf("repair() first got steps %+v", c.steps)
+	}
+	if c.repair(answer("again"), invalid) {
+		t.Errorf("repair() second got ok")
+	}
+}
+
+func TestNewFormatSchema_Invalid(t *testing.T) {
+	tests := []*openai.ResponseFormat{
+		{Type: "yaml"},
+		{Type: openai.ResponseFormatTypeJSONSchema},
+		{Type: openai.ResponseFormatTypeJSONSchema, JSONSchema: &openai.JSONSchema{Name: "x", Schema: json.RawMessage(`1`)}},
+	}
+	for _, tt := range tests {
+		if _, err := newFormatSchema(tt); err == nil {
+			t.Errorf("newFormatSchema(%+v) want error got nil", tt)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package chat
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyisen/wf"
+	"gorm.io/gorm"
+)
+
+// idempotencyWindow is how long a key is honored since the chat it has created.
+const idempotencyWindow = 24 * time.Hour
+
+// maxIdempotencyKeyLength is beyond any UUID or hash a client would send.
+const maxIdempotencyKeyLength = 255
+
+// digest is of what p asks for, so that its IdempotencyKey is bound to it.
+func (p *RequestPayload) digest() string {
+	data, err := json.Marshal(struct {
+		*RequestPayload
+		Revision Revision `json:"revision"`
+	}{RequestPayload: p, Revision: p.Revision})
+	if err != nil {
+		// It's decoded from JSON, thus could be encoded again.
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// errKeyReused tells an Idempotency-Key is reused by another request, see [RequestPayload.digest].
+func errKeyReused(key string) *wf.CodedError {
+	return wf.NewCodedErrorf(http.StatusUnprocessableEntity, "Idempotency-Key %q reused by another request", key)
+}
+
+type flightKey struct {
+	sessionID int
+	key       string
+}
+
+// flight is a chat in generation for an idempotency key, which requests with the same one wait for.
+type flight struct {
+	key    flightKey
+	digest string        // of the request started it
+	done   chan struct{} // closed once it's over
+	st     *stream       // nil until it's streamed, or if it's not
+}
+
+// flights are those in generation by their keys.
+type flights struct {
+	mu sync.Mutex
+	m  map[flightKey]*flight
+}
+
+func newFlights() *flights {
+	return &flights{m: make(map[flightKey]*flight)}
+}
+
+// join returns the flight of k, starting one of digest if none, which started tells.
+func (r *flights) join(k flightKey, digest string) (_ *flight, started bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ret, ok := r.m[k]; ok {
+		return ret, false
+	}
+	ret := &flight{key: k, digest: digest, done: make(chan struct{}), st: nil}
+	r.m[k] = ret
+	return ret, true
+}
+
+// stream tells requests joining f later to attach to st.
+func (r *flights) stream(f *flight, st *stream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f.st = st
+}
+
+// attached returns the stream of f, nil if none yet.
+func (r *flights) attached(f *flight) *stream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return f.st
+}
+
+// land ends f started by join, nil for none.
+func (r *flights) land(f *flight) {
+	if f == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.m, f.key)
+	close(f.done)
+}
+
+// recall finds what an earlier request with key in the session has got, which is either the chat it has saved,
+// or the stream it's generating if attach is set. Otherwise, none is found, and a flight is started for key,
+// which must be landed once the chat is saved.
+// A request with the same key in flight is waited for, unless it's attached to.
+// The key reused by a request of another digest is rejected, see [RequestPayload.digest].
+func (s *Service) recall(ctx context.Context, sessionID int, key string, digest string, attach bool) (
+	earlier *model.Chat,
+	live *stream,
+	started *flight,
+	_ *wf.CodedError,
+) {
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, nil, nil, wf.NewCodedErrorf(
+			http.StatusBadRequest,
+			"Idempotency-Key longer than %d",
+			maxIdempotencyKeyLength,
+		)
+	}
+	k := flightKey{sessionID: sessionID, key: key}
+	for {
+		f, ok := s.flights.join(k, digest)
+		if !ok && f.digest != digest {
+			return nil, nil, nil, errKeyReused(key)
+		}
+		if ok {
+			// Found after joining, so that none with key starts meanwhile.
+			since := time.Now().Add(-idempotencyWindow).UnixMilli()
+			ret, err := s.chatRepository.FindLastByIdempotencyKey(ctx, sessionID, key, since)
+			if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && ret.Result == nil) {
+				// One failed without an answer is tried again, as nothing is charged.
+				return nil, nil, f, nil
+			}
+			s.flights.land(f)
+			if err != nil {
+				return nil, nil, nil, wf.NewCodedError(http.StatusInternalServerError, err)
+			}
+			if ret.RequestDigest != nil && *ret.RequestDigest != digest {
+				return nil, nil, nil, errKeyReused(key)
+			}
+			return ret, nil, nil, nil
+		}
+		if st := s.flights.attached(f); attach && st != nil {
+			return nil, st, nil, nil
+		}
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, nil, nil, wf.NewCodedErrorf(http.StatusConflict, "request of Idempotency-Key %q in flight", key)
+		}
+	}
+}
+
+// replay emits the saved answer of neo as a stream would, in one piece for each part.
+// Tool calls come first, without what upstream said in their rounds, which is not saved.
+func replay(neo *model.Chat, emit func(wf.MessageEvent)) {
+	emit(wf.MessageEvent{TypeOptional: "chat", Lines: []string{strconv.Itoa(neo.ID)}})
+	for _, step := range model.StepMessages(neo.Steps) {
+		for _, call := range step.ToolCalls {
+			emit(NewJSONMessageEvent("toolCall", call))
+		}
+		if step.ToolCallID != "" {
+			emit(NewJSONMessageEvent("toolResult", step))
+		}
+	}
+	cc := neo.Result.ChatCompletion()
+	message := cc.Choices[0].Message
+	chunks := []openai.ChatCompletionChunk{{
+		ChatCompletionBase: cc.ChatCompletionBase,
+		Choices:            []openai.ChunkChoice{{Delta: openai.Message{Role: message.Role}}},
+	}}
+	if message.ReasoningContent != "" {
+		chunks = append(chunks, openai.ChatCompletionChunk{
+			Choices: []openai.ChunkChoice{{Delta: openai.Message{ReasoningContent: message.ReasoningContent}}},
+		})
+	}
+	if message.Content != "" {
+		chunks = append(chunks, openai.ChatCompletionChunk{
+			Choices: []openai.ChunkChoice{{Delta: openai.Message{Content: message.Content}}},
+		})
+	}
+	chunks = append(chunks, openai.ChatCompletionChunk{
+		Choices: []openai.ChunkChoice{{FinishReason: &cc.Choices[0].FinishReason}},
+		Usage:   &cc.Usage,
+	})
+
+	var stage int
+	for _, chunk := range chunks {
+		stage = translate(stage, chunk, cc, emit)
+	}
+	if neo.Result.Partial() {
+		emit(NewJSONMessageEvent("partial", neo.Result.Status))
+	}
+}
+
+// replayed returns events of the saved earlier, whose stream is attached again if it's still kept.
+func (s *Service) replayed(ctx context.Context, earlier *model.Chat) <-chan wf.MessageEvent {
+	if st := s.streams.find(earlier.ID); st != nil {
+		return st.subscribe(ctx, 0)
+	}
+	st := newStream(earlier.SessionID, nil)
+	replay(earlier, st.emit)
+	st.end()
+	return st.subscribe(ctx, 0)
+}
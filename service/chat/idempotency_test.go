@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"slices"
+	"testing"
+
+	"github.com/hyisen/wf"
+)
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  []*model.Step
+		result *model.Result
+		want   []string // event types, "data" for an untyped one
+	}{
+		{
+			name:   "content only",
+			result: &model.Result{Role: "assistant", Content: "ok", FinishReason: "stop", Status: model.ResultStatusComplete},
+			want:   []string{"chat", "head", "role", "cotEnd", "data", "finish", "usage"},
+		},
+		{
+			name: "reasoning with tool calls",
+			steps: []*model.Step{
+				{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_0"}}},
+				{Role: "tool", Content: "12:00", ToolCallID: "call_0"},
+			},
+			result: &model.Result{
+				Role:             "assistant",
+				Content:          "noon",
+				ReasoningContent: "check the clock",
+				FinishReason:     "stop",
+				Status:           model.ResultStatusComplete,
+			},
+			want: []string{"chat", "toolCall", "toolResult", "head", "role", "data", "cotEnd", "data", "finish", "usage"},
+		},
+		{
+			name:   "cancelled before any content",
+			result: &model.Result{Role: "assistant", Status: model.ResultStatusCancelled},
+			want:   []string{"chat", "head", "role", "finish", "usage", "partial"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			replay(&model.Chat{ChatPart: model.ChatPart{ID: 1}, Steps: tt.steps, Result: tt.result}, func(me wf.MessageEvent) {
+				if me.TypeOptional == "" {
+					got = append(got, "data")
+					return
+				}
+				got = append(got, me.TypeOptional)
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("replay() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlights(t *testing.T) {
+	r := newFlights()
+	k := flightKey{sessionID: 1, key: "a"}
+	first, started := r.join(k, "d")
+	if !started {
+		t.Fatal("join() started = false for a new key")
+	}
+	if _, started := r.join(flightKey{sessionID: 2, key: "a"}, "d"); !started {
+		t.Error("join() started = false for the key of another session")
+	}
+	second, started := r.join(k, "e")
+	if started || second != first || second.digest != "d" {
+		t.Errorf("join() = %p, %v, want the one in flight %p of its digest", second, started, first)
+	}
+	r.land(first)
+	<-second.done
+	if _, started := r.join(k, "d"); !started {
+		t.Error("join() started = false after it landed")
+	}
+	r.land(nil)
+}
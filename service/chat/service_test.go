@@ -0,0 +1,453 @@
+//go:build sqlite_fts5
+
+package chat
+
+import (
+	"aiagent/clients/budget"
+	"aiagent/clients/chat"
+	"aiagent/clients/compaction"
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"aiagent/clients/provider"
+	"aiagent/clients/session"
+	"aiagent/helpers/pricer"
+	sb "aiagent/service/budget"
+	"aiagent/service/tool"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyisen/wf"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// upstream serves answers in order to requests, repeating the last one, as the non-stream response of model m.
+func upstream(t *testing.T, answers ...string) (baseURL string) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		answer := answers[min(requests, len(answers)-1)]
+		requests++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(answer))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// testService is a [Service] on an in-memory DB of docs/ddl.sql, whose only model m goes to baseURL.
+type testService struct {
+	*Service
+	db               *gorm.DB
+	budgetRepository *budget.Repository
+}
+
+func newTestService(t *testing.T, baseURL string, models []provider.ModelConfig, tools *tool.Registry) *testService {
+	db, err := gorm.Open(sqlite.Open("file::memory:?_foreign_keys=on"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1) // each connection opens its own memory database
+	ddl, err := os.ReadFile("../../docs/ddl.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(string(ddl)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO users VALUES (1000, 'u', 0)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if models == nil {
+		models = []provider.ModelConfig{{Name: "m", Provider: "stub"}}
+	}
+	providers, err := provider.New(provider.Config{
+		Providers: []provider.ProviderConfig{{Name: "stub", BaseURL: baseURL, Dialect: openai.DialectPlain}},
+		Models:    models,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices, err := pricer.NewTable(pricer.Config{Prices: []pricer.PriceConfig{
+		{Model: "m", EffectiveFrom: time.UnixMilli(0), Input: 1_000, CachedInput: 0, Output: 2_000, Unit: "USD"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, _ := session.NewRepository(db)
+	cr, _ := chat.NewRepository(db)
+	cpr, _ := compaction.NewRepository(db)
+	br, _ := budget.NewRepository(db)
+	return &testService{
+		Service:          NewService(providers, tools, cr, sr, cpr, nil, sb.NewService(br), prices),
+		db:               db,
+		budgetRepository: br,
+	}
+}
+
+// newSession returns the id of a new session of user 1000.
+func (s *testService) newSession(t *testing.T) int {
+	ctx := context.Background()
+	if err := s.sessionRepository.Create(ctx, 1000, t.Name(), ""); err != nil {
+		t.Fatal(err)
+	}
+	ses, err := s.sessionRepository.FindLastByUserIDAndName(ctx, 1000, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ses.ID
+}
+
+func TestService_continueSpent(t *testing.T) {
+	s := newTestService(t, upstream(t,
+		`{"id":"1","model":"m","choices":[{"message":{"role":"assistant","content":"one, "},"finish_reason":"length"}],
+			"usage":{"prompt_tokens":3,"completion_tokens":2}}`,
+		`{"id":"2","model":"m","choices":[{"message":{"role":"assistant","content":"two"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":5,"completion_tokens":4}}`,
+	), nil, &tool.Registry{})
+	ctx := context.Background()
+	sessionID := s.newSession(t)
+
+	if _, e := s.ChatSimple(ctx, sessionID, &RequestPayload{Content: "count"}); e != nil {
+		t.Fatal(e)
+	}
+	neo, e := s.ChatSaved(ctx, sessionID, &RequestPayload{Revision: RevisionContinue})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// Stored with both generations.
+	result := neo.Result
+	if result.Content != "one, two" || result.PromptTokens != 8 || result.CompletionTokens != 6 ||
+		result.CostNano == nil || *result.CostNano != 8*1_000+6*2_000 {
+		t.Errorf("continued result = %+v, want usage and cost of both generations", result)
+	}
+	// Spent once for each generation.
+	usages, err := s.budgetRepository.FindUsagesByUserIDSince(ctx, 1000, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.Usage{
+		UserID:           1000,
+		Day:              time.Now().Format(model.UsageDayLayout),
+		Model:            "m",
+		PromptTokens:     8,
+		CachedTokens:     0,
+		CompletionTokens: 6,
+		Cost:             8*1_000 + 6*2_000,
+		Unit:             "USD",
+	}
+	if len(usages) != 1 {
+		t.Fatalf("usages = %d rows, want 1", len(usages))
+	}
+	if *usages[0] != want {
+		t.Errorf("usage = %+v, want %+v", *usages[0], want)
+	}
+}
+
+func TestService_priceByRoute(t *testing.T) {
+	s := newTestService(t, upstream(t,
+		`{"id":"1","model":"m-2026-10-17","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":3,"completion_tokens":2}}`,
+	), []provider.ModelConfig{{Name: "m", Provider: "stub", UpstreamName: "m:8b"}}, &tool.Registry{})
+	ctx := context.Background()
+
+	neo, e := s.ChatSaved(ctx, s.newSession(t), &RequestPayload{Content: "hi"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	result := neo.Result
+	if result.Model != "m-2026-10-17" || result.Route != "m" || result.CostNano == nil || *result.CostNano != 3*1_000+2*2_000 {
+		t.Errorf("result = %+v, want model of upstream and cost of route m", result)
+	}
+	// So does backfill, by the route stored.
+	stored, err := s.chatRepository.FindByID(ctx, neo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Result.CostNano, stored.Result.CostUnit = nil, ""
+	if err := Price(s.prices, stored); err != nil || *stored.Result.CostNano != *result.CostNano {
+		t.Errorf("Price() of stored = %v, %v, want %d", stored.Result.CostNano, err, *result.CostNano)
+	}
+}
+
+// streamUpstream serves chunks as the stream response of model m to every request.
+func streamUpstream(t *testing.T, chunks ...string) (baseURL string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// checkToolRoundsExceeded checks neo is saved failed with steps of all rounds allowed.
+func checkToolRoundsExceeded(t *testing.T, s *testService, neo *model.Chat) {
+	t.Helper()
+	stored, err := s.chatRepository.FindByID(context.Background(), neo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Steps) != 2*maxToolRounds {
+		t.Errorf("steps = %d, want a call and its reply of each of %d rounds", len(stored.Steps), maxToolRounds)
+	}
+	if stored.Result == nil || stored.Result.Status != model.ResultStatusUpstreamError {
+		t.Errorf("result = %+v, want status %s", stored.Result, model.ResultStatusUpstreamError)
+	}
+}
+
+func TestService_toolRoundsExceeded(t *testing.T) {
+	tools, err := tool.NewRegistry(tool.Clock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t, upstream(t,
+		`{"id":"1","model":"m","choices":[{"message":{"role":"assistant","tool_calls":[
+			{"id":"c","type":"function","function":{"name":"clock","arguments":"{}"}}]},"finish_reason":"tool_calls"}],
+			"usage":{"prompt_tokens":3,"completion_tokens":2}}`,
+	), nil, tools)
+
+	neo, e := s.ChatSaved(context.Background(), s.newSession(t), &RequestPayload{Content: "what time is it"})
+	if e == nil || e.Code != http.StatusBadGateway || !errors.Is(e.Err, errToolRounds) {
+		t.Fatalf("ChatSaved() error = %v, want %d %v", e, http.StatusBadGateway, errToolRounds)
+	}
+	checkToolRoundsExceeded(t, s, neo)
+}
+
+func TestService_toolRoundsExceededStream(t *testing.T) {
+	tools, err := tool.NewRegistry(tool.Clock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t, streamUpstream(t,
+		`{"id":"1","model":"m","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[`+
+			`{"index":0,"id":"c","type":"function","function":{"name":"clock","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`,
+	), nil, tools)
+	sessionID := s.newSession(t)
+
+	events, e := s.ChatStreamSimple(context.Background(), sessionID, &RequestPayload{Content: "what time is it"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var last wf.MessageEvent
+	for event := range events {
+		last = event
+	}
+	if last.TypeOptional != "error" || !strings.Contains(strings.Join(last.Lines, "\n"), errToolRounds.Error()) {
+		t.Errorf("last event = %+v, want error of %v", last, errToolRounds)
+	}
+	neo, err := s.chatRepository.FindLastBySessionID(context.Background(), sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkToolRoundsExceeded(t, s, neo)
+}
+
+// stubSummarizer returns summary or fails by e, recording what it's asked to summarize.
+type stubSummarizer struct {
+	summary  string
+	e        *wf.CodedError
+	previous string
+	inputs   []string // first letters of inputs of chats
+}
+
+func (s *stubSummarizer) Summarize(_ context.Context, previous string, chats []*model.Chat) (string, *wf.CodedError) {
+	s.previous = previous
+	for _, c := range chats {
+		s.inputs = append(s.inputs, c.Input[:1])
+	}
+	return s.summary, s.e
+}
+
+func TestService_historySummarize(t *testing.T) {
+	tests := []struct {
+		name         string
+		systemPrompt string
+		previous     string // compacted up to chat a, none if empty
+		summarizer   *stubSummarizer
+		want         []string // first letters of inputs in history
+		wantSummary  string   // in history, none if empty
+		wantAsked    []string // of chats to summarize
+	}{
+		{"with head", "Be brief.", "", &stubSummarizer{summary: "S"}, []string{"d"}, "S", []string{"a", "b", "c"}},
+		{"after previous", "", "P", &stubSummarizer{summary: "S"}, []string{"d"}, "S", []string{"b", "c"}},
+		{"failed to drop", "Be brief.", "P",
+			&stubSummarizer{e: wf.NewCodedErrorf(http.StatusBadGateway, "down")}, []string{"c", "d"}, "P", []string{"b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, "", nil, &tool.Registry{})
+			s.summarizer = tt.summarizer
+			ctx := context.Background()
+			ses := historySession()
+			ses.ID = s.newSession(t)
+			ses.SystemPrompt = tt.systemPrompt
+			for _, c := range ses.Chats {
+				if err := s.db.Exec("INSERT INTO chats (id, session_id, input, create_time, parent_id) VALUES (?, ?, ?, 0, ?)",
+					c.ID, ses.ID, c.Input, c.ParentID).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.previous != "" {
+				if err := s.compactionRepository.Save(ctx, &model.Compaction{SessionID: ses.ID, ChatID: 1, Summary: tt.previous}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// 200-100-5 leaves 95 for 2 turns, the latest of which is kept in half of it.
+			route := &provider.Route{ModelConfig: provider.ModelConfig{
+				ContextWindow:   200,
+				MaxOutputTokens: 100,
+				Compaction:      provider.CompactionSummarize,
+			}}
+			messages, e := s.history(ctx, ses, route, &RequestPayload{Content: "e"}, false)
+			if e != nil {
+				t.Fatalf("history() error = %v", e)
+			}
+			if got := inputs(messages); !slices.Equal(got, tt.want) {
+				t.Errorf("history() inputs = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(tt.summarizer.inputs, tt.wantAsked) || tt.summarizer.previous != tt.previous {
+				t.Errorf("Summarize() asked %v after %q, want %v after %q",
+					tt.summarizer.inputs, tt.summarizer.previous, tt.wantAsked, tt.previous)
+			}
+			var summary string
+			for _, m := range messages {
+				if m.Role == "system" && m.Content != tt.systemPrompt {
+					summary = strings.TrimPrefix(m.Content, "Summary of the earlier conversation:\n")
+				}
+			}
+			if summary != tt.wantSummary {
+				t.Errorf("history() summary = %q, want %q", summary, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func TestService_streamUsageLast(t *testing.T) {
+	s := newTestService(t, streamUpstream(t,
+		`{"id":"1","model":"m","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}`,
+		`{"id":"1","model":"m","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`{"id":"1","model":"m","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2}}`,
+	), nil, &tool.Registry{})
+	ctx := context.Background()
+	sessionID := s.newSession(t)
+
+	events, e := s.ChatStreamSimple(ctx, sessionID, &RequestPayload{Content: "hi"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var finish, usage []string
+	for event := range events {
+		switch event.TypeOptional {
+		case "finish":
+			finish = event.Lines
+		case "usage":
+			usage = event.Lines
+		}
+	}
+	if !slices.Equal(finish, []string{"stop"}) || len(usage) != 1 || !strings.Contains(usage[0], `"prompt_tokens":3`) {
+		t.Errorf("events finish %v usage %v, want stop with usage of the last chunk", finish, usage)
+	}
+	last, err := s.chatRepository.FindLastBySessionID(ctx, sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	neo, err := s.chatRepository.FindByID(ctx, last.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := neo.Result
+	if result.Status != model.ResultStatusComplete || result.PromptTokens != 3 || result.CompletionTokens != 2 ||
+		result.CostNano == nil || *result.CostNano != 3*1_000+2*2_000 {
+		t.Errorf("result = %+v, want complete with usage and cost", result)
+	}
+}
+
+func TestService_continueWithKey(t *testing.T) {
+	s := newTestService(t, upstream(t,
+		`{"id":"1","model":"m","choices":[{"message":{"role":"assistant","content":"one, "},"finish_reason":"length"}]}`,
+		`{"id":"2","model":"m","choices":[{"message":{"role":"assistant","content":"two"},"finish_reason":"stop"}]}`,
+	), nil, &tool.Registry{})
+	ctx := context.Background()
+	sessionID := s.newSession(t)
+
+	if _, e := s.ChatSaved(ctx, sessionID, &RequestPayload{Content: "count", IdempotencyKey: "k"}); e != nil {
+		t.Fatal(e)
+	}
+	// The same key as the chat continued, which is not recalled.
+	neo, e := s.ChatSaved(ctx, sessionID, &RequestPayload{Revision: RevisionContinue, IdempotencyKey: "k"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if neo.Result.Content != "one, two" || neo.Result.Status != model.ResultStatusComplete {
+		t.Errorf("continued result = %+v, want the partial answer continued", neo.Result)
+	}
+}
+
+func TestService_keyReused(t *testing.T) {
+	s := newTestService(t, upstream(t,
+		`{"id":"1","model":"m","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`,
+	), nil, &tool.Registry{})
+	ctx := context.Background()
+	sessionID := s.newSession(t)
+
+	first, e := s.ChatSaved(ctx, sessionID, &RequestPayload{Content: "hello", IdempotencyKey: "k"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	again, e := s.ChatSaved(ctx, sessionID, &RequestPayload{Content: "hello", IdempotencyKey: "k"})
+	if e != nil || again.ID != first.ID {
+		t.Errorf("ChatSaved() again = %v, %v, want the chat %d recalled", again, e, first.ID)
+	}
+	_, e = s.ChatSaved(ctx, sessionID, &RequestPayload{Content: "bye", IdempotencyKey: "k"})
+	if e == nil || e.Code != http.StatusUnprocessableEntity {
+		t.Errorf("ChatSaved() of another request with the key error = %v, want 422", e)
+	}
+}
+
+func TestService_stepSignature(t *testing.T) {
+	s := newTestService(t, upstream(t), nil, &tool.Registry{})
+	ctx := context.Background()
+	sessionID := s.newSession(t)
+
+	call := openai.Message{Role: "assistant", ReasoningContent: "think", ReasoningSignature: "sig", ToolCalls: []openai.ToolCall{
+		{ID: "call_0", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "clock", Arguments: "{}"}},
+	}}
+	neo := &model.Chat{
+		ChatPart: model.ChatPart{SessionID: sessionID, CreateTime: time.Now().UnixMilli()},
+		Input:    "what time",
+		Steps:    model.NewSteps([]openai.Message{call, openai.NewToolMessage("call_0", "noon")}),
+	}
+	if err := s.chatRepository.Save(ctx, neo); err != nil {
+		t.Fatal(err)
+	}
+	// Reloaded, thinking is passed back to DialectAnthropic by its signature.
+	saved, err := s.chatRepository.FindByID(ctx, neo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := model.StepMessages(saved.Steps)[0].ReasoningSignature; got != "sig" {
+		t.Errorf("ReasoningSignature of the saved step = %q, want sig", got)
+	}
+}
@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hyisen/wf"
+)
+
+// streamRetention is how long events of an ended stream are kept, for a client coming back late.
+const streamRetention = 5 * time.Minute
+
+// errCancelled is the cause of a generation cancelled by [Service.Cancel].
+var errCancelled = errors.New("cancelled by the client")
+
+// cancelled returns whether ctx of a generation is done by [Service.Cancel].
+func cancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errCancelled)
+}
+
+// stream buffers events of an in-flight chat, so that a client could reattach after its connection drops.
+// Events are numbered from 1 in order, which is their SSE id.
+type stream struct {
+	sessionID int
+	cancel    context.CancelCauseFunc // of the generation, nil if it can not be cancelled
+	mu        sync.Mutex
+	events    []wf.MessageEvent
+	ended     bool
+	changed   chan struct{} // closed and replaced on every change
+	done      chan struct{} // closed once ended
+}
+
+func newStream(sessionID int, cancel context.CancelCauseFunc) *stream {
+	return &stream{sessionID: sessionID, cancel: cancel, changed: make(chan struct{}), done: make(chan struct{})}
+}
+
+// emit never blocks, so that the generation goes on at its pace whoever is listening.
+func (s *stream) emit(me wf.MessageEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, me)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *stream) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	close(s.changed)
+	s.changed = make(chan struct{})
+	close(s.done)
+}
+
+// abort cancels the generation unless the stream has ended, returns whether it's cancelled.
+// The stream ends once what's generated is saved, see done.
+func (s *stream) abort() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended || s.cancel == nil {
+		return false
+	}
+	s.cancel(errCancelled)
+	return true
+}
+
+// len returns how many events have been emitted, which is the ID of the last one.
+func (s *stream) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events)
+}
+
+// subscribe sends events after the one on lastID, until the stream ends or ctx is done.
+// lastID must be in [0, len()].
+func (s *stream) subscribe(ctx context.Context, lastID int) <-chan wf.MessageEvent {
+	ret := make(chan wf.MessageEvent)
+	go func() {
+		defer close(ret)
+		next := lastID
+		for {
+			s.mu.Lock()
+			// Emitted ones are never changed, so they are safe to read after unlock.
+			pending, ended, changed := s.events[next:], s.ended, s.changed
+			s.mu.Unlock()
+			for _, me := range pending {
+				select {
+				case ret <- me:
+					next++
+				case <-ctx.Done():
+					slog.Warn("client gone", "error", ctx.Err(), "sent", next)
+					return
+				}
+			}
+			if len(pending) > 0 {
+				continue
+			}
+			if ended {
+				return
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				slog.Warn("client gone", "error", ctx.Err(), "sent", next)
+				return
+			}
+		}
+	}()
+	return ret
+}
+
+// streams are those in flight or recently ended by ID of their chats,
+// which is also where an in-flight generation is found to be cancelled.
+type streams struct {
+	mu sync.Mutex
+	m  map[int]*stream
+}
+
+func newStreams() *streams {
+	return &streams{m: make(map[int]*stream)}
+}
+
+// open starts a stream of the chat on chatID, replacing the ended one of it, if any, which a continuation makes.
+// cancel aborts the generation behind it.
+func (r *streams) open(sessionID int, chatID int, cancel context.CancelCauseFunc) *stream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := newStream(sessionID, cancel)
+	r.m[chatID] = ret
+	return ret
+}
+
+// close ends st of the chat on chatID, which would be forgotten after streamRetention.
+func (r *streams) close(chatID int, st *stream) {
+	st.end()
+	time.AfterFunc(streamRetention, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.m[chatID] == st {
+			delete(r.m, chatID)
+		}
+	})
+}
+
+// find returns the stream of the chat on chatID, nil if none.
+func (r *streams) find(chatID int) *stream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.m[chatID]
+}
@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/hyisen/wf"
+)
+
+func TestStream_subscribe(t *testing.T) {
+	event := func(word string) wf.MessageEvent { return wf.MessageEvent{Lines: []string{word}} }
+	collect := func(ch <-chan wf.MessageEvent) []string {
+		var ret []string
+		for me := range ch {
+			ret = append(ret, me.Lines[0])
+		}
+		return ret
+	}
+
+	st := newStream(1, nil)
+	st.emit(event("a"))
+	st.emit(event("b"))
+	from0 := st.subscribe(context.Background(), 0)
+	from1 := st.subscribe(context.Background(), 1)
+	st.emit(event("c"))
+	st.end()
+
+	tests := []struct {
+		name string
+		ch   <-chan wf.MessageEvent
+		want []string
+	}{
+		{"from start", from0, []string{"a", "b", "c"}},
+		{"after the first", from1, []string{"b", "c"}},
+		{"after it ended", st.subscribe(context.Background(), 2), []string{"c"}},
+		{"all received", st.subscribe(context.Background(), 3), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collect(tt.ch); !slices.Equal(got, tt.want) {
+				t.Errorf("subscribe() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStream_subscribeGone(t *testing.T) {
+	st := newStream(1, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := st.subscribe(ctx, 0)
+	cancel()
+	// Closed though the stream goes on, so that a gone client holds nothing.
+	for range ch {
+		t.Error("subscribe() sent after the client has gone")
+	}
+	st.emit(wf.MessageEvent{Lines: []string{"a"}})
+	if st.len() != 1 {
+		t.Errorf("len() = %d, want 1", st.len())
+	}
+}
+
+func TestStream_abort(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	st := newStream(1, cancel)
+	if !st.abort() {
+		t.Fatal("abort() = false before it ended")
+	}
+	if !cancelled(ctx) {
+		t.Errorf("cancelled() = false, cause %v", context.Cause(ctx))
+	}
+	st.end()
+	<-st.done
+	if st.abort() {
+		t.Error("abort() = true after it ended")
+	}
+	if newStream(1, nil).abort() {
+		t.Error("abort() = true without a cancel")
+	}
+}
@@ -3,6 +3,7 @@ package digest
 import (
 	"aiagent/clients/model"
 	"aiagent/clients/openai"
+	"aiagent/clients/provider"
 	"aiagent/clients/session"
 	"aiagent/helpers/matcher"
 	"aiagent/helpers/pricer"
@@ -14,18 +15,29 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/hyisen/wf"
 	"gorm.io/gorm"
 )
 
 type Service struct {
-	client            *openai.Client
+	route             *provider.Route // where digest goes, a cheap and fast model preferred
 	sessionRepository *session.Repository
+	prices            *pricer.Table
 }
 
-func NewService(client *openai.Client, sessionRepository *session.Repository) *Service {
-	return &Service{client: client, sessionRepository: sessionRepository}
+func NewService(route *provider.Route, sessionRepository *session.Repository, prices *pricer.Table) *Service {
+	return &Service{route: route, sessionRepository: sessionRepository, prices: prices}
+}
+
+// price formats what cc costs for logging, telling if the model is not priced.
+func (s *Service) price(cc *openai.ChatCompletion) string {
+	price, err := s.prices.Price(s.route.Name, time.Unix(cc.Created, 0))
+	if err != nil {
+		return err.Error()
+	}
+	return price.Cost(pricer.OpenAIUsage(cc.Usage))
 }
 
 func (s *Service) generateTitleAndSave(ctx context.Context, sessionID int) (neo *model.Session, e *wf.CodedError) {
@@ -45,25 +57,24 @@ func (s *Service) generateTitleAndSave(ctx context.Context, sessionID int) (neo
 
 	req := openai.NewRequest(
 		[]openai.Message{openai.NewUserMessage(prompt)},
-		s.digestChatModel(),
+		s.route.UpstreamModel(),
 		openai.ReasoningEffortNone,
 	)
-	// `s.client.OneShotStream` behaves identical on rejected inputs,
+	// `OneShotStream` behaves identical on rejected inputs,
 	// as just rejected in content, with a normal FinishReasonStop.
 	// Make sense as it's reject to response,
 	// not response but filtered as FinishReasonContentFilter.
 	// And that's why I add safe-word mechanism.
-	cc, err := s.client.OneShot(ctx, req)
+	cc, err := s.route.Client.OneShot(ctx, req)
 	if err != nil {
-		return nil, wf.NewCodedError(http.StatusServiceUnavailable, err)
+		return nil, wf.NewCodedError(openai.HTTPStatus(err), err)
 	}
 	name, ce := extractNewName(cc, safeWord)
 	if ce != nil {
 		return nil, ce
 	}
 
-	price := pricer.PriceOrDefault(s.digestChatModel()).Cost(pricer.OpenAIUsage(cc.Usage))
-	slog.Info("session name generated", "name", name, "prompt_length", len(prompt), "price", price, "usage", cc.Usage)
+	slog.Info("session name generated", "name", name, "prompt_length", len(prompt), "price", s.price(cc), "usage", cc.Usage)
 
 	if err := s.sessionRepository.UpdateName(ctx, sessionID, name); err != nil {
 		return nil, wf.NewCodedError(http.StatusServiceUnavailable, err)
@@ -78,6 +89,29 @@ func (s *Service) GenerateTitle(ctx context.Context, sessionID int) *wf.CodedErr
 	return err
 }
 
+// Summarize condenses chats, which follow what previous summarizes, so that they could be replaced in history.
+func (s *Service) Summarize(ctx context.Context, previous string, chats []*model.Chat) (summary string, _ *wf.CodedError) {
+	prompt, ce := Summary(previous, chats)
+	if ce != nil {
+		return "", ce
+	}
+	req := openai.NewRequest(
+		[]openai.Message{openai.NewUserMessage(prompt)},
+		s.route.UpstreamModel(),
+		openai.ReasoningEffortNone,
+	)
+	cc, err := s.route.Client.OneShot(ctx, req)
+	if err != nil {
+		return "", wf.NewCodedError(openai.HTTPStatus(err), err)
+	}
+	if !cc.Valid() || cc.Choices[0].FinishReason != openai.FinishReasonStop {
+		return "", wf.NewCodedErrorf(http.StatusBadGateway, "upstream summarizes abnormally %+v", cc)
+	}
+
+	slog.Info("chats summarized", "chats", len(chats), "prompt_length", len(prompt), "price", s.price(cc), "usage", cc.Usage)
+	return cc.Choices[0].Message.Content, nil
+}
+
 func extractNewName(cc *openai.ChatCompletion, safeWord string) (string, *wf.CodedError) {
 	if !cc.Valid() {
 		return "", wf.NewCodedErrorf(http.StatusServiceUnavailable, "invalid %+v", cc)
@@ -101,22 +135,22 @@ func extractNewName(cc *openai.ChatCompletion, safeWord string) (string, *wf.Cod
 		return "", wf.NewCodedErrorf(http.StatusUnavailableForLegalReasons, "upstream said %v", choice.FinishReason)
 	case openai.FinishReasonInsufficientSystemResource:
 		return "", wf.NewCodedErrorf(http.StatusServiceUnavailable, "upstream says %v", choice.FinishReason)
-	case openai.FinishReasonLength:
-		fallthrough
 	case openai.FinishReasonToolCalls:
+		// No tool is offered in the request, so it's upstream misbehaving rather than an invariant broken here.
+		return "", wf.NewCodedErrorf(http.StatusBadGateway, "upstream calls tools %+v while none offered", choice.Message.ToolCalls)
+	case openai.FinishReasonLength:
 		fallthrough
 	default:
 		panic(fmt.Errorf("unexpected finish reason %q", choice.FinishReason))
 	}
 }
 
-func (s *Service) digestChatModel() openai.ChatModel {
-	return openai.ChatModelDeepSeekV4Flash
-}
-
 func (s *Service) concurrentLimit() int {
-	upstream := s.digestChatModel().ConcurrentLimit() / 2 // yield half to others
-	local := runtime.NumCPU() * 20                        // fan out 20x as it's more concurrent than parallelism
+	local := runtime.NumCPU() * 20 // fan out 20x as it's more concurrent than parallelism
+	if s.route.ConcurrentLimit == 0 {
+		return local // unknown upstream limit, typically a local server, which would queue what it can't serve
+	}
+	upstream := max(s.route.ConcurrentLimit/2, 1) // yield half to others
 	return min(upstream, local)
 }
 
@@ -56,6 +56,36 @@ func Digest(chats []*model.Chat, safeWord string) (prompt string, err *wf.CodedE
 var templateText string
 var promptTmpl = template.Must(template.New("prompt").Parse(templateText))
 
+//go:embed summary.tmpl
+var summaryText string
+var summaryTmpl = template.Must(template.New("summary").Parse(summaryText))
+
+// Summary returns the prompt to summarize chats, which follow what previous summarizes.
+// Tool calls are left out, as their results are mostly reflected in answers.
+func Summary(previous string, chats []*model.Chat) (prompt string, err *wf.CodedError) {
+	var messages []Message
+	for _, chat := range chats {
+		messages = append(messages, Message{Role: "user", Content: chat.Input})
+		if chat.Result != nil {
+			messages = append(messages, Message{Role: chat.Result.Role, Content: chat.Result.Content})
+		}
+	}
+
+	var sb strings.Builder
+	if err := summaryTmpl.Execute(&sb, SummaryParams{
+		Previous: previous,
+		Messages: messages,
+	}); err != nil {
+		return "", wf.NewCodedErrorf(http.StatusInternalServerError, "execute template: %v", err)
+	}
+	return sb.String(), nil
+}
+
+type SummaryParams struct {
+	Previous string
+	Messages []Message
+}
+
 type Message struct {
 	Role    string
 	Content string
@@ -0,0 +1,19 @@
+package service
+
+import (
+	"context"
+	"net/http"
+)
+
+type idempotencyKeyKey struct{}
+
+// withIdempotencyKey attaches the Idempotency-Key header of req to its context, see [withLastEventID] for why.
+func withIdempotencyKey(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), idempotencyKeyKey{}, req.Header.Get("Idempotency-Key")))
+}
+
+// idempotencyKey returns what the client identifies a request with, empty if none.
+func idempotencyKey(ctx context.Context) string {
+	ret, _ := ctx.Value(idempotencyKeyKey{}).(string)
+	return ret
+}
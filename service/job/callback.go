@@ -0,0 +1,74 @@
+package job
+
+import (
+	"aiagent/helpers/closer"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hyisen/wf"
+)
+
+// callback POSTs what's over to where clients ask, retrying until it's accepted.
+type callback struct {
+	client   *http.Client
+	attempts int           // including the first one
+	base     time.Duration // of the first retry, doubled by each later one
+}
+
+func newCallback() *callback {
+	return &callback{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		attempts: 5,
+		base:     time.Second,
+	}
+}
+
+// post sends body to url, a 2xx response accepts it.
+// Retries are done on network failures and responses telling to retry, until attempts are used up.
+func (c *callback) post(ctx context.Context, url string, body []byte) {
+	delay := c.base
+	for attempt := 1; ; attempt++ {
+		retry, err := c.postOnce(ctx, url, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == c.attempts {
+			slog.Error("callback given up", "url", url, "attempts", attempt, "err", err)
+			return
+		}
+		slog.Warn("callback failed, retry later", "url", url, "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+	}
+}
+
+func (c *callback) postOnce(ctx context.Context, url string, body []byte) (retry bool, _ error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", wf.JSONContentType)
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer closer.CloseAndWarnIfFail(rsp.Body)
+	if rsp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("bad status %v", rsp.Status)
+	switch rsp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true, err
+	default:
+		return rsp.StatusCode >= 500, err
+	}
+}
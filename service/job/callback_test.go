@@ -0,0 +1,59 @@
+package job
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCallback_post(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // of responses in order, the last one repeats
+		want     int   // attempts made
+	}{
+		{"accepted", []int{http.StatusNoContent}, 1},
+		{"retried until accepted", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, 3},
+		{"rejected", []int{http.StatusBadRequest}, 1},
+		{"given up", []int{http.StatusBadGateway}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[min(hits, len(tt.statuses)-1)])
+				hits++
+			}))
+			defer srv.Close()
+			c := &callback{client: srv.Client(), attempts: 3, base: time.Millisecond}
+			c.post(context.Background(), srv.URL, []byte("{}"))
+			if hits != tt.want {
+				t.Errorf("post() attempted %d times, want %d", hits, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{"", false},
+		{"https://example.com/hook", false},
+		{"http://localhost:8080/hook?job=1", false},
+		{"ftp://example.com/hook", true},
+		{"/hook", true},
+		{"https://", true},
+		{"::", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if err := validateCallbackURL(tt.raw); (err != nil) != tt.wantErr {
+				t.Errorf("validateCallbackURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package job
+
+import (
+	"aiagent/clients/chat"
+	"aiagent/clients/job"
+	"aiagent/clients/model"
+	"aiagent/clients/session"
+	sc "aiagent/service/chat"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hyisen/wf"
+	"gorm.io/gorm"
+)
+
+// jobTimeout limits one job, which is far beyond the timeout of chat APIs, as no client is waiting.
+const jobTimeout = 30 * time.Minute
+
+// queueSize limits jobs waiting for a worker in memory, beyond which a new one is rejected.
+const queueSize = 64
+
+// Chatter is what a job runs.
+type Chatter interface {
+	Validate(req *sc.RequestPayload) *wf.CodedError
+	ChatSaved(ctx context.Context, sessionID int, req *sc.RequestPayload) (*model.Chat, *wf.CodedError)
+}
+
+// Service runs chats as jobs in a pool of workers, independent of any HTTP request.
+type Service struct {
+	chats             Chatter
+	sessionRepository *session.Repository
+	chatRepository    *chat.Repository
+	jobRepository     *job.Repository
+	workers           int
+	queue             chan int // of job IDs
+	callback          *callback
+}
+
+func NewService(
+	chats Chatter,
+	sessionRepository *session.Repository,
+	chatRepository *chat.Repository,
+	jobRepository *job.Repository,
+	workers int,
+) *Service {
+	return &Service{
+		chats:             chats,
+		sessionRepository: sessionRepository,
+		chatRepository:    chatRepository,
+		jobRepository:     jobRepository,
+		workers:           workers,
+		queue:             make(chan int, queueSize),
+		callback:          newCallback(),
+	}
+}
+
+type Request struct {
+	UserID          int
+	SessionScopedID int
+	Payload
+}
+
+type Payload struct {
+	sc.RequestPayload
+	// CallbackURL is POSTed with the [View] once the job is over, empty for none.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// View is a job with the chat it has generated, if any.
+type View struct {
+	*model.Job
+	Chat *model.Chat // nil until it's over or if nothing is saved
+}
+
+// Start runs workers until ctx is done.
+// Jobs left queued by the last process are queued again, while running ones are failed,
+// as what's saved by half is unknown.
+func (s *Service) Start(ctx context.Context) error {
+	running, err := s.jobRepository.FindByState(ctx, model.JobStateRunning)
+	if err != nil {
+		return err
+	}
+	for _, item := range running {
+		s.fail(ctx, item, errors.New("interrupted by a restart"))
+	}
+	queued, err := s.jobRepository.FindByState(ctx, model.JobStateQueued)
+	if err != nil {
+		return err
+	}
+
+	for range s.workers {
+		go s.work(ctx)
+	}
+	go func() {
+		// Beyond the queue, so it waits for workers here rather than rejecting them.
+		for _, item := range queued {
+			select {
+			case s.queue <- item.ID:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	slog.Info("job workers started", "workers", s.workers, "queued", len(queued), "failed", len(running))
+	return nil
+}
+
+func (s *Service) work(ctx context.Context) {
+	for {
+		select {
+		case id := <-s.queue:
+			s.run(ctx, id)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Submit saves req as a queued job, which is run by a worker later.
+func (s *Service) Submit(ctx context.Context, req *Request) (*model.Job, *wf.CodedError) {
+	if e := s.chats.Validate(&req.RequestPayload); e != nil {
+		return nil, e
+	}
+	if err := validateCallbackURL(req.CallbackURL); err != nil {
+		return nil, wf.NewCodedError(http.StatusBadRequest, err)
+	}
+	sessionID, err := s.sessionRepository.FindIDByUserIDAndScopedID(ctx, req.UserID, req.SessionScopedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, wf.NewCodedErrorf(http.StatusNotFound, "no session %d-%d to chat", req.UserID, req.SessionScopedID)
+	}
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	payload, err := json.Marshal(req.RequestPayload)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+
+	now := time.Now().UnixMilli()
+	ret := &model.Job{
+		ID:          0,
+		UserID:      req.UserID,
+		SessionID:   sessionID,
+		ChatID:      nil,
+		State:       model.JobStateQueued,
+		Payload:     string(payload),
+		CallbackURL: req.CallbackURL,
+		Error:       "",
+		CreateTime:  now,
+		UpdateTime:  now,
+	}
+	if err := s.jobRepository.Save(ctx, ret); err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	select {
+	case s.queue <- ret.ID:
+		return ret, nil
+	default:
+		// Not told to its callback, as the client knows it by the response.
+		ret.State, ret.Error = model.JobStateFailed, "too many jobs queued"
+		s.save(ctx, ret)
+		return nil, wf.NewCodedErrorf(http.StatusServiceUnavailable, "too many jobs queued, retry later")
+	}
+}
+
+// Find returns the job on id with its chat.
+func (s *Service) Find(ctx context.Context, userID int, id int) (*View, *wf.CodedError) {
+	item, err := s.jobRepository.FindByUserIDAndID(ctx, userID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, wf.NewCodedErrorf(http.StatusNotFound, "no job %d of user %d", id, userID)
+	}
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	ret, err := s.view(ctx, item)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return ret, nil
+}
+
+func (s *Service) view(ctx context.Context, item *model.Job) (*View, error) {
+	ret := &View{Job: item, Chat: nil}
+	if item.ChatID == nil {
+		return ret, nil
+	}
+	var err error
+	ret.Chat, err = s.chatRepository.FindByID(ctx, *item.ChatID)
+	return ret, err
+}
+
+// run chats the job on id, whose state is saved on every change, then tells its callback.
+func (s *Service) run(ctx context.Context, id int) {
+	item, err := s.jobRepository.FindByID(ctx, id)
+	if err != nil {
+		slog.Error("job gone before it's run", "id", id, "err", err)
+		return
+	}
+	var req sc.RequestPayload
+	if err := json.Unmarshal([]byte(item.Payload), &req); err != nil {
+		s.fail(ctx, item, err)
+		return
+	}
+	item.State = model.JobStateRunning
+	if !s.save(ctx, item) {
+		return
+	}
+
+	chatCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+	neo, e := s.chats.ChatSaved(chatCtx, item.SessionID, &req)
+	if neo != nil {
+		item.ChatID = &neo.ID
+	}
+	if e != nil {
+		s.fail(ctx, item, e)
+		return
+	}
+	item.State = model.JobStateDone
+	if s.save(ctx, item) {
+		s.notify(ctx, item)
+	}
+}
+
+// fail saves item as failed by err, then tells its callback.
+func (s *Service) fail(ctx context.Context, item *model.Job, err error) {
+	slog.Warn("job failed", "id", item.ID, "err", err)
+	item.State = model.JobStateFailed
+	item.Error = err.Error()
+	if s.save(ctx, item) {
+		s.notify(ctx, item)
+	}
+}
+
+// save returns whether item is saved, which is logged if not.
+func (s *Service) save(ctx context.Context, item *model.Job) bool {
+	item.UpdateTime = time.Now().UnixMilli()
+	if err := s.jobRepository.Save(ctx, item); err != nil {
+		slog.Error("can not save job", "job", item, "err", err)
+		return false
+	}
+	return true
+}
+
+// notify POSTs the view of item to its callback, if any, in the background, as retries take a while.
+func (s *Service) notify(ctx context.Context, item *model.Job) {
+	if item.CallbackURL == "" {
+		return
+	}
+	view, err := s.view(ctx, item)
+	if err != nil {
+		slog.Error("can not view job to notify", "id", item.ID, "err", err)
+		return
+	}
+	body, err := json.Marshal(view)
+	if err != nil {
+		// Jobs and chats are always marshalled by the poll endpoint too.
+		panic(err)
+	}
+	go s.callback.post(ctx, item.CallbackURL, body)
+}
+
+func validateCallbackURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("callback_url must be an absolute http or https URL")
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+type queryKey struct{}
+
+// withQuery attaches the query parameters of req to its context, see [withLastEventID] for why.
+func withQuery(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), queryKey{}, req.URL.Query()))
+}
+
+// query returns the query parameters of the request, none if it's not attached.
+func query(ctx context.Context) url.Values {
+	ret, _ := ctx.Value(queryKey{}).(url.Values)
+	return ret
+}
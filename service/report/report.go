@@ -0,0 +1,195 @@
+package report
+
+import (
+	"aiagent/clients/report"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyisen/wf"
+)
+
+// CSVContentType is of what [MarshalCSV] returns.
+const CSVContentType = "text/csv; charset=utf-8"
+
+// Service reports what's used by chats.
+type Service struct {
+	reportRepository *report.Repository
+	now              func() time.Time
+}
+
+func NewService(reportRepository *report.Repository) *Service {
+	return &Service{reportRepository: reportRepository, now: time.Now}
+}
+
+// Request tells what to report, by query parameters of the endpoint.
+type Request struct {
+	UserID *int // nil for all users
+	// From and To are days in local time of the server, inclusive,
+	// which are the first day of the month and today by default.
+	From  string
+	To    string
+	Group []report.Dimension // empty to sum up all
+}
+
+type Report struct {
+	From  string
+	To    string
+	Group []report.Dimension
+	Rows  []*report.Row
+}
+
+// NewRequest parses query parameters from, to and group, the last of which is comma separated.
+func NewRequest(userID *int, values url.Values) (*Request, *wf.CodedError) {
+	ret := &Request{UserID: userID, From: values.Get("from"), To: values.Get("to"), Group: nil}
+	for name := range strings.SplitSeq(values.Get("group"), ",") {
+		if name == "" {
+			continue
+		}
+		dimension := report.Dimension(name)
+		if !slices.Contains(report.Dimensions, dimension) {
+			return nil, wf.NewCodedErrorf(http.StatusBadRequest, "bad group %q, want some of %v", name, report.Dimensions)
+		}
+		ret.Group = append(ret.Group, dimension)
+	}
+	return ret, nil
+}
+
+// Find sums up usages as req asks.
+func (s *Service) Find(ctx context.Context, req *Request) (*Report, *wf.CodedError) {
+	now := s.now()
+	y, m, _ := now.Date()
+	from, e := parseDay("from", req.From, time.Date(y, m, 1, 0, 0, 0, 0, now.Location()))
+	if e != nil {
+		return nil, e
+	}
+	to, e := parseDay("to", req.To, now)
+	if e != nil {
+		return nil, e
+	}
+	if to.Before(from) {
+		return nil, wf.NewCodedErrorf(
+			http.StatusBadRequest,
+			"to %s before from %s",
+			to.Format(time.DateOnly),
+			from.Format(time.DateOnly),
+		)
+	}
+
+	filter := &report.Filter{
+		UserID: req.UserID,
+		Since:  from.UnixMilli(),
+		Until:  to.AddDate(0, 0, 1).UnixMilli(),
+	}
+	rows, err := s.reportRepository.Sum(ctx, filter, req.Group)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return &Report{
+		From:  from.Format(time.DateOnly),
+		To:    to.Format(time.DateOnly),
+		Group: req.Group,
+		Rows:  rows,
+	}, nil
+}
+
+// parseDay returns the start of day in local time, or that of fallback if day is empty.
+func parseDay(name string, day string, fallback time.Time) (time.Time, *wf.CodedError) {
+	if day == "" {
+		y, m, d := fallback.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, fallback.Location()), nil
+	}
+	ret, err := time.ParseInLocation(time.DateOnly, day, time.Local)
+	if err != nil {
+		return time.Time{}, wf.NewCodedErrorf(http.StatusBadRequest, "bad %s %q, want YYYY-MM-DD", name, day)
+	}
+	return ret, nil
+}
+
+// MarshalCSV formats a [*Report] as CSV, with a header and a line for each row.
+// Only dimensions grouped by have columns, costs are in billionths of units as JSON.
+func MarshalCSV(output any) ([]byte, error) {
+	rep := output.(*Report)
+	var header []string
+	for _, dimension := range report.Dimensions {
+		if !slices.Contains(rep.Group, dimension) {
+			continue
+		}
+		switch dimension {
+		case report.DimensionSession:
+			if !slices.Contains(rep.Group, report.DimensionUser) {
+				header = append(header, "user_id")
+			}
+			header = append(header, "session_scoped_id")
+		case report.DimensionUser:
+			header = append(header, "user_id")
+		default:
+			header = append(header, string(dimension))
+		}
+	}
+	dimensions := len(header)
+	header = append(header,
+		"chats",
+		"prompt_tokens",
+		"completion_tokens",
+		"cached_tokens",
+		"reasoning_tokens",
+		"prompt_cache_hit_tokens",
+		"cache_hit_ratio",
+		"reasoning_share",
+		"cost_nano",
+		"unit",
+	)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, row := range rep.Rows {
+		var record []string
+		for _, column := range header[:dimensions] {
+			switch column {
+			case "day":
+				record = append(record, row.Day)
+			case "model":
+				record = append(record, row.Model)
+			case "user_id":
+				record = append(record, formatID(row.UserID))
+			case "session_scoped_id":
+				record = append(record, formatID(row.SessionScopedID))
+			}
+		}
+		record = append(record,
+			strconv.FormatInt(row.Chats, 10),
+			strconv.FormatInt(row.PromptTokens, 10),
+			strconv.FormatInt(row.CompletionTokens, 10),
+			strconv.FormatInt(row.CachedTokens, 10),
+			strconv.FormatInt(row.ReasoningTokens, 10),
+			strconv.FormatInt(row.PromptCacheHitTokens, 10),
+			strconv.FormatFloat(row.CacheHitRatio, 'f', 4, 64),
+			strconv.FormatFloat(row.ReasoningShare, 'f', 4, 64),
+			strconv.FormatInt(row.CostNano, 10),
+			row.Unit,
+		)
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func formatID(id *int) string {
+	if id == nil {
+		// Always selected by the repository when grouped by.
+		panic("no id of a dimension grouped by")
+	}
+	return strconv.Itoa(*id)
+}
@@ -0,0 +1,80 @@
+package report
+
+import (
+	"aiagent/clients/report"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []report.Dimension
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"group=day", []report.Dimension{report.DimensionDay}, false},
+		{"group=model,session,", []report.Dimension{report.DimensionModel, report.DimensionSession}, false},
+		{"group=day,week", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			got, e := NewRequest(nil, values)
+			if (e != nil) != tt.wantErr {
+				t.Fatalf("NewRequest() error = %v, wantErr %v", e, tt.wantErr)
+			}
+			if e == nil && !slices.Equal(got.Group, tt.want) {
+				t.Errorf("NewRequest() Group = %v, want %v", got.Group, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalCSV(t *testing.T) {
+	userID, scopedID := 1000, 3
+	row := &report.Row{
+		Day:                  "2026-10-17",
+		Model:                "deepseek-v4-pro",
+		UserID:               &userID,
+		SessionScopedID:      &scopedID,
+		Chats:                2,
+		PromptTokens:         100,
+		CompletionTokens:     50,
+		CachedTokens:         40,
+		ReasoningTokens:      10,
+		PromptCacheHitTokens: 40,
+		CacheHitRatio:        0.4,
+		ReasoningShare:       0.2,
+		CostNano:             12345,
+		Unit:                 "CNY",
+	}
+	const metrics = "chats,prompt_tokens,completion_tokens,cached_tokens,reasoning_tokens,prompt_cache_hit_tokens," +
+		"cache_hit_ratio,reasoning_share,cost_nano,unit\n"
+	const values = "2,100,50,40,10,40,0.4000,0.2000,12345,CNY\n"
+	tests := []struct {
+		name  string
+		group []report.Dimension
+		want  string
+	}{
+		{"none", nil, metrics + values},
+		{"model and day in order of columns", []report.Dimension{report.DimensionModel, report.DimensionDay},
+			"day,model," + metrics + "2026-10-17,deepseek-v4-pro," + values},
+		{"session with its user", []report.Dimension{report.DimensionSession},
+			"user_id,session_scoped_id," + metrics + "1000,3," + values},
+		{"session and user", []report.Dimension{report.DimensionSession, report.DimensionUser},
+			"user_id,session_scoped_id," + metrics + "1000,3," + values},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalCSV(&Report{Group: tt.group, Rows: []*report.Row{row}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MarshalCSV() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/hyisen/wf"
+)
+
+type lastEventIDKey struct{}
+
+// withLastEventID attaches the Last-Event-ID header of req to its context,
+// as [wf.ParseFunc] sees no header, just like what [wf.AttachToken] does to Token.
+func withLastEventID(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), lastEventIDKey{}, req.Header.Get("Last-Event-ID")))
+}
+
+// lastEventID returns what the client has received in a stream, 0 if it's new.
+func lastEventID(ctx context.Context) (int, *wf.CodedError) {
+	value, _ := ctx.Value(lastEventIDKey{}).(string)
+	if value == "" {
+		return 0, nil
+	}
+	ret, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, wf.NewCodedErrorf(http.StatusBadRequest, "bad Last-Event-ID %q", value)
+	}
+	return ret, nil
+}
+
+// numberedEvents are events whose IDs go on by one after the one on after.
+type numberedEvents struct {
+	after  int
+	events <-chan wf.MessageEvent
+}
+
+// resumableSSEHandler is [wf.ServerSentEventsHandler] with an id on every event, which wf does not write,
+// so that a client could resume by Last-Event-ID.
+type resumableSSEHandler struct {
+	wf.TimeoutConfig
+	match  wf.MatchFunc
+	parse  wf.ParseFunc
+	handle func(ctx context.Context, req any) (*numberedEvents, *wf.CodedError)
+}
+
+func (h *resumableSSEHandler) Match(req *http.Request) bool {
+	return h.match(req)
+}
+
+func (h *resumableSSEHandler) Parse(data []byte, path string) (any, error) {
+	return h.parse(data, path)
+}
+
+func (h *resumableSSEHandler) Handle(ctx context.Context, req any) (wf.HandleOutputType, *wf.CodedError) {
+	return h.handle(ctx, req)
+}
+
+func (h *resumableSSEHandler) Response(output wf.HandleOutputType, writer http.ResponseWriter) {
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	ne := output.(*numberedEvents)
+	rc := http.NewResponseController(writer)
+	id := ne.after
+	for me := range ne.events {
+		id++
+		_, _ = fmt.Fprintf(writer, "id: %d\n", id)
+		if me.TypeOptional != "" {
+			_, _ = fmt.Fprintf(writer, "event: %s\n", me.TypeOptional)
+		}
+		for _, line := range me.Lines {
+			_, _ = fmt.Fprintf(writer, "data: %s\n", line)
+		}
+		_, _ = fmt.Fprintln(writer)
+		if err := rc.Flush(); err != nil {
+			slog.Error("unexpected failure on flush", "err", err)
+			return
+		}
+	}
+}
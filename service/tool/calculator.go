@@ -0,0 +1,110 @@
+package tool
+
+import (
+	"aiagent/clients/openai"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+)
+
+// Calculator evaluates arithmetic expressions exactly, as LLM is bad at it.
+//
+// It borrows Go's parser and its arbitrary-precision constant arithmetic,
+// so the syntax is that of Go constant expressions, except that integer division is not truncated.
+type Calculator struct {
+}
+
+func (c Calculator) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name: "calculator",
+		Description: "Evaluate an arithmetic expression exactly. " +
+			"Supports numbers, parentheses, + - * / %, and bitwise & | ^ << >> on integers.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"expression":{"type":"string","description":"such as (1+2)*3/7"}` +
+			`},"required":["expression"]}`),
+	}
+}
+
+func (c Calculator) Call(_ context.Context, _ Scope, arguments string) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+	return Calculate(args.Expression)
+}
+
+func Calculate(expression string) (string, error) {
+	expr, err := parser.ParseExpr(expression)
+	if err != nil {
+		return "", err
+	}
+	v, err := evaluate(expr)
+	if err != nil {
+		return "", err
+	}
+	if v.Kind() == constant.Int {
+		return v.ExactString(), nil
+	}
+	f, _ := constant.Float64Val(v)
+	return fmt.Sprintf("%g", f), nil
+}
+
+var errNotArithmetic = errors.New("not an arithmetic expression")
+
+func evaluate(expr ast.Expr) (v constant.Value, err error) {
+	// constant.BinaryOp and its friends panic on invalid operands, such as a shift on float.
+	// Convert them back to error as the input is from upstream, not an invariant here.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("evaluate %v: %v", expr, r)
+		}
+	}()
+
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			return nil, fmt.Errorf("%w: literal %s", errNotArithmetic, e.Value)
+		}
+		return constant.MakeFromLiteral(e.Value, e.Kind, 0), nil
+	case *ast.ParenExpr:
+		return evaluate(e.X)
+	case *ast.UnaryExpr:
+		x, err := evaluate(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return constant.UnaryOp(e.Op, x, 0), nil
+	case *ast.BinaryExpr:
+		x, err := evaluate(e.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := evaluate(e.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.SHL, token.SHR:
+			s, ok := constant.Uint64Val(y)
+			if !ok || s > 4096 { // A big shift would eat up memory, while no sane arithmetic needs that.
+				return nil, fmt.Errorf("bad shift count %v", y)
+			}
+			return constant.Shift(x, e.Op, uint(s)), nil
+		case token.QUO, token.REM:
+			if constant.Sign(y) == 0 {
+				return nil, errors.New("division by zero")
+			}
+		default:
+		}
+		return constant.BinaryOp(x, e.Op, y), nil
+	default:
+		return nil, fmt.Errorf("%w: %T", errNotArithmetic, expr)
+	}
+}
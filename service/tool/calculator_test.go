@@ -0,0 +1,33 @@
+package tool
+
+import "testing"
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       string
+		wantErr    bool
+	}{
+		{"integer", "(1+2)*3", "9", false},
+		{"exact big", "1<<100", "1267650600228229401496703205376", false},
+		{"untruncated division", "1/4", "0.25", false},
+		{"float", "0.1+0.2", "0.3", false},
+		{"negative", "-3%2", "-1", false},
+		{"division by zero", "1/0", "", true},
+		{"shift on float", "1.5<<1", "", true},
+		{"not arithmetic", `"a"+"b"`, "", true},
+		{"call", "len(x)", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Calculate(tt.expression)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Calculate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Calculate() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
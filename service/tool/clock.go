@@ -0,0 +1,36 @@
+package tool
+
+import (
+	"aiagent/clients/openai"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Clock tells the current time, as LLM has no idea about now.
+type Clock struct {
+}
+
+func (c Clock) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name:        "clock",
+		Description: "Get the current date and time in RFC 3339.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"zone":{"type":"string","description":"IANA Time Zone name such as Asia/Shanghai, UTC if omitted"}` +
+			`}}`),
+	}
+}
+
+func (c Clock) Call(_ context.Context, _ Scope, arguments string) (string, error) {
+	var args struct {
+		Zone string `json:"zone"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+	loc, err := time.LoadLocation(args.Zone) // empty as UTC
+	if err != nil {
+		return "", err
+	}
+	return time.Now().In(loc).Format(time.RFC3339), nil
+}
@@ -0,0 +1,93 @@
+package tool
+
+import (
+	"aiagent/clients/openai"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Fetch gets a web page on hosts in an allow-list, so that upstream could not reach anything private.
+type Fetch struct {
+	allowHosts []string
+	client     *http.Client
+}
+
+func NewFetch(allowHosts []string) *Fetch {
+	return &Fetch{
+		allowHosts: allowHosts,
+		client: &http.Client{
+			// Redirection is checked too, otherwise an allowed host could redirect to a private one.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return errors.New("stopped after 5 redirects")
+				}
+				return checkHost(req.URL, allowHosts)
+			},
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// fetchLimit is how many bytes of body would be replied, which shall not explode the context.
+const fetchLimit = 64 * 1024
+
+func (f *Fetch) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name:        "fetch",
+		Description: fmt.Sprintf("HTTP GET a URL and return its status and body, truncated to %d bytes.", fetchLimit),
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"url":{"type":"string","description":"absolute http or https URL"}` +
+			`},"required":["url"]}`),
+	}
+}
+
+func checkHost(u *url.URL, allowHosts []string) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if !slices.Contains(allowHosts, u.Hostname()) {
+		return fmt.Errorf("host %q is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+func (f *Fetch) Call(ctx context.Context, _ Scope, arguments string) (reply string, err error) {
+	var args struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(args.URL)
+	if err != nil {
+		return "", err
+	}
+	if err := checkHost(u, f.allowHosts); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func(c io.Closer) {
+		err = errors.Join(err, c.Close())
+	}(resp.Body)
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, fetchLimit))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("status: %s\n\n%s", resp.Status, string(data)), nil
+}
@@ -0,0 +1,203 @@
+package tool
+
+import (
+	"aiagent/clients/mcp"
+	"aiagent/clients/openai"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// MCPClient limits [mcp.Client] to use.
+type MCPClient interface {
+	Name() string
+	ListTools(ctx context.Context) ([]mcp.Tool, error)
+	ListResources(ctx context.Context) ([]mcp.Resource, error)
+	ListPrompts(ctx context.Context) ([]mcp.Prompt, error)
+	CallTool(ctx context.Context, name string, arguments json.RawMessage) (*mcp.CallToolResult, error)
+	ReadResource(ctx context.Context, uri string) (*mcp.ReadResourceResult, error)
+	GetPrompt(ctx context.Context, name string, arguments map[string]string) (*mcp.GetPromptResult, error)
+}
+
+// NewMCPTools lists what the server provides and wraps them as tools.
+// Each of its tools becomes one, while resources and prompts, if any, become one reader tool for each kind.
+// Names are prefixed by the server name to avoid collision among servers.
+func NewMCPTools(ctx context.Context, client MCPClient) ([]Tool, error) {
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := client.ListResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prompts, err := client.ListPrompts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("MCP listed", "server", client.Name(), "tools", len(tools), "resources", len(resources), "prompts", len(prompts))
+
+	var ret []Tool
+	for _, t := range tools {
+		ret = append(ret, &mcpTool{client: client, tool: t})
+	}
+	if len(resources) > 0 {
+		ret = append(ret, &mcpResourceReader{client: client, resources: resources})
+	}
+	if len(prompts) > 0 {
+		ret = append(ret, &mcpPromptGetter{client: client, prompts: prompts})
+	}
+	return ret, nil
+}
+
+var invalidFunctionNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// mcpFunctionName follows the function name limit of upstream, which is ^[a-zA-Z0-9_-]{1,64}$.
+func mcpFunctionName(server string, name string) string {
+	ret := invalidFunctionNameChars.ReplaceAllString(server+"__"+name, "_")
+	return ret[:min(len(ret), 64)]
+}
+
+type mcpTool struct {
+	client MCPClient
+	tool   mcp.Tool
+}
+
+func (t *mcpTool) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name:        mcpFunctionName(t.client.Name(), t.tool.Name),
+		Description: t.tool.Description,
+		Parameters:  t.tool.InputSchema,
+	}
+}
+
+func (t *mcpTool) Call(ctx context.Context, _ Scope, arguments string) (string, error) {
+	if arguments == "" {
+		arguments = "{}" // some upstream sends nothing for a tool without parameters
+	}
+	result, err := t.client.CallTool(ctx, t.tool.Name, json.RawMessage(arguments))
+	if err != nil {
+		return "", err
+	}
+	reply := formatContents(result.Content)
+	if result.IsError {
+		return "", errors.New(reply)
+	}
+	return reply, nil
+}
+
+func formatContents(contents []mcp.Content) string {
+	var parts []string
+	for _, c := range contents {
+		parts = append(parts, formatContent(c))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// formatContent keeps texts, and describes others, as we have no multimodal upstream yet.
+func formatContent(c mcp.Content) string {
+	switch c.Type {
+	case "text":
+		return c.Text
+	case "resource":
+		if c.Resource == nil {
+			return "[resource]"
+		}
+		return formatResourceContents(*c.Resource)
+	case "resource_link":
+		return fmt.Sprintf("[resource_link %s %s]", c.URI, c.Name)
+	default:
+		return fmt.Sprintf("[%s %s, %d bytes in base64]", c.Type, c.MIMEType, len(c.Data))
+	}
+}
+
+func formatResourceContents(rc mcp.ResourceContents) string {
+	if rc.Blob != "" {
+		return fmt.Sprintf("[blob %s %s, %d bytes in base64]", rc.URI, rc.MIMEType, len(rc.Blob))
+	}
+	return rc.Text
+}
+
+type mcpResourceReader struct {
+	client    MCPClient
+	resources []mcp.Resource
+}
+
+func (r *mcpResourceReader) Definition() openai.FunctionDefinition {
+	var sb strings.Builder
+	sb.WriteString("Read a resource by URI. Available resources:")
+	for _, res := range r.resources {
+		_, _ = fmt.Fprintf(&sb, "\n- %s (%s) %s", res.URI, res.Name, res.Description)
+	}
+	return openai.FunctionDefinition{
+		Name:        mcpFunctionName(r.client.Name(), "read_resource"),
+		Description: sb.String(),
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"uri":{"type":"string"}},"required":["uri"]}`),
+	}
+}
+
+func (r *mcpResourceReader) Call(ctx context.Context, _ Scope, arguments string) (string, error) {
+	var args struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+	result, err := r.client.ReadResource(ctx, args.URI)
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, c := range result.Contents {
+		parts = append(parts, formatResourceContents(c))
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+type mcpPromptGetter struct {
+	client  MCPClient
+	prompts []mcp.Prompt
+}
+
+func (g *mcpPromptGetter) Definition() openai.FunctionDefinition {
+	var sb strings.Builder
+	sb.WriteString("Get a prompt template filled with arguments. Available prompts:")
+	for _, p := range g.prompts {
+		_, _ = fmt.Fprintf(&sb, "\n- %s: %s", p.Name, p.Description)
+		for _, a := range p.Arguments {
+			_, _ = fmt.Fprintf(&sb, "\n  - argument %s (required=%v) %s", a.Name, a.Required, a.Description)
+		}
+	}
+	return openai.FunctionDefinition{
+		Name:        mcpFunctionName(g.client.Name(), "get_prompt"),
+		Description: sb.String(),
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"name":{"type":"string"},` +
+			`"arguments":{"type":"object","additionalProperties":{"type":"string"}}` +
+			`},"required":["name"]}`),
+	}
+}
+
+func (g *mcpPromptGetter) Call(ctx context.Context, _ Scope, arguments string) (string, error) {
+	var args struct {
+		Name      string            `json:"name"`
+		Arguments map[string]string `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+	result, err := g.client.GetPrompt(ctx, args.Name, args.Arguments)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, m := range result.Messages {
+		_, _ = fmt.Fprintf(&sb, "%s: %s\n", m.Role, formatContent(m.Content))
+	}
+	return sb.String(), nil
+}
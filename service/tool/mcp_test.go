@@ -0,0 +1,50 @@
+package tool
+
+import (
+	"aiagent/clients/mcp"
+	"strings"
+	"testing"
+)
+
+func TestMCPFunctionName(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+		tool   string
+		want   string
+	}{
+		{"kept", "fs", "read_file", "fs__read_file"},
+		{"sanitized", "my.server", "get weather/now", "my_server__get_weather_now"},
+		{"non-ASCII sanitized", "fs", "读", "fs___"},
+		{"truncated", "fs", strings.Repeat("a", 70), "fs__" + strings.Repeat("a", 60)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mcpFunctionName(tt.server, tt.tool); got != tt.want {
+				t.Errorf("mcpFunctionName() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// namedClient is an [MCPClient] of which only the name is used.
+type namedClient struct {
+	MCPClient
+	name string
+}
+
+func (c namedClient) Name() string {
+	return c.name
+}
+
+func TestNewRegistry_mcpCollision(t *testing.T) {
+	client := namedClient{name: "fs"}
+	long := strings.Repeat("a", 60)
+	_, err := NewRegistry(
+		&mcpTool{client: client, tool: mcp.Tool{Name: long + "_one"}},
+		&mcpTool{client: client, tool: mcp.Tool{Name: long + "_two"}},
+	)
+	if err == nil {
+		t.Error("NewRegistry() of names colliding once truncated want error got nil")
+	}
+}
@@ -0,0 +1,209 @@
+package tool
+
+import (
+	"aiagent/clients/model"
+	"aiagent/clients/openai"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// SessionsFinder limits [session.Repository] to use.
+type SessionsFinder interface {
+	FindWithChatsByUserID(ctx context.Context, userID int) ([]*model.Session, error)
+}
+
+// Sessions runs read-only SQL over sessions of the user in [Scope].
+//
+// Rather than fencing the SQL on our DB, which is hard to get right,
+// it copies what the user owns into a private in-memory DB and runs there.
+// It's costly on a heavy user, but safe by construction.
+type Sessions struct {
+	finder SessionsFinder
+}
+
+func NewSessions(finder SessionsFinder) *Sessions {
+	return &Sessions{finder: finder}
+}
+
+const sessionsSchema = `
+CREATE TABLE sessions
+(
+    scoped_id INTEGER PRIMARY KEY,
+    name      TEXT NOT NULL
+);
+CREATE TABLE chats
+(
+    id                INTEGER PRIMARY KEY,
+    session_scoped_id INTEGER NOT NULL,
+    input             TEXT    NOT NULL,
+    create_time       INTEGER NOT NULL -- epoch millisecond
+);
+CREATE TABLE results
+(
+    chat_id           INTEGER PRIMARY KEY,
+    model             TEXT    NOT NULL,
+    finish_reason     TEXT    NOT NULL,
+    content           TEXT    NOT NULL,
+    prompt_tokens     INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL,
+    status            TEXT    NOT NULL -- complete, length, interrupted, upstream_error or cancelled
+);`
+
+// Limits of reply, which shall not explode the context.
+const (
+	sessionsRowLimit  = 50
+	sessionsCellLimit = 2000
+)
+
+func (s *Sessions) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name: "sessions",
+		Description: "Run a read-only SQLite SELECT over the user's own chat history and get rows in TSV, " +
+			fmt.Sprintf("at most %d rows and %d chars per cell. Schema:\n", sessionsRowLimit, sessionsCellLimit) +
+			sessionsSchema,
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"query":{"type":"string","description":"a SELECT statement"}` +
+			`},"required":["query"]}`),
+	}
+}
+
+func (s *Sessions) Call(ctx context.Context, scope Scope, arguments string) (reply string, err error) {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+
+	sessions, err := s.finder.FindWithChatsByUserID(ctx, scope.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	if err != nil {
+		return "", err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return "", err
+	}
+	defer func(c *sql.DB) {
+		err = errors.Join(err, c.Close())
+	}(sqlDB)
+	// Every connection has its own in-memory DB, keep one to make sure we query what we have filled.
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := fill(db.WithContext(ctx), sessions); err != nil {
+		return "", err
+	}
+	if err := db.WithContext(ctx).Exec("PRAGMA query_only = ON").Error; err != nil {
+		return "", err
+	}
+
+	rows, err := db.WithContext(ctx).Raw(args.Query).Rows()
+	if err != nil {
+		return "", err
+	}
+	defer func(c *sql.Rows) {
+		err = errors.Join(err, c.Close())
+	}(rows)
+	return formatRows(rows)
+}
+
+func fill(db *gorm.DB, sessions []*model.Session) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(sessionsSchema).Error; err != nil {
+			return err
+		}
+		for _, ses := range sessions {
+			if err := tx.Exec("INSERT INTO sessions VALUES (?, ?)", ses.ScopedID, ses.Name).Error; err != nil {
+				return err
+			}
+			for _, c := range ses.Path() {
+				if err := tx.Exec(
+					"INSERT INTO chats VALUES (?, ?, ?, ?)",
+					c.ID, ses.ScopedID, c.Input, c.CreateTime,
+				).Error; err != nil {
+					return err
+				}
+				if c.Result == nil {
+					continue
+				}
+				if err := tx.Exec(
+					"INSERT INTO results VALUES (?, ?, ?, ?, ?, ?, ?)",
+					c.ID,
+					c.Result.Model,
+					c.Result.FinishReason,
+					c.Result.Content,
+					c.Result.PromptTokens,
+					c.Result.CompletionTokens,
+					c.Result.Status,
+				).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func formatRows(rows *sql.Rows) (string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.Join(columns, "\t"))
+	sb.WriteString("\n")
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	var count int
+	for rows.Next() {
+		count++
+		if count > sessionsRowLimit {
+			sb.WriteString("...(truncated)\n")
+			break
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return "", err
+		}
+		for i, v := range values {
+			if i > 0 {
+				sb.WriteString("\t")
+			}
+			sb.WriteString(formatCell(v))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), rows.Err()
+}
+
+func formatCell(v any) string {
+	var s string
+	switch x := v.(type) {
+	case nil:
+		s = "NULL"
+	case []byte:
+		s = string(x)
+	default:
+		s = fmt.Sprint(x)
+	}
+	// TSV can't hold them, escape them like in Go string literal.
+	s = strings.NewReplacer("\\", `\\`, "\t", `\t`, "\n", `\n`).Replace(s)
+	if len(s) > sessionsCellLimit {
+		s = strings.ToValidUTF8(s[:sessionsCellLimit], "") + "..."
+	}
+	return s
+}
@@ -0,0 +1,85 @@
+// Package tool provides Go implemented tools that upstream could call in a chat.
+package tool
+
+import (
+	"aiagent/clients/openai"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// Tool is a function that upstream could call.
+// Errors returned by Call are reported back to upstream as the reply, so that it could correct itself.
+type Tool interface {
+	Definition() openai.FunctionDefinition
+	Call(ctx context.Context, scope Scope, arguments string) (reply string, err error)
+}
+
+// Scope is where a tool is called, tools with privilege shall limit themselves within it.
+type Scope struct {
+	UserID    int
+	SessionID int
+}
+
+// Registry holds tools by name. The zero value is an empty one.
+type Registry struct {
+	tools map[string]Tool
+	names []string // in register order, to keep the offered tools stable, which is friendly to prompt cache
+}
+
+func NewRegistry(tools ...Tool) (*Registry, error) {
+	ret := &Registry{}
+	for _, t := range tools {
+		if err := ret.Register(t); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (r *Registry) Register(t Tool) error {
+	name := t.Definition().Name
+	if _, ok := r.tools[name]; ok {
+		return fmt.Errorf("duplicated tool name %s", name)
+	}
+	if r.tools == nil {
+		r.tools = make(map[string]Tool)
+	}
+	r.tools[name] = t
+	r.names = append(r.names, name)
+	return nil
+}
+
+func (r *Registry) Empty() bool {
+	return len(r.names) == 0
+}
+
+func (r *Registry) Names() []string {
+	return slices.Clone(r.names)
+}
+
+// Tools returns schemas of all registered tools to be offered in [openai.Request].
+func (r *Registry) Tools() []openai.Tool {
+	var ret []openai.Tool
+	for _, name := range r.names {
+		d := r.tools[name].Definition()
+		ret = append(ret, openai.NewFunctionTool(d.Name, d.Description, d.Parameters))
+	}
+	return ret
+}
+
+// Call executes call and returns the reply message to be sent back.
+// It never fails, as any failure is also a reply that upstream shall know.
+func (r *Registry) Call(ctx context.Context, scope Scope, call openai.ToolCall) openai.Message {
+	t, ok := r.tools[call.Function.Name]
+	if !ok {
+		return openai.NewToolMessage(call.ID, fmt.Sprintf("error: no tool named %q", call.Function.Name))
+	}
+	reply, err := t.Call(ctx, scope, call.Function.Arguments)
+	if err != nil {
+		slog.Warn("tool call failed", "name", call.Function.Name, "arguments", call.Function.Arguments, "err", err)
+		return openai.NewToolMessage(call.ID, "error: "+err.Error())
+	}
+	return openai.NewToolMessage(call.ID, reply)
+}
@@ -2,10 +2,14 @@ package service
 
 import (
 	"aiagent/clients/model"
+	"aiagent/clients/preset"
 	"aiagent/clients/session"
 	"context"
 	"errors"
 	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/hyisen/wf"
 	"gorm.io/gorm"
@@ -15,15 +19,41 @@ import (
 // It's designed for normal users. Newer features may come to V2 first.
 type V2Service struct {
 	sessionRepository *session.Repository
+	presetRepository  *preset.Repository
 }
 
-func NewV2Service(sessionRepository *session.Repository) *V2Service {
-	return &V2Service{sessionRepository: sessionRepository}
+func NewV2Service(sessionRepository *session.Repository, presetRepository *preset.Repository) *V2Service {
+	return &V2Service{sessionRepository: sessionRepository, presetRepository: presetRepository}
 }
 
-func (s *V2Service) CreateSessionByUserID(ctx context.Context, userID int) (created *model.Session, _ *wf.CodedError) {
+// CreateSessionRequest is optional, at most one of its fields could be set.
+type CreateSessionRequest struct {
+	Preset       string // name of one in the user's presets
+	SystemPrompt string
+}
+
+func (s *V2Service) CreateSessionByUserID(
+	ctx context.Context,
+	userID int,
+	req *CreateSessionRequest,
+) (created *model.Session, _ *wf.CodedError) {
+	systemPrompt := req.SystemPrompt
+	if req.Preset != "" {
+		if systemPrompt != "" {
+			return nil, wf.NewCodedErrorf(http.StatusBadRequest, "both preset and system prompt are set")
+		}
+		p, err := s.presetRepository.FindByUserIDAndName(ctx, userID, req.Preset)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, wf.NewCodedErrorf(http.StatusNotFound, "no preset %q of user %d", req.Preset, userID)
+		}
+		if err != nil {
+			return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+		}
+		systemPrompt = p.SystemPrompt
+	}
+
 	name := model.DefaultSessionName()
-	if err := s.sessionRepository.Create(ctx, userID, name); err != nil {
+	if err := s.sessionRepository.Create(ctx, userID, name, systemPrompt); err != nil {
 		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
 	}
 	ret, err := s.sessionRepository.FindLastByUserIDAndName(ctx, userID, name)
@@ -54,3 +84,186 @@ func (s *V2Service) FindSession(ctx context.Context, userID int, scopedID int) (
 	}
 	return ret, nil
 }
+
+func (s *V2Service) UpdateSessionSystemPrompt(
+	ctx context.Context,
+	userID int,
+	scopedID int,
+	systemPrompt string,
+) *wf.CodedError {
+	err := s.sessionRepository.UpdateSystemPrompt(ctx, userID, scopedID, systemPrompt)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return wf.NewCodedErrorf(http.StatusNotFound, "no session at %v-%v", userID, scopedID)
+	}
+	if err != nil {
+		return wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
+// SwitchActiveChat makes the chat on chatID active, which the next chat follows.
+// Switching to a chat with followers forks a new branch from it.
+func (s *V2Service) SwitchActiveChat(ctx context.Context, userID int, scopedID int, chatID int) *wf.CodedError {
+	ses, e := s.FindSession(ctx, userID, scopedID)
+	if e != nil {
+		return e
+	}
+	if ses.FindChat(chatID) == nil {
+		return wf.NewCodedErrorf(http.StatusNotFound, "no chat %d in session %v-%v", chatID, userID, scopedID)
+	}
+	if err := s.sessionRepository.UpdateActiveChatID(ctx, ses.ID, chatID); err != nil {
+		return wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
+// ForkSession creates a session with copies of chats on the path to the chat on chatID, which the fork continues.
+func (s *V2Service) ForkSession(
+	ctx context.Context,
+	userID int,
+	scopedID int,
+	chatID int,
+) (created *model.Session, _ *wf.CodedError) {
+	origin, e := s.FindSession(ctx, userID, scopedID)
+	if e != nil {
+		return nil, e
+	}
+	if origin.FindChat(chatID) == nil {
+		return nil, wf.NewCodedErrorf(http.StatusNotFound, "no chat %d in session %v-%v", chatID, userID, scopedID)
+	}
+	id, err := s.sessionRepository.Fork(ctx, origin, chatID, model.DefaultSessionName())
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	ret, err := s.sessionRepository.FindWithChats(ctx, id)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return ret, nil
+}
+
+// Branch is a path in the tree of chats of a session, from the first turn to its leaf.
+type Branch struct {
+	LeafID     int
+	Turns      int
+	Active     bool   // whether the active chat is on it
+	CreateTime int64  // of the leaf
+	Input      string // of the leaf
+}
+
+func (s *V2Service) FindBranches(ctx context.Context, userID int, scopedID int) ([]*Branch, *wf.CodedError) {
+	ses, e := s.FindSession(ctx, userID, scopedID)
+	if e != nil {
+		return nil, e
+	}
+	var ret []*Branch
+	for _, leaf := range ses.Leaves() {
+		path := ses.PathTo(leaf.ID)
+		ret = append(ret, &Branch{
+			LeafID: leaf.ID,
+			Turns:  len(path),
+			Active: ses.ActiveChatID != nil && slices.ContainsFunc(path, func(c *model.Chat) bool {
+				return c.ID == *ses.ActiveChatID
+			}),
+			CreateTime: leaf.CreateTime,
+			Input:      leaf.Input,
+		})
+	}
+	return ret, nil
+}
+
+// SessionMatch is a session of chats matching a search, with snippets of them, the most relevant first.
+type SessionMatch struct {
+	ScopedID int
+	Name     string
+	Hits     []*Hit // at most searchHitsPerSession
+}
+
+type Hit struct {
+	ChatID  int
+	Source  string // input of the chat, or content of its result
+	Snippet string // around what matches, which is marked by ** on both sides
+}
+
+const (
+	searchSessions       = 20
+	searchHitsPerSession = 3
+	searchMinTermLength  = 3 // as texts are indexed by trigrams
+)
+
+// Search finds sessions of the user with chats matching all space separated terms in text, only those of the user.
+func (s *V2Service) Search(ctx context.Context, userID int, text string) ([]*SessionMatch, *wf.CodedError) {
+	terms := strings.Fields(text)
+	if len(terms) == 0 {
+		return nil, wf.NewCodedErrorf(http.StatusBadRequest, "nothing to search, want q")
+	}
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < searchMinTermLength {
+			return nil, wf.NewCodedErrorf(http.StatusBadRequest,
+				"term %q to search shorter than %d characters", term, searchMinTermLength)
+		}
+	}
+	// Enough for sessions to fill, unless a few sessions hit much more than others.
+	hits, err := s.sessionRepository.Search(ctx, userID, terms, searchSessions*searchHitsPerSession*2)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	ret := make([]*SessionMatch, 0)
+	matches := make(map[int]*SessionMatch)
+	for _, hit := range hits {
+		m, ok := matches[hit.SessionScopedID]
+		if !ok {
+			if len(ret) == searchSessions {
+				continue
+			}
+			m = &SessionMatch{ScopedID: hit.SessionScopedID, Name: hit.SessionName, Hits: nil}
+			matches[hit.SessionScopedID] = m
+			ret = append(ret, m)
+		}
+		if len(m.Hits) < searchHitsPerSession {
+			m.Hits = append(m.Hits, &Hit{ChatID: hit.ChatID, Source: hit.Source, Snippet: hit.Snippet})
+		}
+	}
+	return ret, nil
+}
+
+func (s *V2Service) FindPresets(ctx context.Context, userID int) ([]*model.Preset, *wf.CodedError) {
+	ret, err := s.presetRepository.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return ret, nil
+}
+
+// SavePreset creates one, or replaces the system prompt of that with the same name.
+func (s *V2Service) SavePreset(ctx context.Context, userID int, item *model.Preset) (*model.Preset, *wf.CodedError) {
+	name := strings.TrimSpace(item.Name)
+	if name == "" {
+		return nil, wf.NewCodedErrorf(http.StatusBadRequest, "empty preset name")
+	}
+	if err := s.presetRepository.Save(ctx, &model.Preset{
+		ID:           0,
+		UserID:       userID,
+		Name:         name,
+		SystemPrompt: item.SystemPrompt,
+	}); err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	// Read back, as ID is not filled on conflict.
+	ret, err := s.presetRepository.FindByUserIDAndName(ctx, userID, name)
+	if err != nil {
+		return nil, wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return ret, nil
+}
+
+func (s *V2Service) DeletePreset(ctx context.Context, userID int, id int) *wf.CodedError {
+	err := s.presetRepository.DeleteByUserIDAndID(ctx, userID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return wf.NewCodedErrorf(http.StatusNotFound, "no preset %d of user %d", id, userID)
+	}
+	if err != nil {
+		return wf.NewCodedError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
@@ -1,11 +1,22 @@
 package service
 
 import (
+	"aiagent/clients/budget"
 	"aiagent/clients/chat"
-	"aiagent/clients/openai"
+	"aiagent/clients/compaction"
+	"aiagent/clients/job"
+	"aiagent/clients/model"
+	"aiagent/clients/preset"
+	"aiagent/clients/provider"
+	"aiagent/clients/report"
 	"aiagent/clients/session"
+	"aiagent/helpers/pricer"
+	sb "aiagent/service/budget"
 	sc "aiagent/service/chat"
 	"aiagent/service/digest"
+	sj "aiagent/service/job"
+	sr "aiagent/service/report"
+	"aiagent/service/tool"
 	"context"
 	"encoding/json"
 	"log/slog"
@@ -22,12 +33,20 @@ type Service struct {
 	v2            *V2Service
 	chatService   *sc.Service
 	digestService *digest.Service
+	jobService    *sj.Service
+	budgetService *sb.Service
+	reportService *sr.Service
 	buildInfo     *debug.BuildInfo
 	web           *wf.Web
 }
 
 func (s *Service) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	s.web.ServeHTTP(writer, request)
+	s.web.ServeHTTP(writer, withQuery(withIdempotencyKey(withLastEventID(request))))
+}
+
+// Start runs what goes on in the background, such as job workers, until ctx is done.
+func (s *Service) Start(ctx context.Context) error {
+	return s.jobService.Start(ctx)
 }
 
 // chatTimeout provides a timeout that shall be used over chat APIs,
@@ -38,17 +57,41 @@ func chatTimeout() time.Duration {
 }
 
 func New(
-	client *openai.Client,
+	providers *provider.Registry,
+	digestRoute *provider.Route,
+	tools *tool.Registry,
 	sessionRepository *session.Repository,
 	chatRepository *chat.Repository,
+	presetRepository *preset.Repository,
+	compactionRepository *compaction.Repository,
+	jobRepository *job.Repository,
+	jobWorkers int,
+	budgetRepository *budget.Repository,
+	reportRepository *report.Repository,
+	prices *pricer.Table,
 	buildInfo *debug.BuildInfo,
 ) *Service {
+	digestService := digest.NewService(digestRoute, sessionRepository, prices)
+	budgetService := sb.NewService(budgetRepository)
+	chatService := sc.NewService(
+		providers,
+		tools,
+		chatRepository,
+		sessionRepository,
+		compactionRepository,
+		digestService,
+		budgetService,
+		prices,
+	)
 	ret := &Service{
 		web:           nil,
 		v1:            NewV1Service(sessionRepository),
-		v2:            NewV2Service(sessionRepository),
-		chatService:   sc.NewService(client, chatRepository, sessionRepository),
-		digestService: digest.NewService(client, sessionRepository),
+		v2:            NewV2Service(sessionRepository, presetRepository),
+		chatService:   chatService,
+		digestService: digestService,
+		jobService:    sj.NewService(chatService, sessionRepository, chatRepository, jobRepository, jobWorkers),
+		budgetService: budgetService,
+		reportService: sr.NewService(reportRepository),
 		buildInfo:     buildInfo,
 	}
 
@@ -137,15 +180,207 @@ func New(
 		http.MethodPost,
 		[]string{"v2", "users", "", "sessions"},
 	)
+	type UserIDAndCreateSession struct {
+		UserID int
+		*CreateSessionRequest
+	}
 	v2PostSession := wf.NewClosureHandler(
 		v2PostSessionMatcher,
-		v2PostSessionParser,
+		func(data []byte, path string) (req any, err error) {
+			ids, err := v2PostSessionParser(nil, path)
+			if err != nil {
+				return nil, err
+			}
+			request := &CreateSessionRequest{}
+			// The body is optional, for compatibility with clients creating without it.
+			if len(data) > 0 {
+				if err := json.Unmarshal(data, request); err != nil {
+					return nil, err
+				}
+			}
+			return UserIDAndCreateSession{UserID: ids.([]int)[0], CreateSessionRequest: request}, nil
+		},
 		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
-			return ret.v2.CreateSessionByUserID(ctx, req.([]int)[0])
+			r := req.(UserIDAndCreateSession)
+			return ret.v2.CreateSessionByUserID(ctx, r.UserID, r.CreateSessionRequest)
 		}, json.Marshal,
 		wf.JSONContentType,
 	)
 
+	v2PutSessionSystemPromptMatcher, v2PutSessionSystemPromptParser := wf.ResourceWithIDs(
+		http.MethodPut,
+		[]string{"v2", "users", "", "sessions", "", "system-prompt"},
+	)
+	type IDsAndText struct {
+		IDs  []int
+		Text string
+	}
+	v2PutSessionSystemPrompt := wf.NewClosureHandler(
+		v2PutSessionSystemPromptMatcher,
+		func(data []byte, path string) (req any, err error) {
+			ids, err := v2PutSessionSystemPromptParser(nil, path)
+			if err != nil {
+				return nil, err
+			}
+			return IDsAndText{IDs: ids.([]int), Text: string(data)}, nil
+		},
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			r := req.(IDsAndText)
+			return nil, ret.v2.UpdateSessionSystemPrompt(ctx, r.IDs[0], r.IDs[1], r.Text)
+		},
+		wf.FormatEmpty,
+		http.DetectContentType(nil),
+	)
+
+	v2PutSessionActiveChatMatcher, v2PutSessionActiveChatParser := wf.ResourceWithIDs(
+		http.MethodPut,
+		[]string{"v2", "users", "", "sessions", "", "chats", "", "active"},
+	)
+	v2PutSessionActiveChat := wf.NewClosureHandler(
+		v2PutSessionActiveChatMatcher,
+		v2PutSessionActiveChatParser,
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			ids := req.([]int)
+			return nil, ret.v2.SwitchActiveChat(ctx, ids[0], ids[1], ids[2])
+		},
+		wf.FormatEmpty,
+		http.DetectContentType(nil),
+	)
+
+	v2PostSessionForkMatcher, v2PostSessionForkParser := wf.ResourceWithIDs(
+		http.MethodPost,
+		[]string{"v2", "users", "", "sessions", "", "chats", "", "fork"},
+	)
+	v2PostSessionFork := wf.NewClosureHandler(
+		v2PostSessionForkMatcher,
+		v2PostSessionForkParser,
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			ids := req.([]int)
+			return ret.v2.ForkSession(ctx, ids[0], ids[1], ids[2])
+		},
+		json.Marshal,
+		wf.JSONContentType,
+	)
+
+	v2GetSessionChatStreamMatcher, v2GetSessionChatStreamParser := wf.ResourceWithIDs(
+		http.MethodGet,
+		[]string{"v2", "users", "", "sessions", "", "chats", "", "stream"},
+	)
+	v2GetSessionChatStream := &resumableSSEHandler{
+		TimeoutConfig: wf.TimeoutConfig{Timeout: chatTimeout()},
+		match:         v2GetSessionChatStreamMatcher,
+		parse:         v2GetSessionChatStreamParser,
+		handle: func(ctx context.Context, req any) (*numberedEvents, *wf.CodedError) {
+			after, e := lastEventID(ctx)
+			if e != nil {
+				return nil, e
+			}
+			ids := req.([]int)
+			ch, e := ret.chatService.Resume(ctx, ids[0], ids[1], ids[2], after)
+			if e != nil {
+				return nil, e
+			}
+			return &numberedEvents{after: after, events: ch}, nil
+		},
+	}
+
+	v2PostSessionChatCancelMatcher, v2PostSessionChatCancelParser := wf.ResourceWithIDs(
+		http.MethodPost,
+		[]string{"v2", "users", "", "sessions", "", "chats", "", "cancel"},
+	)
+	v2PostSessionChatCancel := wf.NewClosureHandler(
+		v2PostSessionChatCancelMatcher,
+		v2PostSessionChatCancelParser,
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			ids := req.([]int)
+			return nil, ret.chatService.Cancel(ctx, ids[0], ids[1], ids[2])
+		},
+		wf.FormatEmpty,
+		http.DetectContentType(nil),
+	)
+
+	v2GetSessionBranchesMatcher, v2GetSessionBranchesParser := wf.ResourceWithIDs(
+		http.MethodGet,
+		[]string{"v2", "users", "", "sessions", "", "branches"},
+	)
+	v2GetSessionBranches := wf.NewClosureHandler(
+		v2GetSessionBranchesMatcher,
+		v2GetSessionBranchesParser,
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			ids := req.([]int)
+			return ret.v2.FindBranches(ctx, ids[0], ids[1])
+		},
+		json.Marshal,
+		wf.JSONContentType,
+	)
+
+	v2GetSearchPathSuffix := "/search"
+	v2GetSearch := wf.NewClosureHandler(
+		wf.ResourceWithID(http.MethodGet, "/v2/users/", v2GetSearchPathSuffix),
+		wf.PathIDParser(v2GetSearchPathSuffix),
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			return ret.v2.Search(ctx, req.(int), query(ctx).Get("q"))
+		},
+		json.Marshal,
+		wf.JSONContentType,
+	)
+
+	v2GetPresetsMatcher, v2GetPresetsParser := wf.ResourceWithIDs(
+		http.MethodGet,
+		[]string{"v2", "users", "", "presets"},
+	)
+	v2GetPresets := wf.NewClosureHandler(
+		v2GetPresetsMatcher,
+		v2GetPresetsParser,
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			return ret.v2.FindPresets(ctx, req.([]int)[0])
+		},
+		json.Marshal,
+		wf.JSONContentType,
+	)
+	v2PutPresetMatcher, v2PutPresetPathParser := wf.ResourceWithIDs(
+		http.MethodPut,
+		[]string{"v2", "users", "", "presets"},
+	)
+	type UserIDAndPreset struct {
+		UserID int
+		*model.Preset
+	}
+	v2PutPreset := wf.NewClosureHandler(
+		v2PutPresetMatcher,
+		func(data []byte, path string) (req any, err error) {
+			ids, err := v2PutPresetPathParser(nil, path)
+			if err != nil {
+				return nil, err
+			}
+			item := &model.Preset{}
+			if err := json.Unmarshal(data, item); err != nil {
+				return nil, err
+			}
+			return UserIDAndPreset{UserID: ids.([]int)[0], Preset: item}, nil
+		},
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			r := req.(UserIDAndPreset)
+			return ret.v2.SavePreset(ctx, r.UserID, r.Preset)
+		},
+		json.Marshal,
+		wf.JSONContentType,
+	)
+	v2DeletePresetMatcher, v2DeletePresetParser := wf.ResourceWithIDs(
+		http.MethodDelete,
+		[]string{"v2", "users", "", "presets", ""},
+	)
+	v2DeletePreset := wf.NewClosureHandler(
+		v2DeletePresetMatcher,
+		v2DeletePresetParser,
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			ids := req.([]int)
+			return nil, ret.v2.DeletePreset(ctx, ids[0], ids[1])
+		},
+		wf.FormatEmpty,
+		http.DetectContentType(nil),
+	)
+
 	v2GetSessionMatcher, v2GetSessionParser := wf.ResourceWithIDs(
 		http.MethodGet,
 		[]string{"v2", "users", "", "sessions", ""},
@@ -162,49 +397,145 @@ func New(
 		wf.JSONContentType,
 	)
 
-	v2PostSessionChatPathMatcher, v2PostSessionChatPathParser := wf.ResourceWithIDs(
-		http.MethodPost,
-		[]string{"v2", "users", "", "sessions", "", "chat"},
-	)
-	v2PostSessionChatParser := func(data []byte, path string) (req any, err error) {
-		raw, err := v2PostSessionChatPathParser(nil, path)
-		if err != nil {
-			return nil, err
-		}
-		ids := raw.([]int)
-		request := &sc.Request{
-			UserID:          ids[0],
-			SessionScopedID: ids[1],
-			RequestPayload:  sc.RequestPayload{},
+	// v2PostSessionChatHandlers returns handlers of a chat on path parts, in plain and stream mode.
+	v2PostSessionChatHandlers := func(revision sc.Revision, parts ...string) (plain wf.Handler, stream wf.Handler) {
+		v2PostSessionChatPathMatcher, v2PostSessionChatPathParser := wf.ResourceWithIDs(
+			http.MethodPost,
+			append([]string{"v2", "users", "", "sessions", ""}, parts...),
+		)
+		v2PostSessionChatParser := func(data []byte, path string) (req any, err error) {
+			raw, err := v2PostSessionChatPathParser(nil, path)
+			if err != nil {
+				return nil, err
+			}
+			ids := raw.([]int)
+			request := &sc.Request{
+				UserID:          ids[0],
+				SessionScopedID: ids[1],
+				RequestPayload:  sc.RequestPayload{},
+			}
+			if err := json.Unmarshal(data, &request.RequestPayload); err != nil {
+				return nil, err
+			}
+			request.Revision = revision
+			return request, nil
 		}
-		if err := json.Unmarshal(data, &request.RequestPayload); err != nil {
-			return nil, err
+		v2PostSessionChat := wf.NewClosureHandler(
+			func(req *http.Request) bool {
+				if !v2PostSessionChatPathMatcher(req) {
+					return false
+				}
+				return req.URL.Query().Get("stream") != "true"
+			},
+			v2PostSessionChatParser,
+			func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+				r := req.(*sc.Request)
+				r.IdempotencyKey = idempotencyKey(ctx)
+				return ret.chatService.Chat(ctx, r)
+			},
+			json.Marshal,
+			wf.JSONContentType,
+		)
+		v2PostSessionChat.Timeout = chatTimeout()
+		v2PostSessionChatStream := &resumableSSEHandler{
+			TimeoutConfig: wf.TimeoutConfig{Timeout: chatTimeout()},
+			match:         wf.MatchAll(v2PostSessionChatPathMatcher, wf.HasQuery("stream", "true")),
+			parse:         v2PostSessionChatParser,
+			handle: func(ctx context.Context, req any) (*numberedEvents, *wf.CodedError) {
+				r := req.(*sc.Request)
+				r.IdempotencyKey = idempotencyKey(ctx)
+				ch, e := ret.chatService.ChatStream(ctx, r)
+				if e != nil {
+					return nil, e
+				}
+				return &numberedEvents{after: 0, events: ch}, nil
+			},
 		}
-		return request, nil
+		return v2PostSessionChat, v2PostSessionChatStream
 	}
-	v2PostSessionChat := wf.NewClosureHandler(
-		func(req *http.Request) bool {
-			if !v2PostSessionChatPathMatcher(req) {
-				return false
+	v2PostSessionChat, v2PostSessionChatStream := v2PostSessionChatHandlers(sc.RevisionNone, "chat")
+	v2PostSessionChatRegenerate, v2PostSessionChatRegenerateStream := v2PostSessionChatHandlers(
+		sc.RevisionRegenerate,
+		"chat", "regenerate",
+	)
+	v2PostSessionChatEdit, v2PostSessionChatEditStream := v2PostSessionChatHandlers(sc.RevisionEdit, "chat", "edit")
+	v2PostSessionChatContinue, v2PostSessionChatContinueStream := v2PostSessionChatHandlers(
+		sc.RevisionContinue,
+		"chat", "continue",
+	)
+
+	v2PostSessionChatEstimateMatcher, v2PostSessionChatEstimateSubParser := wf.ResourceWithIDs(
+		http.MethodPost,
+		[]string{"v2", "users", "", "sessions", "", "chat", "estimate"},
+	)
+	v2PostSessionChatEstimate := wf.NewClosureHandler(
+		v2PostSessionChatEstimateMatcher,
+		func(data []byte, path string) (req any, err error) {
+			raw, err := v2PostSessionChatEstimateSubParser(nil, path)
+			if err != nil {
+				return nil, err
 			}
-			return req.URL.Query().Get("stream") != "true"
+			ids := raw.([]int)
+			request := &sc.Request{
+				UserID:          ids[0],
+				SessionScopedID: ids[1],
+				RequestPayload:  sc.RequestPayload{},
+			}
+			if err := json.Unmarshal(data, &request.RequestPayload); err != nil {
+				return nil, err
+			}
+			return request, nil
 		},
-		v2PostSessionChatParser,
 		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
-			return ret.chatService.Chat(ctx, req.(*sc.Request))
+			return ret.chatService.Estimate(ctx, req.(*sc.Request))
 		},
 		json.Marshal,
 		wf.JSONContentType,
 	)
-	v2PostSessionChat.Timeout = chatTimeout()
-	v2PostSessionChatStream := wf.NewServerSentEventsHandler(
-		wf.MatchAll(v2PostSessionChatPathMatcher, wf.HasQuery("stream", "true")),
-		v2PostSessionChatParser,
-		func(ctx context.Context, req any) (ch <-chan wf.MessageEvent, codedError *wf.CodedError) {
-			return ret.chatService.ChatStream(ctx, req.(*sc.Request))
+
+	v2PostSessionChatJobMatcher, v2PostSessionChatJobSubParser := wf.ResourceWithIDs(
+		http.MethodPost,
+		[]string{"v2", "users", "", "sessions", "", "chat", "jobs"},
+	)
+	v2PostSessionChatJob := wf.NewClosureHandler(
+		v2PostSessionChatJobMatcher,
+		func(data []byte, path string) (req any, err error) {
+			raw, err := v2PostSessionChatJobSubParser(nil, path)
+			if err != nil {
+				return nil, err
+			}
+			ids := raw.([]int)
+			request := &sj.Request{
+				UserID:          ids[0],
+				SessionScopedID: ids[1],
+				Payload:         sj.Payload{},
+			}
+			if err := json.Unmarshal(data, &request.Payload); err != nil {
+				return nil, err
+			}
+			return request, nil
+		},
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			return ret.jobService.Submit(ctx, req.(*sj.Request))
+		},
+		json.Marshal,
+		wf.JSONContentType,
+	)
+
+	v2GetJobMatcher, v2GetJobParser := wf.ResourceWithIDs(
+		http.MethodGet,
+		[]string{"v2", "users", "", "jobs", ""},
+	)
+	v2GetJob := wf.NewClosureHandler(
+		v2GetJobMatcher,
+		v2GetJobParser,
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			ids := req.([]int)
+			return ret.jobService.Find(ctx, ids[0], ids[1])
 		},
+		json.Marshal,
+		wf.JSONContentType,
 	)
-	v2PostSessionChatStream.Timeout = chatTimeout()
 
 	v1GetBuildInfo := wf.NewJSONHandler(
 		wf.Exact(http.MethodGet, "/v1/build-info"),
@@ -213,6 +544,20 @@ func New(
 			return ret.buildInfo, nil
 		})
 
+	v1GetModels := wf.NewJSONHandler(
+		wf.Exact(http.MethodGet, "/v1/models"),
+		reflect.TypeFor[wf.Empty](),
+		func(ctx context.Context, _ any) (rsp any, codedError *wf.CodedError) {
+			return providers.Models(), nil
+		})
+
+	v1GetModelsLoad := wf.NewJSONHandler(
+		wf.Exact(http.MethodGet, "/v1/models/load"),
+		reflect.TypeFor[wf.Empty](),
+		func(ctx context.Context, _ any) (rsp any, codedError *wf.CodedError) {
+			return providers.Load(), nil
+		})
+
 	v1CleanEmpty := wf.NewClosureHandler(
 		wf.Exact(http.MethodPost, "/v1/clean-empty"),
 		func(data []byte, path string) (req any, err error) {
@@ -226,6 +571,83 @@ func New(
 		http.DetectContentType(nil),
 	)
 
+	v1BudgetPathSuffix := "/budget"
+	v1GetUserBudget := wf.NewClosureHandler(
+		wf.ResourceWithID(http.MethodGet, "/v1/users/", v1BudgetPathSuffix),
+		wf.PathIDParser(v1BudgetPathSuffix),
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			return ret.budgetService.Find(ctx, req.(int))
+		},
+		json.Marshal,
+		wf.JSONContentType,
+	)
+	v1PutUserBudgetPathIDParser := wf.PathIDParser(v1BudgetPathSuffix)
+	v1PutUserBudget := wf.NewClosureHandler(
+		wf.ResourceWithID(http.MethodPut, "/v1/users/", v1BudgetPathSuffix),
+		func(data []byte, path string) (req any, err error) {
+			id, err := v1PutUserBudgetPathIDParser(nil, path)
+			if err != nil {
+				return nil, err
+			}
+			item := &model.Budget{}
+			if err := json.Unmarshal(data, item); err != nil {
+				return nil, err
+			}
+			item.UserID = id.(int)
+			return item, nil
+		},
+		func(ctx context.Context, req any) (rsp any, codedError *wf.CodedError) {
+			return ret.budgetService.Update(ctx, req.(*model.Budget))
+		},
+		json.Marshal,
+		wf.JSONContentType,
+	)
+
+	// usageHandlers returns handlers of the usage report in JSON and CSV, which the query parameter format tells.
+	// The user to report is parsed from the path by parse, nil for all users.
+	usageHandlers := func(matcher wf.MatchFunc, parse func(path string) (*int, error)) (plain wf.Handler, csv wf.Handler) {
+		parser := func(data []byte, path string) (req any, err error) {
+			return parse(path)
+		}
+		handle := func(ctx context.Context, userID any) (rsp any, codedError *wf.CodedError) {
+			req, e := sr.NewRequest(userID.(*int), query(ctx))
+			if e != nil {
+				return nil, e
+			}
+			return ret.reportService.Find(ctx, req)
+		}
+		plain = wf.NewClosureHandler(
+			func(req *http.Request) bool {
+				return matcher(req) && req.URL.Query().Get("format") != "csv"
+			},
+			parser, handle, json.Marshal, wf.JSONContentType,
+		)
+		csv = wf.NewClosureHandler(
+			func(req *http.Request) bool {
+				return matcher(req) && req.URL.Query().Get("format") == "csv"
+			},
+			parser, handle, sr.MarshalCSV, sr.CSVContentType,
+		)
+		return plain, csv
+	}
+	v1GetUsage, v1GetUsageCSV := usageHandlers(
+		wf.Exact(http.MethodGet, "/v1/usage"),
+		func(string) (*int, error) { return nil, nil },
+	)
+	v2UsagePathSuffix := "/usage"
+	v2UsagePathIDParser := wf.PathIDParser(v2UsagePathSuffix)
+	v2GetUsage, v2GetUsageCSV := usageHandlers(
+		wf.ResourceWithID(http.MethodGet, "/v2/users/", v2UsagePathSuffix),
+		func(path string) (*int, error) {
+			id, err := v2UsagePathIDParser(nil, path)
+			if err != nil {
+				return nil, err
+			}
+			userID := id.(int)
+			return &userID, nil
+		},
+	)
+
 	v1PostSessionNameGeneratePathSuffix := "/name/generate"
 	v1PostSessionNameGenerate := wf.NewClosureHandler(
 		wf.ResourceWithID(http.MethodPost, "/v1/sessions/", v1PostSessionNameGeneratePathSuffix),
@@ -274,11 +696,38 @@ func New(
 		v1PostSessionChatStream,
 		v2GetSessions,
 		v2PostSession,
+		v2PutSessionSystemPrompt,
+		v2PutSessionActiveChat,
+		v2PostSessionFork,
+		v2GetSessionBranches,
+		v2GetSearch,
+		v2GetSessionChatStream,
+		v2PostSessionChatCancel,
+		v2GetPresets,
+		v2PutPreset,
+		v2DeletePreset,
 		v2GetSession,
 		v2PostSessionChat,
 		v2PostSessionChatStream,
+		v2PostSessionChatRegenerate,
+		v2PostSessionChatRegenerateStream,
+		v2PostSessionChatEdit,
+		v2PostSessionChatEditStream,
+		v2PostSessionChatContinue,
+		v2PostSessionChatContinueStream,
+		v2PostSessionChatEstimate,
+		v2PostSessionChatJob,
+		v2GetJob,
 		v1GetBuildInfo,
+		v1GetModels,
+		v1GetModelsLoad,
 		v1CleanEmpty,
+		v1GetUserBudget,
+		v1PutUserBudget,
+		v1GetUsage,
+		v1GetUsageCSV,
+		v2GetUsage,
+		v2GetUsageCSV,
 		v1PostSessionNameGenerate,
 		v2PostSessionNameGenerate,
 	)
@@ -181,3 +181,18 @@ func (c *V1Client) GenerateSessionName(cmd string) (null map[int]string, err err
 	_, err = Fetch(req)
 	return nil, err
 }
+
+// SwitchActiveChat is unsupported, as v1 has no endpoint for it.
+func (c *V1Client) SwitchActiveChat(_ int, _ int) error {
+	return fmt.Errorf("SwitchActiveChat %w", errors.ErrUnsupported)
+}
+
+// Search is unsupported, as v1 has no endpoint for it.
+func (c *V1Client) Search(_ string) ([]SearchMatch, error) {
+	return nil, fmt.Errorf("Search %w", errors.ErrUnsupported)
+}
+
+// CancelChat is unsupported, as v1 has no endpoint for it.
+func (c *V1Client) CancelChat(_ int, _ int) error {
+	return fmt.Errorf("CancelChat %w", errors.ErrUnsupported)
+}
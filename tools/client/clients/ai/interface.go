@@ -21,6 +21,23 @@ type Client interface {
 	GetVersion() (version *debug.BuildInfo, err error)
 	GetSession(id int) (model.Session, error)
 	GenerateSessionName(cmd string) (scopedIDToNeoNameNullable map[int]string, err error)
+	// SwitchActiveChat makes the next chat of the session follow the chat on chatID.
+	SwitchActiveChat(sessionID int, chatID int) error
+	// CancelChat aborts the generation of the chat on chatID, whose partial answer is kept.
+	CancelChat(sessionID int, chatID int) error
+	// Search finds sessions with chats matching all space separated terms in text, the most relevant first.
+	Search(text string) ([]SearchMatch, error)
+}
+
+// SearchMatch is a session found by [Client.Search], with snippets of chats matching, marked by ** around.
+type SearchMatch struct {
+	ScopedID int
+	Name     string
+	Hits     []struct {
+		ChatID  int
+		Source  string // input or content
+		Snippet string
+	}
 }
 
 // Session flats the difference between its implements [v1Session] and [v2Session],
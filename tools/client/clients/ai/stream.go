@@ -11,6 +11,7 @@ import (
 	"io"
 	"log"
 	"strings"
+	"time"
 )
 
 func transform(body io.ReadCloser, output chan<- string) {
@@ -23,13 +24,13 @@ func transform(body io.ReadCloser, output chan<- string) {
 	// See https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation.
 	// Differences (no difference as my server doesn't use them)
 	// - Assume there is always a nice space after `:`.
-	// - Field name "id" and "retry" are not supported.
+	// - Field name "id" is ignored as streams are not resumed here, "retry" is not supported.
 	scanner := bufio.NewScanner(body)
 	eventType := ""
 	var data string
 	for scanner.Scan() {
 		line := scanner.Text()
-		if strings.HasPrefix(line, ":") {
+		if strings.HasPrefix(line, ":") || strings.HasPrefix(line, "id: ") {
 			continue
 		}
 		if value, ok := strings.CutPrefix(line, "event: "); ok {
@@ -61,6 +62,8 @@ func transform(body io.ReadCloser, output chan<- string) {
 
 func message(eventType string, data string) (word string) {
 	switch eventType {
+	case "chat":
+		return ""
 	case "head":
 		return data + "\n"
 	case "role":
@@ -75,6 +78,16 @@ func message(eventType string, data string) (word string) {
 		return data + "\n" + CostMessage(data) + "\n"
 	case "error":
 		return fmt.Sprintf("\nserver error: %s\n", data)
+	case "toolCall":
+		return fmt.Sprintf("\ntool call: %s\n", data)
+	case "toolResult":
+		return fmt.Sprintf("tool result: %s\n", data)
+	case "formatInvalid":
+		return fmt.Sprintf("\nformat invalid, repairing: %s\n", data)
+	case "partial":
+		return fmt.Sprintf("\nanswer kept as partial: %s\n", data)
+	case "cancelled":
+		return fmt.Sprintf("chat %s cancelled\n", data)
 	}
 	log.Fatal(fmt.Errorf("message of eventType %s: %w", eventType, errors.ErrUnsupported))
 	return "unreachable"
@@ -85,5 +98,9 @@ func CostMessage(data string) string {
 	if err := json.Unmarshal([]byte(data), &usage); err != nil {
 		return fmt.Sprintf("err: %v", err)
 	}
-	return "estimated cost " + pricer.PriceOrDefault(openai.ChatModelDeepSeekV4Pro).Cost(pricer.OpenAIUsage(usage))
+	price, err := pricer.Default().Price(openai.ChatModelDeepSeekV4Pro, time.Now())
+	if err != nil {
+		return err.Error()
+	}
+	return "estimated cost " + price.Cost(pricer.OpenAIUsage(usage))
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"runtime/debug"
 	"strings"
 )
@@ -131,3 +132,44 @@ func (c *V2Client) GenerateSessionName(cmd string) (scopedIDToNeoName map[int]st
 
 	return FetchAndParseJSON[map[int]string](req)
 }
+
+func (c *V2Client) SwitchActiveChat(sessionScopedID int, chatID int) error {
+	req, err := http.NewRequest(
+		http.MethodPut,
+		fmt.Sprintf("%s/v2/users/%d/sessions/%d/chats/%d/active", c.endpoint, c.userID, sessionScopedID, chatID),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	c.AttachToken(req)
+	_, err = Fetch(req)
+	return err
+}
+
+func (c *V2Client) CancelChat(sessionScopedID int, chatID int) error {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/v2/users/%d/sessions/%d/chats/%d/cancel", c.endpoint, c.userID, sessionScopedID, chatID),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	c.AttachToken(req)
+	_, err = Fetch(req)
+	return err
+}
+
+func (c *V2Client) Search(text string) ([]SearchMatch, error) {
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/v2/users/%d/search?q=%s", c.endpoint, c.userID, url.QueryEscape(text)),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.AttachToken(req)
+	return FetchAndParseJSON[[]SearchMatch](req)
+}
@@ -1,18 +1,22 @@
 package ui
 
 import (
+	"aiagent/clients/model"
 	"aiagent/console"
 	"aiagent/helpers/pricer"
 	"aiagent/tools/client/clients/ai"
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"runtime/debug"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/text/currency"
 	"golang.org/x/text/width"
 )
 
@@ -140,6 +144,46 @@ func (h *Handler) HandleInput(content string) {
 		}
 	}
 
+	if content == ":tree" {
+		if !h.initialized {
+			fmt.Println("no session to show its tree")
+			return
+		}
+		session, err := h.client.GetSession(h.sessionID)
+		if err != nil {
+			fmt.Printf("Get Session %d failed: %v\n", h.sessionID, err)
+			return
+		}
+		PrintChatTree(session)
+		return
+	}
+
+	if arg, ok := strings.CutPrefix(content, ":checkout "); ok {
+		chatID, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil || !h.initialized {
+			fmt.Println(`Type ":checkout 4" to continue after chat 4 of the session, see IDs by ":tree"`)
+			return
+		}
+		if err := h.client.SwitchActiveChat(h.sessionID, chatID); err != nil {
+			fmt.Printf("Checkout chat %d failed: %v\n", chatID, err)
+			return
+		}
+		fmt.Printf("the next input follows chat %d\n", chatID)
+		return
+	}
+
+	if text, ok := strings.CutPrefix(content, ":search "); ok {
+		matches, err := tryLoginOnceIfForbidden(h, func(c ai.Client) ([]ai.SearchMatch, error) {
+			return c.Search(text)
+		})
+		if err != nil {
+			fmt.Printf("Search [%s] failed: %v\n", text, err)
+			return
+		}
+		PrintSearchMatches(matches)
+		return
+	}
+
 	cmd, ok := strings.CutPrefix(content, ":gn ")
 	if ok {
 		scopedIDToNeoNameNullable, err := h.client.GenerateSessionName(cmd)
@@ -183,16 +227,28 @@ Type "%s 4" to continue session ID 4\n`, initLinePrefix, initLinePrefix)
 				return
 			}
 			fmt.Printf("session name = %s\n", session.Name)
-			for i, chat := range session.Chats {
+			for i, chat := range session.Path() {
 				// SoftWrap not supported as a glance of history is enough, and it's non-trivial to implement.
-				fmt.Printf("| #%4d %s\n", i, localShortDateTime(chat.CreateTime))
+				fmt.Printf("| #%4d %s chat %d\n", i, localShortDateTime(chat.CreateTime), chat.ID)
 				PrintWithPrefix("  ", chat.Input)
-				fmt.Printf("| model = %s FinishReason = %s\n", chat.Result.Model, chat.Result.FinishReason)
+				for _, step := range chat.Steps {
+					for _, call := range step.ToolCalls {
+						fmt.Printf("| tool call %s(%s)\n", call.Function.Name, call.Function.Arguments)
+					}
+					if step.ToolCallID != "" {
+						fmt.Printf("| tool result of %s\n", step.ToolCallID)
+						PrintWithPrefix("  ", step.Content)
+					}
+				}
+				fmt.Printf("| model = %s FinishReason = %s Status = %s\n",
+					chat.Result.Model, chat.Result.FinishReason, chat.Result.Status)
 				PrintWithPrefix("  ", chat.Result.ReasoningContent)
 				PrintWithPrefix("  ", console.COTEndMessage())
 				PrintWithPrefix("  ", chat.Result.Content)
-				usage := pricer.OpenAIUsage(chat.Result.ChatCompletion().Usage)
-				fmt.Printf("| %s\n\n", pricer.PriceOrDefault(chat.Result.Model).Cost(usage))
+				fmt.Printf("| %s\n\n", cost(chat.Result))
+			}
+			if branches := len(session.Leaves()); branches > 1 {
+				fmt.Printf("the active one of %d branches shown, type \":tree\" to see all\n", branches)
 			}
 		}
 		h.initialized = true
@@ -203,9 +259,94 @@ Type "%s 4" to continue session ID 4\n`, initLinePrefix, initLinePrefix)
 	if err != nil {
 		log.Fatal(err)
 	}
+	stop := h.cancelOnInterrupt()
+	defer stop()
 	printWithSoftWrap(h.swo, words)
 }
 
+// cancelOnInterrupt makes Ctrl-C cancel the generation of the session rather than quit, until stop is called.
+// The rest of the stream is still printed, which ends with the cancellation.
+func (h *Handler) cancelOnInterrupt() (stop func()) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-interrupt:
+				h.cancelActiveChat()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(interrupt)
+		close(done)
+	}
+}
+
+// cancelActiveChat cancels the generation of the active chat of the session, which is the one just started.
+func (h *Handler) cancelActiveChat() {
+	session, err := h.client.GetSession(h.sessionID)
+	if err != nil {
+		fmt.Printf("\nGet Session %d failed: %v\n", h.sessionID, err)
+		return
+	}
+	if session.ActiveChatID == nil {
+		fmt.Println("\nno chat to cancel")
+		return
+	}
+	if err := h.client.CancelChat(h.sessionID, *session.ActiveChatID); err != nil {
+		fmt.Printf("\nCancel chat %d failed: %v\n", *session.ActiveChatID, err)
+	}
+}
+
+// PrintChatTree prints chats of session indented by depth, with those on the active path starred.
+func PrintChatTree(session model.Session) {
+	children := make(map[int][]*model.Chat) // by ID of parent, 0 for the first turns as IDs start from 1
+	for _, chat := range session.Chats {
+		var parentID int
+		if chat.ParentID != nil {
+			parentID = *chat.ParentID
+		}
+		children[parentID] = append(children[parentID], chat)
+	}
+	active := make(map[int]bool)
+	for _, chat := range session.Path() {
+		active[chat.ID] = true
+	}
+
+	var walk func(parentID int, depth int)
+	walk = func(parentID int, depth int) {
+		for _, chat := range children[parentID] {
+			marker := " "
+			if active[chat.ID] {
+				marker = "*"
+			}
+			input, _, _ := strings.Cut(chat.Input, "\n")
+			fmt.Printf("%s%s%4d %s\n", marker, strings.Repeat("  ", depth), chat.ID, input)
+			walk(chat.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+}
+
+func PrintSearchMatches(matches []ai.SearchMatch) {
+	if len(matches) == 0 {
+		fmt.Println("nothing found")
+		return
+	}
+	for _, m := range matches {
+		fmt.Printf("%4d\t%s\n", m.ScopedID, m.Name)
+		for _, hit := range m.Hits {
+			fmt.Printf("| chat %d %s\n", hit.ChatID, hit.Source)
+			PrintWithPrefix("  ", hit.Snippet)
+		}
+	}
+	fmt.Printf("Type \"%s 4\" to continue session ID 4, then \":checkout 7\" to follow chat 7 found\n", initLinePrefix)
+}
+
 func PrintWithPrefix(linePrefix string, multiLine string) {
 	for line := range strings.SplitSeq(multiLine, "\n") {
 		fmt.Print(linePrefix)
@@ -274,3 +415,17 @@ func LooksWide(r rune) bool {
 		return true
 	}
 }
+
+// cost formats what result is charged as stored, which is priced by built-in prices for those not stored.
+func cost(result *model.Result) string {
+	if result.CostNano != nil {
+		if unit, err := currency.ParseISO(result.CostUnit); err == nil {
+			return pricer.FormatNano(*result.CostNano, unit)
+		}
+	}
+	price, err := pricer.Default().Price(result.PricedModel(), time.Unix(result.Created, 0))
+	if err != nil {
+		return "unpriced"
+	}
+	return price.Cost(pricer.OpenAIUsage(result.ChatCompletion().Usage))
+}